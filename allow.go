@@ -0,0 +1,154 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	allowDevs   devList
+	excludeDevs devList
+)
+
+func init() {
+	flag.Var(&allowDevs, "allow", "block device (e.g. /dev/sdb or a /dev/disk/by-id path) permitted to be grown; repeatable or comma-separated, or @file to read one per line. If empty, any device may be grown.")
+	flag.Var(&excludeDevs, "exclude", "block device never to modify; repeatable or comma-separated, or @file to read one per line. Takes precedence over --allow.")
+}
+
+// devList is a flag.Value holding a list of block devices.
+type devList []string
+
+func (l *devList) String() string { return strings.Join(*l, ",") }
+
+func (l *devList) Set(v string) error {
+	if !strings.HasPrefix(v, "@") {
+		*l = append(*l, strings.Split(v, ",")...)
+		return nil
+	}
+	all, err := ioutil.ReadFile(v[1:])
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(all), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		*l = append(*l, line)
+	}
+	return nil
+}
+
+// contains reports whether dev, or the disk it's a partition of,
+// is in l. Symlinks such as /dev/disk/by-id paths are resolved first.
+func (l devList) contains(dev string) bool {
+	want := []string{canonicalDev(dev)}
+	if isPartitionDevName(dev) {
+		if disk, err := diskDev(dev); err == nil {
+			want = append(want, canonicalDev(disk))
+		}
+	}
+	for _, v := range l {
+		v = canonicalDev(v)
+		for _, w := range want {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canonicalDev returns dev with any symlinks resolved, or
+// just cleaned if it can't be resolved. A symlink to a device node
+// that's missing, as udev's can briefly be, resolves to its target.
+func canonicalDev(dev string) string {
+	if p, err := filepath.EvalSymlinks(dev); err == nil {
+		return p
+	}
+	if t, err := os.Readlink(dev); err == nil {
+		if !filepath.IsAbs(t) {
+			t = filepath.Join(filepath.Dir(dev), t)
+		}
+		return filepath.Clean(t)
+	}
+	return filepath.Clean(dev)
+}
+
+// resizerDevs returns the block devices that r operates on.
+func resizerDevs(r Resizer) []string {
+	switch r := r.(type) {
+	case fsResizer:
+		return []string{r.fs.dev}
+	case lvResizer:
+		return []string{string(r)}
+	case pvResizer:
+		return []string{string(r)}
+	case vdoResizer:
+		return []string{string(r)}
+	case cryptResizer:
+		return []string{string(r)}
+	case partitionResizer:
+		if !isPartitionDevName(string(r)) {
+			return []string{string(r)} // e.g. on md or dm; diskDev can't map it
+		}
+		disk, err := diskDev(string(r))
+		if err != nil {
+			return []string{string(r)}
+		}
+		return []string{string(r), disk}
+	}
+	return nil
+}
+
+// checkAllowed returns an error if resizing e would modify a device
+// that the --exclude flag forbids, or, if --allow is set, whose
+// underlying device isn't on the --allow list.
+func checkAllowed(e Resizer) error {
+	var bottom []string // devices of the lowest Resizer in the chain
+	for r := e; r != nil; {
+		devs := resizerDevs(r)
+		for _, dev := range devs {
+			if excludeDevs.contains(dev) {
+				return fmt.Errorf("%v: device %s is excluded by --exclude", r, dev)
+			}
+		}
+		if len(devs) > 0 {
+			bottom = devs
+		}
+		dep, err := r.DepResizer()
+		if err != nil {
+			return err
+		}
+		r = dep
+	}
+	if len(allowDevs) == 0 {
+		return nil
+	}
+	for _, dev := range bottom {
+		if allowDevs.contains(dev) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v is backed by %s, which is not permitted by --allow", e, strings.Join(bottom, ", "))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckAllowed(t *testing.T) {
+	defer func(a, e devList) { allowDevs, excludeDevs = a, e }(allowDevs, excludeDevs)
+
+	// An ext4 filesystem directly on /dev/sdb1, explicitly requested.
+	e := fsResizer{fs: fsStat{mnt: "/data", dev: "/dev/sdb1", fstype: "ext4"}}
+	tests := []struct {
+		name    string
+		allow   devList
+		exclude devList
+		wantErr bool
+	}{
+		{name: "no_lists"},
+		{name: "disk_allowed", allow: devList{"/dev/sdb"}},
+		{name: "partition_allowed", allow: devList{"/dev/sdb1"}},
+		{name: "explicit_but_not_allowed", allow: devList{"/dev/sdc"}, wantErr: true},
+		{name: "excluded", exclude: devList{"/dev/sdb"}, wantErr: true},
+		{name: "exclude_wins", allow: devList{"/dev/sdb"}, exclude: devList{"/dev/sdb1"}, wantErr: true},
+		{name: "other_excluded", exclude: devList{"/dev/sda"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowDevs, excludeDevs = tt.allow, tt.exclude
+			err := checkAllowed(e)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkAllowed = %v; want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDevListSet(t *testing.T) {
+	td, err := ioutil.TempDir("", "embiggen-allow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	file := filepath.Join(td, "allow")
+	if err := ioutil.WriteFile(file, []byte("# data disks\n/dev/sdb\n\n/dev/sdc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var l devList
+	for _, v := range []string{"/dev/vdb", "@" + file} {
+		if err := l.Set(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := l.String(), "/dev/vdb,/dev/sdb,/dev/sdc"; got != want {
+		t.Errorf("list = %q; want %q", got, want)
+	}
+
+	// A by-id symlink matches the device it points to.
+	link := filepath.Join(td, "by-id-disk")
+	if err := os.Symlink(td, link); err != nil {
+		t.Fatal(err)
+	}
+	if !(devList{link}).contains(td) {
+		t.Errorf("symlink %s didn't match its target", link)
+	}
+}
+
+func TestResizerDevsNonPartition(t *testing.T) {
+	// A PV on an md array ends in a number but isn't on a disk
+	// diskDev understands; it must not panic.
+	got := resizerDevs(partitionResizer("/dev/md0"))
+	if want := []string{"/dev/md0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resizerDevs = %q; want %q", got, want)
+	}
+	got = resizerDevs(partitionResizer("/dev/nvme0n1p2"))
+	if want := []string{"/dev/nvme0n1p2", "/dev/nvme0n1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resizerDevs = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sysfsDir is where sysfs is mounted.
+var sysfsDir = "/sys"
+
+// errNoSysfs is returned by checkSysfs when sysfs isn't mounted, as in
+// some restricted containers.
+var errNoSysfs = errors.New("sysfs not available; embiggen-disk requires a Linux host with sysfs mounted at /sys (or see --sysfs-root)")
+
+// checkSysfs returns errNoSysfs if there's no sysfs to look at block
+// devices with.
+func checkSysfs() error {
+	if fi, err := os.Stat(filepath.Join(sysfsDir, "block")); err != nil || !fi.IsDir() {
+		return errNoSysfs
+	}
+	return nil
+}
+
+// sysBlockName returns the kernel's name for the block device dev:
+// "sda3" for "/dev/sda3", or "dm-1" for "/dev/mapper/vg-root".
+func sysBlockName(dev string) (string, error) {
+	if strings.HasPrefix(dev, "/dev/mapper/") {
+		want := strings.TrimPrefix(dev, "/dev/mapper/")
+		names, _ := filepath.Glob(filepath.Join(sysfsDir, "block", "dm-*", "dm", "name"))
+		for _, f := range names {
+			if name, err := ioutil.ReadFile(f); err == nil && strings.TrimSpace(string(name)) == want {
+				return filepath.Base(filepath.Dir(filepath.Dir(f))), nil
+			}
+		}
+		return "", fmt.Errorf("device-mapper device %s not found in sysfs", dev)
+	}
+	return filepath.Base(canonicalDev(dev)), nil
+}
+
+// blockName is sysBlockName, falling back to dev's base name if it's
+// a device-mapper device sysfs doesn't know.
+func blockName(dev string) string {
+	if name, err := sysBlockName(dev); err == nil {
+		return name
+	}
+	return filepath.Base(dev)
+}
+
+// backingDisks returns the whole disks (e.g. "/dev/sda") that the
+// block device dev is ultimately stored on, looking through
+// partitions and device-mapper layers such as LVM and dm-crypt.
+func backingDisks(dev string) ([]string, error) {
+	name, err := sysBlockName(dev)
+	if err != nil {
+		return nil, err
+	}
+	return backingDisksOf(name, 0)
+}
+
+func backingDisksOf(name string, depth int) ([]string, error) {
+	if depth > 16 {
+		return nil, errors.New("block device stack too deep")
+	}
+	classDir := filepath.Join(sysfsDir, "class", "block", name)
+	if _, err := os.Stat(classDir); err != nil {
+		return nil, fmt.Errorf("block device %s not found in sysfs", name)
+	}
+	if _, err := os.Stat(filepath.Join(classDir, "partition")); err == nil {
+		// A partition's sysfs directory is within its disk's.
+		p, err := filepath.EvalSymlinks(classDir)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"/dev/" + filepath.Base(filepath.Dir(p))}, nil
+	}
+	slaves, _ := ioutil.ReadDir(filepath.Join(classDir, "slaves"))
+	if len(slaves) == 0 {
+		return []string{"/dev/" + name}, nil
+	}
+	var disks []string
+	for _, fi := range slaves {
+		d, err := backingDisksOf(fi.Name(), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		disks = append(disks, d...)
+	}
+	return disks, nil
+}
+
+// diskForMount returns the single local disk backing the filesystem
+// mounted at mnt, according to mounts.
+func diskForMount(mounts []mountInfo, mnt string) (string, error) {
+	var m *mountInfo
+	for i := range mounts {
+		if mounts[i].mnt == mnt && mounts[i].dev != "rootfs" {
+			m = &mounts[i]
+		}
+	}
+	if m == nil {
+		return "", fmt.Errorf("%s is not mounted", mnt)
+	}
+	dev := m.dev
+	if dev == "/dev/root" {
+		var err error
+		if dev, err = m.devRoot(); err != nil {
+			return "", fmt.Errorf("failed to map /dev/root to real device: %v", err)
+		}
+	}
+	if !strings.HasPrefix(dev, "/dev/") {
+		return "", fmt.Errorf("%s is on %s, not a local block device", mnt, dev)
+	}
+	disks, err := backingDisks(dev)
+	if err != nil {
+		return "", err
+	}
+	if len(disks) != 1 {
+		return "", fmt.Errorf("%s is on %s, which spans %d disks: %s", mnt, dev, len(disks), strings.Join(disks, ", "))
+	}
+	return disks[0], nil
+}
+
+// byPartUUIDDir is where udev makes a symlink to each partition
+// named by its PARTUUID.
+var byPartUUIDDir = "/dev/disk/by-partuuid"
+
+// movedDevs maps device paths to their new paths, for partitions
+// whose device node changed when the partition table was rewritten.
+var movedDevs = map[string]string{}
+
+// currentDev returns the path dev is now known by: dev itself unless
+// it moved after being resized.
+func currentDev(dev string) string {
+	if d, ok := movedDevs[dev]; ok {
+		return d
+	}
+	return dev
+}
+
+// reresolvePartition finds the current device node of the partition
+// that was at dev before its partition table was rewritten, by its
+// PARTUUID, and records it in movedDevs if it changed. Without udev
+// (in some containers and initramfs) there's no byPartUUIDDir, so dev
+// is assumed not to have moved.
+func reresolvePartition(dev, partUUID string) error {
+	if partUUID == "" {
+		return nil
+	}
+	if _, err := os.Stat(byPartUUIDDir); os.IsNotExist(err) {
+		warnf("can't check whether partition %s moved: no %s (is udev running?); assuming it didn't", dev, byPartUUIDDir)
+		return nil
+	}
+	now, err := filepath.EvalSymlinks(filepath.Join(byPartUUIDDir, strings.ToLower(partUUID)))
+	if err != nil {
+		return fmt.Errorf("finding partition %s (PARTUUID=%s) after resizing it: %v", dev, partUUID, err)
+	}
+	if now != dev && now != canonicalDev(dev) {
+		warnf("partition %s (PARTUUID=%s) is now %s", dev, partUUID, now)
+		movedDevs[dev] = now
+	}
+	return nil
+}
+
+// unusedSectors returns how many 512-byte sectors of space there are
+// to grow into below something of used sectors stored on dev: the
+// rest of dev, plus, if dev is a disk's last partition, the disk's
+// unpartitioned space after it.
+func unusedSectors(dev string, used int64) (int64, error) {
+	name, err := sysBlockName(dev)
+	if err != nil {
+		return 0, err
+	}
+	classDir := filepath.Join(sysfsDir, "class", "block", name)
+	size, err := readInt64File(filepath.Join(classDir, "size"))
+	if err != nil {
+		return 0, err
+	}
+	unused := size - used
+	if _, err := os.Stat(filepath.Join(classDir, "partition")); err != nil {
+		return unused, nil // a whole disk
+	}
+	p, err := filepath.EvalSymlinks(classDir)
+	if err != nil {
+		return 0, err
+	}
+	diskDir := filepath.Dir(p)
+	diskSize, err := readInt64File(filepath.Join(diskDir, "size"))
+	if err != nil {
+		return 0, err
+	}
+	ends := map[string]int64{}
+	var lastEnd int64
+	parts, _ := filepath.Glob(filepath.Join(diskDir, "*", "partition"))
+	for _, f := range parts {
+		dir := filepath.Dir(f)
+		start, err := readInt64File(filepath.Join(dir, "start"))
+		if err != nil {
+			return 0, err
+		}
+		size, err := readInt64File(filepath.Join(dir, "size"))
+		if err != nil {
+			return 0, err
+		}
+		ends[filepath.Base(dir)] = start + size
+		if start+size > lastEnd {
+			lastEnd = start + size
+		}
+	}
+	if ends[name] == lastEnd {
+		unused += diskSize - lastEnd
+	}
+	return unused, nil
+}
+
+// devSizeBytes returns the size of the block device dev in bytes.
+func devSizeBytes(dev string) (int64, error) {
+	name, err := sysBlockName(dev)
+	if err != nil {
+		return 0, err
+	}
+	n, err := readInt64File(filepath.Join(sysfsDir, "class", "block", name, "size"))
+	if err != nil {
+		return 0, err
+	}
+	return sectorBytes(n, 512) // sysfs sizes are always in 512-byte units
+}
+
+// dmUUID returns the device-mapper UUID of dev, such as
+// "LVM-..." or "CRYPT-LUKS2-...", or the empty string if it has none.
+func dmUUID(dev string) string {
+	name, err := sysBlockName(dev)
+	if err != nil {
+		return ""
+	}
+	uuid, err := ioutil.ReadFile(filepath.Join(sysfsDir, "block", name, "dm", "uuid"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(uuid))
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs is a sysfs tree under a temp directory.
+type fakeSysfs struct {
+	t   *testing.T
+	dir string
+}
+
+// newFakeSysfs makes a fake sysfs tree and points sysfsDir at it
+// until the returned cleanup func is called. It also points lockDir at
+// a temp dir, so tests that lock the fake disks don't touch the host.
+func newFakeSysfs(t *testing.T) (s *fakeSysfs, cleanup func()) {
+	td, err := ioutil.TempDir("", "embiggen-sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	locks, err := ioutil.TempDir("", "embiggen-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSys, oldLock := sysfsDir, lockDir
+	sysfsDir, lockDir = td, locks
+	return &fakeSysfs{t, td}, func() {
+		unlockDisks()
+		sysfsDir, lockDir = oldSys, oldLock
+		os.RemoveAll(td)
+		os.RemoveAll(locks)
+	}
+}
+
+// file writes contents to the sysfs file at path.
+func (s *fakeSysfs) file(path, contents string) {
+	s.t.Helper()
+	p := filepath.Join(s.dir, path)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		s.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// disk adds a whole disk of the given size in sectors.
+func (s *fakeSysfs) disk(name, sectors string) {
+	s.t.Helper()
+	s.file("block/"+name+"/size", sectors+"\n")
+	s.link("class/block/"+name, "../../block/"+name)
+}
+
+// part adds a partition of disk.
+func (s *fakeSysfs) part(disk, name, num, sectors string) {
+	s.t.Helper()
+	s.file("block/"+disk+"/"+name+"/partition", num+"\n")
+	s.file("block/"+disk+"/"+name+"/size", sectors+"\n")
+	s.link("class/block/"+name, "../../block/"+disk+"/"+name)
+}
+
+// dm adds a device-mapper device named dmName stacked on slaves.
+func (s *fakeSysfs) dm(name, dmName, uuid string, slaves ...string) {
+	s.t.Helper()
+	s.file("block/"+name+"/dm/name", dmName+"\n")
+	s.file("block/"+name+"/dm/uuid", uuid+"\n")
+	for _, sl := range slaves {
+		s.file("block/"+name+"/slaves/"+sl, "")
+		if _, err := os.Stat(filepath.Join(s.dir, "class/block", sl)); err == nil {
+			s.file("class/block/"+sl+"/holders/"+name, "")
+		}
+	}
+	s.link("class/block/"+name, "../../block/"+name)
+}
+
+func (s *fakeSysfs) link(path, target string) {
+	s.t.Helper()
+	p := filepath.Join(s.dir, path)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		s.t.Fatal(err)
+	}
+	if err := os.Symlink(target, p); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func TestDiskForMountLVMOnCrypt(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "209715200")
+	sys.part("sda", "sda1", "1", "1048576")
+	sys.part("sda", "sda3", "3", "207616000")
+	sys.dm("dm-0", "sda3_crypt", "CRYPT-LUKS2-1234-sda3_crypt", "sda3")
+	sys.dm("dm-1", "vg-root", "LVM-abcd", "dm-0")
+
+	mounts, err := parseMountInfo([]byte(`28 1 253:1 / / rw,relatime shared:1 - ext4 /dev/mapper/vg-root rw
+29 28 8:1 / /boot rw,relatime shared:2 - ext4 /dev/sda1 rw
+30 28 0:40 / /mnt/share rw - 9p share rw
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		mnt, want string
+	}{
+		{"/", "/dev/sda"},
+		{"/boot", "/dev/sda"},
+	} {
+		got, err := diskForMount(mounts, tt.mnt)
+		if err != nil || got != tt.want {
+			t.Errorf("diskForMount(%q) = %q, %v; want %q", tt.mnt, got, err, tt.want)
+		}
+	}
+	if got, err := diskForMount(mounts, "/mnt/share"); err == nil {
+		t.Errorf("diskForMount(9p) = %q; want error", got)
+	}
+}
+
+func TestReresolvePartition(t *testing.T) {
+	defer func(d string) { byPartUUIDDir = d }(byPartUUIDDir)
+	defer func() { movedDevs, warnings = map[string]string{}, nil }()
+	dir, err := ioutil.TempDir("", "by-partuuid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	byPartUUIDDir = dir
+
+	// The partition that was /dev/sda2 came back as dir/sdb2.
+	newDev := filepath.Join(dir, "sdb2")
+	if err := ioutil.WriteFile(newDev, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	const uuid = "D7F261B7-9D9A-4864-AB85-A68ED9CD7CF0"
+	if err := os.Symlink("sdb2", filepath.Join(dir, strings.ToLower(uuid))); err != nil {
+		t.Fatal(err)
+	}
+	if err := reresolvePartition("/dev/sda2", uuid); err != nil {
+		t.Fatal(err)
+	}
+	if got := currentDev("/dev/sda2"); got != newDev {
+		t.Errorf("currentDev(/dev/sda2) = %q; want %q", got, newDev)
+	}
+	if got := currentDev("/dev/sda3"); got != "/dev/sda3" {
+		t.Errorf("currentDev(/dev/sda3) = %q; want unchanged", got)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q; want one about the move", warnings)
+	}
+
+	if err := reresolvePartition("/dev/sda2", "00000000-0000-0000-0000-000000000000"); err == nil {
+		t.Error("unexpected success resolving unknown PARTUUID")
+	}
+	if err := reresolvePartition("/dev/sda4", ""); err != nil {
+		t.Errorf("no PARTUUID: %v; want nil", err)
+	}
+
+	// Without udev there's no by-partuuid directory at all.
+	warnings = nil
+	byPartUUIDDir = filepath.Join(dir, "missing")
+	if err := reresolvePartition("/dev/sda3", uuid); err != nil {
+		t.Errorf("no %s: %v; want nil", byPartUUIDDir, err)
+	}
+	if got := currentDev("/dev/sda3"); got != "/dev/sda3" {
+		t.Errorf("currentDev(/dev/sda3) = %q; want unchanged", got)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q; want one about the missing directory", warnings)
+	}
+}
+
+func TestCheckSysfs(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	if err := checkSysfs(); err != errNoSysfs {
+		t.Errorf("empty sysfs: checkSysfs = %v; want errNoSysfs", err)
+	}
+	if got := errExitCode(errNoSysfs); got != exitNoSysfs {
+		t.Errorf("exit code = %d; want %d", got, exitNoSysfs)
+	}
+	sys.disk("sda", "1000")
+	if err := checkSysfs(); err != nil {
+		t.Errorf("with /sys/block: checkSysfs = %v; want nil", err)
+	}
+}
+
+// TestFixtureRoots runs the read paths that look at sysfs and procfs
+// against fixture trees given by Options.
+func TestFixtureRoots(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "41943040")
+	sys.file("block/sda/queue/logical_block_size", "4096\n")
+	sys.part("sda", "sda1", "1", "41940992")
+	sys.file("dev/block/8:1/uevent", "MAJOR=8\nMINOR=1\nDEVNAME=sda1\nDEVTYPE=partition\n")
+
+	proc, err := ioutil.TempDir("", "embiggen-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(proc)
+	if err := os.MkdirAll(filepath.Join(proc, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mountinfo := "22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n"
+	if err := ioutil.WriteFile(filepath.Join(proc, "self", "mountinfo"), []byte(mountinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(p string) { procDir = p }(procDir)
+	sysfsDir, procDir = "/nonexistent/sys", "/nonexistent/proc"
+	defer Options{SysfsRoot: sys.dir, ProcRoot: proc}.use()()
+
+	mounts, err := readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk, err := diskForMount(mounts, "/"); err != nil || disk != "/dev/sda" {
+		t.Errorf("diskForMount(/) = %q, %v; want /dev/sda", disk, err)
+	}
+	if dev, err := mounts[0].devRoot(); err != nil || dev != "/dev/sda1" {
+		t.Errorf("devRoot = %q, %v; want /dev/sda1", dev, err)
+	}
+	if st, err := partitionResizer("/dev/sda1").State(); err != nil || st != "41940992 sectors" {
+		t.Errorf("partition State = %q, %v", st, err)
+	}
+	pt, err := parsePartitionTable([]byte("label: gpt\nsector-size: 4096\n\n/dev/sda1 : start=256, size=5242624\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := pt.checkedSectorSize("/dev/sda"); err != nil || n != 4096 {
+		t.Errorf("checkedSectorSize = %v, %v; want 4096", n, err)
+	}
+}
+
+func TestDiskForMountDevRoot(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vda", "20971520")
+	sys.part("vda", "vda1", "1", "20969472")
+	sys.file("dev/block/252:1/uevent", "MAJOR=252\nMINOR=1\nDEVNAME=vda1\nDEVTYPE=partition\n")
+
+	mounts, err := parseMountInfo([]byte("17 1 252:1 / / rw,relatime - ext4 /dev/root rw\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if disk, err := diskForMount(mounts, "/"); err != nil || disk != "/dev/vda" {
+		t.Errorf("diskForMount(/) = %q, %v; want /dev/vda", disk, err)
+	}
+}
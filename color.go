@@ -0,0 +1,60 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var noColor = flag.Bool("no-color", false, "don't color the output, even on a terminal")
+
+// ANSI colors for paint.
+const (
+	colorRed    = "31"
+	colorGreen  = "32"
+	colorYellow = "33"
+)
+
+// isTerminal reports whether f is a terminal. It's a variable for tests.
+var isTerminal = func(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// useColor reports whether output to f should be colored: only on a
+// terminal, and never with --no-color or NO_COLOR set.
+func useColor(f *os.File) bool {
+	if *noColor || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// paint returns s in the given color if output to f should be colored,
+// and s unchanged otherwise.
+func paint(f *os.File, color, s string) string {
+	if !useColor(f) {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
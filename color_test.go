@@ -0,0 +1,63 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestNoColorWhenPiped(t *testing.T) {
+	out := captureStdout(t, func() {
+		printResult(embiggen.Result{Changes: []embiggen.Change{{Resizer: "partition /dev/sda3", Before: "1", After: "2"}}})
+	})
+	if !strings.Contains(out, "Changes made:") || strings.Contains(out, "\x1b[") {
+		t.Errorf("output to a pipe = %q; want it uncolored", out)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	defer func(f func(*os.File) bool) { isTerminal = f }(isTerminal)
+	defer func(v bool) { *noColor = v }(*noColor)
+	defer func(v string, ok bool) {
+		if ok {
+			os.Setenv("NO_COLOR", v)
+		} else {
+			os.Unsetenv("NO_COLOR")
+		}
+	}(os.LookupEnv("NO_COLOR"))
+	defer func(v string) { os.Setenv("TERM", v) }(os.Getenv("TERM"))
+	os.Unsetenv("NO_COLOR")
+	os.Setenv("TERM", "xterm")
+
+	isTerminal = func(*os.File) bool { return true }
+	if got := paint(os.Stdout, colorGreen, "ok"); got != "\x1b[32mok\x1b[0m" {
+		t.Errorf("paint on a terminal = %q; want green", got)
+	}
+	*noColor = true
+	if got := paint(os.Stdout, colorGreen, "ok"); got != "ok" {
+		t.Errorf("paint with --no-color = %q; want plain", got)
+	}
+	*noColor = false
+	os.Setenv("NO_COLOR", "")
+	if got := paint(os.Stdout, colorGreen, "ok"); got != "ok" {
+		t.Errorf("paint with NO_COLOR = %q; want plain", got)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cryptResizer is a dm-crypt (LUKS) mapping, such as
+// "/dev/mapper/sda3_crypt", grown with cryptsetup resize to fill the
+// device it's on.
+type cryptResizer string
+
+// name returns the mapping's name, which cryptsetup wants: the base
+// name of its /dev/mapper device, or if named as /dev/dm-N, as sysfs
+// knows it.
+func (r cryptResizer) name() string {
+	base := filepath.Base(string(r))
+	if !strings.HasPrefix(base, "dm-") {
+		return base
+	}
+	name, err := ioutil.ReadFile(filepath.Join(sysfsDir, "block", base, "dm", "name"))
+	if err != nil {
+		return base
+	}
+	return strings.TrimSpace(string(name))
+}
+
+func (r cryptResizer) String() string { return fmt.Sprintf("LUKS volume %s", r.name()) }
+
+func (r cryptResizer) State() (string, error) {
+	n, err := devSizeBytes(string(r))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sectors=%d", n/512), nil
+}
+
+// backingDev returns the device the mapping is on.
+func (r cryptResizer) backingDev() (string, error) {
+	name, err := sysBlockName(string(r))
+	if err != nil {
+		return "", err
+	}
+	slaves, _ := ioutil.ReadDir(filepath.Join(sysfsDir, "block", name, "slaves"))
+	if len(slaves) != 1 {
+		return "", fmt.Errorf("%v is on %d devices; want 1", r, len(slaves))
+	}
+	return "/dev/" + slaves[0].Name(), nil
+}
+
+// DepResizer returns the Resizer for the device the mapping is on:
+// its partition, or nothing for a whole disk.
+func (r cryptResizer) DepResizer() (Resizer, error) {
+	dev, err := r.backingDev()
+	if err != nil {
+		return nil, err
+	}
+	if isPartitionDevName(dev) {
+		explainf("dm-crypt mapping %v is on partition %s, which must grow before it", string(r), dev)
+		return partitionResizer(dev), nil
+	}
+	name := filepath.Base(dev)
+	_, diskErr := os.Stat(filepath.Join(sysfsDir, "block", name))
+	_, dmErr := os.Stat(filepath.Join(sysfsDir, "block", name, "dm"))
+	if diskErr != nil || dmErr == nil || strings.HasPrefix(name, "md") {
+		return nil, fmt.Errorf("don't know how to grow %s, which %v is on", dev, r)
+	}
+	explainf("dm-crypt mapping %v is on the whole disk %s, so there's no partition to grow", string(r), dev)
+	return nil, nil // a whole disk, which the hypervisor grows
+}
+
+func (r cryptResizer) Resize() error {
+	cmd := command("cryptsetup", "resize", r.name())
+	if *dry {
+		skipDryRun(cmd)
+		return nil
+	}
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		// LUKS2 mappings whose key isn't in the kernel keyring need
+		// the passphrase to resize, which we can't give.
+		return fmt.Errorf("running %s: %v, %s", strings.Join(cmd.Args, " "), err, capOutput(cmd.Args, out))
+	}
+	return checkOutput(cmd.Args, out)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestLVMOnLUKSOrder checks the whole stack of the usual encrypted
+// install, ext4 on LVM on LUKS on a partition, is grown bottom up.
+func TestLVMOnLUKSOrder(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	blkpgResizePartition = func(string, sfdiskLine) error { return nil }
+	defer func() { notes, warnings = nil, nil }()
+	td, err := ioutil.TempDir("", "embiggen-luks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	defer func(d string) { lockDir = d }(lockDir)
+	lockDir = td
+	defer unlockDisks()
+	// The filesystem's State needs it to be mounted.
+	if err := os.MkdirAll(filepath.Join(td, "proc", "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mountinfo := "22 1 254:1 / " + td + " rw,relatime shared:1 - ext4 /dev/mapper/vg-root rw\n"
+	if err := ioutil.WriteFile(filepath.Join(td, "proc", "self", "mountinfo"), []byte(mountinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { procDir = p }(procDir)
+	procDir = filepath.Join(td, "proc")
+
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "83886080")
+	sys.file("block/sda/queue/logical_block_size", "512\n")
+	sys.part("sda", "sda3", "3", "41940992")
+	sys.file("block/sda/sda3/start", "2048\n")
+	sys.dm("dm-0", "sda3_crypt", "CRYPT-LUKS2-1234-sda3_crypt", "sda3")
+	sys.file("block/dm-0/size", "41936896\n")
+	sys.dm("dm-1", "vg-root", "LVM-abcd", "dm-0")
+
+	const pv = "/dev/mapper/sda3_crypt"
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "/sbin/sfdisk":
+			if args[1] == "-d" {
+				return []byte("label: dos\ndevice: /dev/sda\nunit: sectors\n\n/dev/sda3 : start=2048, size=41940992, type=83\n"), nil
+			}
+			if args[1] == "--version" {
+				return []byte("sfdisk from util-linux 2.34\n"), nil
+			}
+		case "pvdisplay":
+			return []byte("  " + pv + ":vg:41932800:-1:8:8:-1:4096:5118:0:5118:AAAA\n"), nil
+		case "pvresize":
+			return []byte("  Physical volume \"" + pv + "\" changed\n  1 physical volume(s) resized or updated / 0 physical volume(s) not resized\n"), nil
+		case "lvs":
+			if containsString(args, "lv_path,vg_name,lv_size") {
+				return []byte("  /dev/vg/root:vg:41926656\n"), nil
+			}
+			return []byte("  vg:root::-wi-ao----\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	e := fsResizer{
+		fs:  fsStat{dev: "/dev/mapper/vg-root", mnt: td, fstype: "ext4"},
+		cmd: command("resize2fs", "/dev/mapper/vg-root"),
+	}
+	if _, err := Resize(e); err != nil {
+		t.Fatal(err)
+	}
+
+	var changed [][]string
+	for _, args := range *ran {
+		switch args[0] {
+		case "/sbin/sfdisk":
+			if args[1] != "-f" {
+				continue
+			}
+		case "cryptsetup", "pvresize", "lvextend", "resize2fs":
+		default:
+			continue
+		}
+		changed = append(changed, args)
+	}
+	want := [][]string{
+		{"/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", "/dev/sda"},
+		{"cryptsetup", "resize", "sda3_crypt"},
+		{"pvresize", pv},
+		{"lvextend", "-l", "+100%FREE", "/dev/mapper/vg-root"},
+		{"resize2fs", "/dev/mapper/vg-root"},
+	}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("ran:\n%q\nwant:\n%q", changed, want)
+	}
+}
+
+// TestCryptNameOfDMDev checks that a LUKS PV that LVM names by its
+// dm-N device is resized by its mapping name.
+func TestCryptNameOfDMDev(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.dm("dm-0", "nvme0n1p3_crypt", "CRYPT-LUKS2-1234-nvme0n1p3_crypt", "nvme0n1p3")
+
+	for dev, want := range map[string]string{
+		"/dev/dm-0":                   "nvme0n1p3_crypt",
+		"/dev/mapper/nvme0n1p3_crypt": "nvme0n1p3_crypt",
+		"/dev/dm-7":                   "dm-7", // unknown to sysfs
+	} {
+		if got := cryptResizer(dev).name(); got != want {
+			t.Errorf("cryptResizer(%q).name() = %q; want %q", dev, got, want)
+		}
+	}
+	dep, err := pvResizer("/dev/dm-0").DepResizer()
+	if err != nil || dep != cryptResizer("/dev/dm-0") {
+		t.Errorf("DepResizer of PV /dev/dm-0 = %v, %v; want its LUKS volume", dep, err)
+	}
+}
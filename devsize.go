@@ -0,0 +1,119 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	devSizeSource    = flag.String("dev-size-source", "auto", `where to get a disk's size from: "sysfs" (/sys/block/<disk>/size), "ioctl" (BLKGETSIZE64 on the device), "simulate" (--simulate-disk-size), or "auto": --simulate-disk-size if set, else the ioctl, falling back to sysfs`)
+	simulateDiskSize = flag.String("simulate-disk-size", "", "pretend the disk is this size (e.g. 100G) to see what growing into it would do; implies --dry-run")
+	maxDiskSize      = flag.String("max-disk-size", "", "refuse to grow into a disk bigger than this (e.g. 500G), in case it's the wrong disk or its size was misread")
+)
+
+// diskSizeSources are the valid values of --dev-size-source.
+var diskSizeSources = map[string]bool{"auto": true, "sysfs": true, "ioctl": true, "simulate": true}
+
+// checkDevSizeFlags validates --dev-size-source and
+// --simulate-disk-size, and turns on --dry-run for a simulated size.
+func checkDevSizeFlags() error {
+	if !diskSizeSources[*devSizeSource] {
+		return fmt.Errorf("--dev-size-source: unknown source %q", *devSizeSource)
+	}
+	if *simulateDiskSize != "" {
+		if _, err := parseSize(*simulateDiskSize); err != nil {
+			return fmt.Errorf("--simulate-disk-size: %v", err)
+		}
+		*dry = true
+	} else if *devSizeSource == "simulate" {
+		return fmt.Errorf("--dev-size-source=simulate needs --simulate-disk-size")
+	}
+	if *maxDiskSize != "" {
+		if n, err := parseSize(*maxDiskSize); err != nil || n <= 0 {
+			return fmt.Errorf("--max-disk-size: bad size %q", *maxDiskSize)
+		}
+	}
+	return nil
+}
+
+// checkMaxDiskSize returns an error if diskDev, of sectors 512-byte
+// sectors, is bigger than --max-disk-size.
+func checkMaxDiskSize(diskDev string, sectors int64) error {
+	if *maxDiskSize == "" {
+		return nil
+	}
+	max, err := parseSize(*maxDiskSize)
+	if err != nil {
+		return fmt.Errorf("--max-disk-size: %v", err)
+	}
+	if sectors > max/512 {
+		return fmt.Errorf("%s is %s, more than --max-disk-size=%s; not growing into it. Check it's the disk you meant, and that its size was read right (see --dev-size-source)", diskDev, humanSectors(sectors, 512), *maxDiskSize)
+	}
+	return nil
+}
+
+// diskSize returns the size of the disk diskDev in 512-byte sectors,
+// from the source chosen by --dev-size-source.
+func diskSize(diskDev string) (int64, error) {
+	switch src := *devSizeSource; {
+	case src == "simulate" || src == "auto" && *simulateDiskSize != "":
+		b, err := parseSize(*simulateDiskSize)
+		if err != nil {
+			return 0, fmt.Errorf("--simulate-disk-size: %v", err)
+		}
+		vlogf("disk size of %s: %d bytes, from --simulate-disk-size", diskDev, b)
+		return b / 512, nil
+	case src == "ioctl":
+		b, err := blkGetSize64(diskDev)
+		if err != nil {
+			return 0, fmt.Errorf("BLKGETSIZE64 on %s: %v", diskDev, err)
+		}
+		return b / 512, nil
+	case src == "auto" && sysfsDir == "/sys":
+		// With --sysfs-root, sysfs describes some other system than
+		// the devices here, so only it is used.
+		b, err := blkGetSize64(diskDev)
+		if err == nil {
+			vlogf("disk size of %s: %d bytes, from BLKGETSIZE64", diskDev, b)
+			return b / 512, nil
+		}
+		vlogf("BLKGETSIZE64 on %s: %v; using sysfs", diskDev, err)
+	}
+	return readInt64File(filepath.Join(sysfsDir, "block", blockName(diskDev), "size"))
+}
+
+// blkGetSize64 returns the size in bytes of the block device dev, as
+// reported by the BLKGETSIZE64 ioctl. It's a variable for tests.
+var blkGetSize64 = func(dev string) (int64, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var size uint64
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), unix.BLKGETSIZE64, uintptr(unsafe.Pointer(&size))); errno != 0 {
+		return 0, errno
+	}
+	return int64(size), nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskSizeSource(t *testing.T) {
+	defer func(src, sim string, d bool) { *devSizeSource, *simulateDiskSize, *dry = src, sim, d }(*devSizeSource, *simulateDiskSize, *dry)
+	defer func(f func(string) (int64, error)) { blkGetSize64 = f }(blkGetSize64)
+	blkGetSize64 = func(dev string) (int64, error) { return 40 << 30, nil }
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdz", "41943040") // 20 GiB
+
+	tests := []struct {
+		src, sim string
+		real     bool // whether sysfsDir is /sys, so auto may use the ioctl
+		want     int64
+	}{
+		{src: "sysfs", want: 41943040},
+		{src: "ioctl", want: 83886080},
+		{src: "simulate", sim: "100G", want: 209715200},
+		{src: "auto", want: 41943040},
+		{src: "auto", real: true, want: 83886080},
+		{src: "auto", sim: "100G", want: 209715200},
+	}
+	for _, tt := range tests {
+		*devSizeSource, *simulateDiskSize = tt.src, tt.sim
+		sysfsDir = sys.dir
+		if tt.real {
+			sysfsDir = "/sys"
+		}
+		got, err := diskSize("/dev/vdz")
+		sysfsDir = sys.dir
+		if err != nil {
+			t.Errorf("source %s (sim %q, real %v): %v", tt.src, tt.sim, tt.real, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("source %s (sim %q, real %v) = %d sectors; want %d", tt.src, tt.sim, tt.real, got, tt.want)
+		}
+	}
+
+	*dry = false
+	*devSizeSource, *simulateDiskSize = "auto", "100G"
+	if err := checkDevSizeFlags(); err != nil || !*dry {
+		t.Errorf("checkDevSizeFlags with --simulate-disk-size = %v, dry-run %v; want ok, dry-run", err, *dry)
+	}
+	for _, bad := range [][2]string{{"simulate", ""}, {"floppy", ""}, {"auto", "lots"}} {
+		*devSizeSource, *simulateDiskSize = bad[0], bad[1]
+		if err := checkDevSizeFlags(); err == nil {
+			t.Errorf("checkDevSizeFlags(%q, %q) succeeded; want error", bad[0], bad[1])
+		}
+	}
+}
+
+func TestMaxDiskSize(t *testing.T) {
+	defer func(max, src, sim string, d bool) {
+		*maxDiskSize, *devSizeSource, *simulateDiskSize, *dry = max, src, sim, d
+	}(*maxDiskSize, *devSizeSource, *simulateDiskSize, *dry)
+
+	*maxDiskSize = ""
+	if err := checkMaxDiskSize("/dev/sda", 1<<40); err != nil {
+		t.Errorf("no --max-disk-size: %v", err)
+	}
+	*maxDiskSize = "500G"
+	if err := checkDevSizeFlags(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		sectors int64
+		ok      bool
+	}{
+		{500 << 21, true}, // exactly 500 GiB
+		{100 << 21, true},
+		{500<<21 + 1, false},
+		{16 << 31, false}, // 16 TiB
+	} {
+		err := checkMaxDiskSize("/dev/sda", tt.sectors)
+		if tt.ok != (err == nil) {
+			t.Errorf("%d sectors: err = %v; want ok = %v", tt.sectors, err, tt.ok)
+		}
+		if err != nil && !strings.Contains(err.Error(), "--max-disk-size=500G") {
+			t.Errorf("%d sectors: err = %v; want it to name the flag", tt.sectors, err)
+		}
+	}
+	for _, bad := range []string{"lots", "0", "-5G"} {
+		*maxDiskSize = bad
+		if err := checkDevSizeFlags(); err == nil {
+			t.Errorf("--max-disk-size=%s: want error", bad)
+		}
+	}
+
+	// A resize into a disk over the bound is refused before anything
+	// is written.
+	*maxDiskSize, *devSizeSource, *simulateDiskSize = "1T", "auto", "2T"
+	if err := checkDevSizeFlags(); err != nil {
+		t.Fatal(err)
+	}
+	_, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte(mbrSample), nil
+		}
+		if args[0] == "/sbin/sfdisk" && args[1] == "--version" {
+			return []byte("sfdisk from util-linux 2.34\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	defer unlockDisks()
+	if err := partitionResizer("/dev/sda5").Resize(); err == nil || !strings.Contains(err.Error(), "more than --max-disk-size=1T") {
+		t.Fatalf("Resize into 2T disk = %v; want refusal", err)
+	}
+	for _, args := range *ran {
+		if args[0] == "/sbin/sfdisk" && args[1] != "-d" && args[1] != "--version" {
+			t.Errorf("ran %q after refusing", args)
+		}
+	}
+}
+
+// TestDiskSizeOfGivenDisk checks that a disk's size is read from its
+// own sysfs entry, not sda's, including when it's named by a symlink
+// such as /dev/disk/by-id/....
+func TestDiskSizeOfGivenDisk(t *testing.T) {
+	defer func(src string) { *devSizeSource = src }(*devSizeSource)
+	*devSizeSource = "sysfs"
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "1000")
+	sys.disk("vdb", "2000")
+	sys.disk("nvme0n1", "3000")
+
+	td, err := ioutil.TempDir("", "embiggen-byid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	link := filepath.Join(td, "virtio-data")
+	if err := os.Symlink("/dev/vdb", link); err != nil {
+		t.Fatal(err)
+	}
+
+	for dev, want := range map[string]int64{
+		"/dev/sda":     1000,
+		"/dev/vdb":     2000,
+		"/dev/nvme0n1": 3000,
+		link:           2000,
+	} {
+		if got, err := diskSize(dev); err != nil || got != want {
+			t.Errorf("diskSize(%q) = %d, %v; want %d", dev, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// isDMDev reports whether dev names a device-mapper device.
+func isDMDev(dev string) bool {
+	return strings.HasPrefix(dev, "/dev/mapper/") || strings.HasPrefix(dev, "/dev/dm-")
+}
+
+// dmPartUUIDRx matches the device-mapper UUID kpartx gives the
+// partitions it maps: "part1-" and the UUID of their disk. kpartx
+// gives none to partitions of a disk without a UUID, so those aren't
+// recognized.
+var dmPartUUIDRx = regexp.MustCompile(`^part\d+-`)
+
+// isDMPartition reports whether dev is a partition of a
+// device-mapper disk, such as a dm-linear test rig, mapped by kpartx.
+func isDMPartition(dev string) bool {
+	return isDMDev(dev) && dmPartUUIDRx.MatchString(dmUUID(dev))
+}
+
+// dmDiskOf returns the device-mapper disk that the kpartx partition
+// dev is mapped onto, such as "/dev/mapper/disk" for
+// "/dev/mapper/disk1".
+func dmDiskOf(dev string) (string, error) {
+	name, err := sysBlockName(dev)
+	if err != nil {
+		return "", err
+	}
+	slaves, _ := ioutil.ReadDir(filepath.Join(sysfsDir, "block", name, "slaves"))
+	if len(slaves) != 1 {
+		return "", fmt.Errorf("%s is on %d devices; want 1", dev, len(slaves))
+	}
+	disk, err := ioutil.ReadFile(filepath.Join(sysfsDir, "block", slaves[0].Name(), "dm", "name"))
+	if err != nil {
+		return "", fmt.Errorf("%s is on %s, which isn't a device-mapper disk", dev, slaves[0].Name())
+	}
+	return "/dev/mapper/" + strings.TrimSpace(string(disk)), nil
+}
+
+// A linearTarget is a device-mapper table of a single dm-linear
+// target, mapping length sectors of dev from offset.
+type linearTarget struct {
+	length int64
+	dev    string // "7:0"
+	offset int64
+}
+
+func (t linearTarget) String() string {
+	return fmt.Sprintf("0 %d linear %s %d", t.length, t.dev, t.offset)
+}
+
+// parseLinearTable parses the output of "dmsetup table" for a
+// device that should be a single dm-linear target, such as:
+//
+//	0 2097152 linear 7:0 0
+func parseLinearTable(out []byte) (linearTarget, error) {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	f := strings.Fields(lines[0])
+	if len(lines) != 1 || len(f) != 5 || f[0] != "0" || f[2] != "linear" {
+		return linearTarget{}, fmt.Errorf("table isn't a single linear target: %q", out)
+	}
+	t := linearTarget{dev: f[3]}
+	var err error
+	if t.length, err = strconv.ParseInt(f[1], 10, 64); err != nil {
+		return linearTarget{}, fmt.Errorf("bad length in table %q", out)
+	}
+	if t.offset, err = strconv.ParseInt(f[4], 10, 64); err != nil {
+		return linearTarget{}, fmt.Errorf("bad offset in table %q", out)
+	}
+	return t, nil
+}
+
+// growDMLinear grows the dm-linear disk diskDev to the end of the
+// device it maps, should that have grown, so its partitions can grow
+// too.
+func growDMLinear(diskDev string) error {
+	name := strings.TrimPrefix(diskDev, "/dev/mapper/")
+	if !strings.HasPrefix(diskDev, "/dev/mapper/") {
+		name = blockName(diskDev)
+	}
+	cmd := command("dmsetup", "table", name)
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("running dmsetup table %s: %v", name, execErrDetail(err))
+	}
+	t, err := parseLinearTable(out)
+	if err != nil {
+		return fmt.Errorf("can't grow device-mapper disk %s: %v", diskDev, err)
+	}
+	backing, err := readInt64File(filepath.Join(sysfsDir, "dev", "block", t.dev, "size"))
+	if err != nil {
+		return fmt.Errorf("size of %s, under %s: %v", t.dev, diskDev, err)
+	}
+	if backing-t.offset <= t.length {
+		vlogf("dm-linear disk %s already maps all of %s", diskDev, t.dev)
+		return nil
+	}
+	grown := t
+	grown.length = backing - t.offset
+	if err := checkMaxDiskSize(diskDev, grown.length); err != nil {
+		return err
+	}
+	explainf("%s is a dm-linear disk mapping %d sectors of %s, which now has %d past its offset, so its table is reloaded before its partition grows", diskDev, t.length, t.dev, grown.length)
+	reload := command("dmsetup", "reload", name, "--table", grown.String())
+	resume := command("dmsetup", "resume", name)
+	if *dry {
+		skipDryRun(reload)
+		skipDryRun(resume)
+		fmt.Fprintf(progress(), "[dry-run] the partition below is planned against the disk's current size\n")
+		return nil
+	}
+	if err := checkAllowedHours(); err != nil {
+		return err
+	}
+	for _, cmd := range []*exec.Cmd{reload, resume} {
+		if out, err := cmdCombinedOutput(cmd); err != nil {
+			return fmt.Errorf("running %s: %v, %s", strings.Join(cmd.Args, " "), err, capOutput(cmd.Args, out))
+		}
+	}
+	notef("grew dm-linear disk %s from %d to %d sectors", diskDev, t.length, grown.length)
+	return nil
+}
+
+// kpartxUpdate has kpartx update the mappings of the partitions of
+// the device-mapper disk diskDev to match its partition table.
+func kpartxUpdate(diskDev string) error {
+	cmd := command("kpartx", "-u", diskDev)
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("running kpartx -u %s: %v, %s", diskDev, err, capOutput(cmd.Args, out))
+	}
+	return checkOutput(cmd.Args, out)
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDMLinearDisk(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.dm("dm-0", "rig", "", "loop0")
+	sys.file("block/dm-0/size", "2097152\n")
+	sys.dm("dm-1", "rig1", "part1-rig", "dm-0")
+	sys.dm("dm-2", "vg-root", "LVM-abcd", "dm-1")
+	sys.file("dev/block/7:0/size", "4194304\n")
+
+	for dev, want := range map[string]bool{
+		"/dev/mapper/rig1":    true,
+		"/dev/dm-1":           true,
+		"/dev/mapper/rig":     false,
+		"/dev/mapper/vg-root": false,
+		"/dev/sda":            false,
+	} {
+		if got := isPartitionDevName(dev); got != want {
+			t.Errorf("isPartitionDevName(%q) = %v; want %v", dev, got, want)
+		}
+	}
+	if got, err := diskDev("/dev/mapper/rig1"); got != "/dev/mapper/rig" || err != nil {
+		t.Errorf("diskDev(rig1) = %q, %v; want /dev/mapper/rig", got, err)
+	}
+
+	table := "0 2097152 linear 7:0 0\n"
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "dmsetup" && args[1] == "table" {
+			return []byte(table), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	if err := growDMLinear("/dev/mapper/rig"); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"dmsetup", "table", "rig"},
+		{"dmsetup", "reload", "rig", "--table", "0 4194304 linear 7:0 0"},
+		{"dmsetup", "resume", "rig"},
+	}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %q; want %q", *ran, want)
+	}
+
+	// Already mapping all of its device, it's left alone.
+	table = "0 4194304 linear 7:0 0\n"
+	*ran = nil
+	if err := growDMLinear("/dev/mapper/rig"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 1 {
+		t.Errorf("ran %q; want only dmsetup table", *ran)
+	}
+
+	// Anything but a single linear target is refused.
+	table = "0 2097152 linear 7:0 0\n2097152 2097152 linear 7:1 0\n"
+	if err := growDMLinear("/dev/mapper/rig"); err == nil || !strings.Contains(err.Error(), "single linear target") {
+		t.Errorf("two targets: %v; want refusal", err)
+	}
+
+	// The kernel doesn't partition dm devices, so kpartx updates them.
+	*ran = nil
+	if err := tellKernel("/dev/mapper/rig", sfdiskLine{dev: "/dev/mapper/rig1", pno: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"kpartx", "-u", "/dev/mapper/rig"}}; !reflect.DeepEqual(*ran, want) {
+		t.Errorf("tellKernel ran %q; want %q", *ran, want)
+	}
+}
+
+// TestDMPartitionOnTwoDevices checks that a kpartx-style partition
+// whose disk can't be told is an error from everything that needs the
+// disk, not a panic.
+func TestDMPartitionOnTwoDevices(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.dm("dm-0", "rig", "", "loop0")
+	sys.dm("dm-1", "rig1", "part1-rig", "dm-0", "loop1")
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		return nil, fmt.Errorf("unexpected command %q", args)
+	})
+	defer restore()
+
+	const want = "is on 2 devices"
+	if _, err := diskDev("/dev/mapper/rig1"); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("diskDev = %v; want %q", err, want)
+	}
+	if err := partitionResizer("/dev/mapper/rig1").Resize(); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Resize = %v; want %q", err, want)
+	}
+	if err := shrinkPartition("/dev/mapper/rig1", 1<<30); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("shrinkPartition = %v; want %q", err, want)
+	}
+	if !canDiscard("/dev/mapper/rig1") {
+		t.Errorf("canDiscard = false; want true, as it can't be told")
+	}
+}
+
+func TestParseLinearTable(t *testing.T) {
+	got, err := parseLinearTable([]byte("0 2097152 linear 253:4 2048\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (linearTarget{length: 2097152, dev: "253:4", offset: 2048}); got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if got.String() != "0 2097152 linear 253:4 2048" {
+		t.Errorf("String = %q", got.String())
+	}
+	for _, bad := range []string{"", "0 2097152 striped 2 128 7:0 0 7:1 0", "0 x linear 7:0 0"} {
+		if _, err := parseLinearTable([]byte(bad)); err == nil {
+			t.Errorf("parseLinearTable(%q): want error", bad)
+		}
+	}
+}
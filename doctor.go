@@ -0,0 +1,132 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var doctor = flag.Bool("doctor", false, "don't make changes; explain why the argument's partition would or wouldn't grow, and what to do about it")
+
+// diskFacts are what diagnose needs to know about the partition that
+// would be grown and its disk.
+type diskFacts struct {
+	disk       string // "/dev/sda"
+	part       string // "/dev/sda3"
+	label      string // partition table type: "dos", "gpt"
+	sectorSize int64
+	diskSize   int64        // in sectors, as the kernel sees it
+	partEnd    int64        // first sector after the partition
+	typeErr    error        // from checkGrowableType
+	after      string       // a partition after part, in the way
+	canRescan  bool         // the disk has a sysfs device/rescan file
+	gaps       []freeRegion // free space between partitions, which can't be grown into
+}
+
+// diagnose explains whether the partition described by f can grow,
+// and if not, why not and what to do. It returns one line per finding.
+func diagnose(f diskFacts) []string {
+	switch f.label {
+	case "dos", "gpt":
+	default:
+		return []string{fmt.Sprintf("%s has a %q partition table, which embiggen-disk doesn't support; only dos (MBR) and gpt are", f.disk, f.label)}
+	}
+	isGPT := f.label == "gpt"
+	if f.typeErr != nil {
+		return []string{fmt.Sprintf("%v; change its type if it really is a Linux partition", f.typeErr)}
+	}
+	if f.after != "" {
+		return []string{fmt.Sprintf("partition %s is after %s on %s, so %s has nowhere to grow; move or remove %s first", f.after, f.part, f.disk, f.part, f.after)}
+	}
+	remain := f.diskSize - f.partEnd
+	if remain <= 0 {
+		fix := fmt.Sprintf("check that the new size reached this machine (e.g. for a VM, that the hypervisor resized the disk it's running with, not just the image), then reboot if %s still hasn't grown", f.disk)
+		if f.canRescan {
+			fix = fmt.Sprintf("if the disk was grown on the host, have the kernel re-read its size with: echo 1 > /sys/block/%s/device/rescan", filepath.Base(f.disk))
+		}
+		return []string{fmt.Sprintf("the kernel sees %s as %d sectors, and %s already ends there: the disk hasn't grown as far as this machine knows", f.disk, f.diskSize, f.part), fix}
+	}
+	if extend := remain - endReserve(f.sectorSize, isGPT, false); extend <= 0 {
+		msg := fmt.Sprintf("only %s is free after %s, no more than the space kept free at the end of the disk for alignment (1 MiB, or --gpt-reserve on GPT disks)", humanSectors(remain, f.sectorSize), f.part)
+		if remain > endReserve(f.sectorSize, isGPT, true) {
+			return []string{msg, "run with --force to grow into it anyway"}
+		}
+		return []string{msg, "there's nothing to gain: the disk needs to grow first"}
+	}
+	return []string{fmt.Sprintf("%s can grow by %s into the free space at the end of %s; run without --doctor to grow it", f.part, humanSectors(remain-endReserve(f.sectorSize, isGPT, false), f.sectorSize), f.disk)}
+}
+
+// doctorDiagnosis gathers the facts about e's partition and diagnoses
+// them.
+func doctorDiagnosis(e Resizer) ([]string, error) {
+	bottom, err := bottomResizer(e)
+	if err != nil {
+		return nil, err
+	}
+	pr, ok := bottom.(partitionResizer)
+	if !ok {
+		return []string{fmt.Sprintf("%v is not on a partition, so only it and the layers above it are grown; its disk needs to grow first", bottom)}, nil
+	}
+	f, err := partitionFacts(pr)
+	if err != nil {
+		return nil, err
+	}
+	return diagnose(f), nil
+}
+
+// partitionFacts returns the facts about the partition pr and its
+// disk. If the disk's partition table isn't supported, only the
+// label is filled in.
+func partitionFacts(pr partitionResizer) (f diskFacts, err error) {
+	f = diskFacts{part: string(pr)}
+	if f.disk, err = diskDev(string(pr)); err != nil {
+		return f, err
+	}
+	pt, err := readPartitionTable(f.disk)
+	if err != nil {
+		return f, err
+	}
+	f.label = pt.Meta("label")
+	if f.label != "dos" && f.label != "gpt" {
+		return f, nil
+	}
+	part, ok := pt.lastPartition()
+	if !ok {
+		return f, fmt.Errorf("no non-zero partition found on %s", f.disk)
+	}
+	f.part = part.dev
+	f.typeErr = checkGrowableType(part, f.label == "gpt")
+	if after, ok := pt.partitionAfter(part); ok {
+		f.after = after.dev
+	}
+	if f.sectorSize, err = pt.checkedSectorSize(f.disk); err != nil {
+		return f, err
+	}
+	sysSize, err := diskSize(f.disk)
+	if err != nil {
+		return f, err
+	}
+	f.diskSize = diskSectors(sysSize, f.sectorSize)
+	f.partEnd = part.Start() + part.Size()
+	f.gaps, _ = pt.freeRegions(f.diskSize)
+	_, err = os.Stat(filepath.Join(sysfsDir, "block", filepath.Base(f.disk), "device", "rescan"))
+	f.canRescan = err == nil
+	return f, nil
+}
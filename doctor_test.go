@@ -0,0 +1,116 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	base := diskFacts{
+		disk:       "/dev/sda",
+		part:       "/dev/sda3",
+		label:      "gpt",
+		sectorSize: 512,
+		diskSize:   41943040,
+		partEnd:    20971520,
+	}
+	tests := []struct {
+		name string
+		edit func(*diskFacts)
+		want []string // substrings of the diagnosis, in order
+	}{
+		{
+			name: "room to grow",
+			want: []string{"/dev/sda3 can grow by 20969472 sectors"},
+		},
+		{
+			name: "disk not grown, rescannable",
+			edit: func(f *diskFacts) { f.diskSize, f.canRescan = f.partEnd, true },
+			want: []string{"the kernel sees /dev/sda as 20971520 sectors", "echo 1 > /sys/block/sda/device/rescan"},
+		},
+		{
+			name: "disk not grown, no rescan",
+			edit: func(f *diskFacts) { f.diskSize = f.partEnd },
+			want: []string{"hasn't grown as far as this machine knows", "hypervisor"},
+		},
+		{
+			name: "only the tail reserve free",
+			edit: func(f *diskFacts) { f.diskSize = f.partEnd + 2048 },
+			want: []string{"no more than the space kept free", "--force"},
+		},
+		{
+			name: "only the backup GPT's room free",
+			edit: func(f *diskFacts) { f.diskSize = f.partEnd + 33 },
+			want: []string{"no more than the space kept free", "the disk needs to grow first"},
+		},
+		{
+			name: "unsupported label",
+			edit: func(f *diskFacts) { f.label = "sun" },
+			want: []string{`"sun" partition table`},
+		},
+		{
+			name: "unsupported partition type",
+			edit: func(f *diskFacts) { f.typeErr = errors.New("partition /dev/sda3 has type swap") },
+			want: []string{"has type swap; change its type"},
+		},
+		{
+			name: "partition in the way",
+			edit: func(f *diskFacts) { f.after = "/dev/sda4" },
+			want: []string{"partition /dev/sda4 is after /dev/sda3"},
+		},
+	}
+	for _, tt := range tests {
+		f := base
+		if tt.edit != nil {
+			tt.edit(&f)
+		}
+		got := diagnose(f)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: diagnosis = %q; want %d lines", tt.name, got, len(tt.want))
+			continue
+		}
+		for i, w := range tt.want {
+			if !strings.Contains(got[i], w) {
+				t.Errorf("%s: line %d = %q; want it to contain %q", tt.name, i, got[i], w)
+			}
+		}
+	}
+}
+
+func TestDoctorDiagnosis(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "20971520")
+	sys.file("block/sda/queue/logical_block_size", "512\n")
+	sys.file("block/sda/device/rescan", "")
+	sys.part("sda", "sda1", "1", "20969472")
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		return []byte("label: dos\nlabel-id: 0x1\ndevice: /dev/sda\nunit: sectors\n\n/dev/sda1 : start=2048, size=20969472, type=83\n"), nil
+	})
+	defer restore()
+
+	got, err := doctorDiagnosis(partitionResizer("/dev/sda1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !strings.Contains(got[1], "/sys/block/sda/device/rescan") {
+		t.Errorf("diagnosis = %q; want a suggestion to rescan /dev/sda", got)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package embiggen defines the machine-readable output of the
+// embiggen-disk command, as printed by its --json flag.
+//
+// Go programs running embiggen-disk can unmarshal its output
+// directly into a Result.
+package embiggen
+
+import "fmt"
+
+// Version is the version of the Result JSON schema.
+//
+// It's incremented whenever a field is removed, renamed or changes
+// meaning. Adding fields doesn't change the version, so consumers
+// should ignore fields they don't know about.
+const Version = 1
+
+// Result is the JSON object printed by embiggen-disk --json.
+type Result struct {
+	// Version is the schema version. It's always Version when
+	// written by this package.
+	Version int `json:"version"`
+
+	// ToolVersion, ToolCommit and BuildDate identify the
+	// embiggen-disk binary that did the run, as --version prints
+	// them. The commit and date are empty if the build didn't set
+	// them.
+	ToolVersion string `json:"toolVersion,omitempty"`
+	ToolCommit  string `json:"toolCommit,omitempty"`
+	BuildDate   string `json:"buildDate,omitempty"`
+
+	// Mount is the mount point that was requested to be enlarged.
+	Mount string `json:"mount"`
+
+	// Disk is the whole disk backing Mount, such as "/dev/sda",
+	// if it was resolved (with --dev-from-root).
+	Disk string `json:"disk,omitempty"`
+
+	// Label and UUID identify the filesystem at Mount, as read
+	// by blkid before anything was changed. Either is empty if the
+	// filesystem doesn't have one (or with --raw, where there's no
+	// filesystem).
+	Label string `json:"label,omitempty"`
+	UUID  string `json:"uuid,omitempty"`
+
+	// Changes are the layers that changed size, from the bottom
+	// of the stack (e.g. a partition) to the top (the filesystem).
+	Changes []Change `json:"changes"`
+
+	// Skipped is why the run deliberately grew nothing, such as the
+	// filesystem being no fuller than --grow-if-used-above allows.
+	Skipped string `json:"skipped,omitempty"`
+
+	// Layers are all the layers that were grown, from the bottom of
+	// the stack to the top, including any already as big as they
+	// could be.
+	Layers []Layer `json:"layers,omitempty"`
+
+	// Risk is the risk level of the operation as assessed by
+	// --preflight: "low", "medium" or "high". It's empty if
+	// --preflight wasn't used.
+	Risk string `json:"risk,omitempty"`
+
+	// RiskReasons explain the Risk level.
+	RiskReasons []string `json:"riskReasons,omitempty"`
+
+	// Diagnosis explains, for --doctor, whether and why not the
+	// partition below Mount can grow, and what to do about it.
+	Diagnosis []string `json:"diagnosis,omitempty"`
+
+	// ReclaimableBytes is, for --report-reclaimable-only, how much
+	// unallocated space the partition below Mount could grow into.
+	ReclaimableBytes int64 `json:"reclaimableBytes,omitempty"`
+
+	// MinSizeBytes is, for --report-min-size, the smallest the ext
+	// filesystem at Mount could be shrunk to, as estimated by
+	// resize2fs -P. ShrinkableBytes is how much smaller than its
+	// current size that is.
+	MinSizeBytes    int64 `json:"minSizeBytes,omitempty"`
+	ShrinkableBytes int64 `json:"shrinkableBytes,omitempty"`
+
+	// UsableBytesGained is, after growing an ext filesystem, how
+	// much more of it is usable by users other than root: its growth
+	// less what's reserved for root, as set by tune2fs -m.
+	UsableBytesGained int64 `json:"usableBytesGained,omitempty"`
+
+	// TrimmedBytes is, with --trim, how many bytes fstrim discarded
+	// after the filesystem grew.
+	TrimmedBytes int64 `json:"trimmedBytes,omitempty"`
+
+	// TreeBefore and TreeAfter are the output of "lsblk -b" for the
+	// disk below Mount, before and after a run that changed
+	// something, showing the sizes in bytes of each layer.
+	TreeBefore string `json:"treeBefore,omitempty"`
+	TreeAfter  string `json:"treeAfter,omitempty"`
+
+	// Timings are how long each stage of the run took, in the
+	// order they ran.
+	Timings []Timing `json:"timings,omitempty"`
+
+	// Notes describe what individual layers found, such as a PV
+	// or filesystem that had no room to grow.
+	Notes []string `json:"notes,omitempty"`
+
+	// Warnings are non-fatal problems noticed during the run,
+	// also printed to stderr.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Error is the error that stopped the run, if any. Changes
+	// made before the error are still listed in Changes.
+	Error string `json:"error,omitempty"`
+}
+
+// A Change describes one layer of the storage stack whose state
+// changed during a run.
+type Change struct {
+	Resizer string `json:"resizer"` // "ext4 filesystem at /", "LVM PV /dev/sda3"
+	Before  string `json:"before"`  // "1038833256 blocks"
+	After   string `json:"after"`   // "1039091312 blocks"
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: before: %s, after: %s", c.Resizer, c.Before, c.After)
+}
+
+// A Layer describes one layer of the storage stack that a run grew,
+// or tried to.
+type Layer struct {
+	Kind    string `json:"kind"`    // "partition", "lvm-pv", "lvm-lv", "dm-crypt", "vdo" or "filesystem"
+	Resizer string `json:"resizer"` // as in Change
+	Device  string `json:"device"`  // "/dev/sda3"
+	Before  string `json:"before"`  // as in Change
+	After   string `json:"after"`   // as in Change
+
+	// NoOp is whether the layer didn't change: it already filled
+	// what was below it, or the run was a dry run.
+	NoOp bool `json:"noop"`
+
+	// For a partition, PartitionTable is the type of its disk's
+	// partition table, "gpt" or "dos", and its sizes are in sectors
+	// of SectorSize bytes, the disk's logical sector size, and in
+	// bytes.
+	PartitionTable string `json:"partitionTable,omitempty"`
+	SectorSize     int64  `json:"sectorSize,omitempty"`
+	SectorsBefore  int64  `json:"sectorsBefore,omitempty"`
+	SectorsAfter   int64  `json:"sectorsAfter,omitempty"`
+	BytesBefore    int64  `json:"bytesBefore,omitempty"`
+	BytesAfter     int64  `json:"bytesAfter,omitempty"`
+
+	// VG and LV are, for an LVM LV, the names of its volume group
+	// and of the LV itself.
+	VG string `json:"vg,omitempty"`
+	LV string `json:"lv,omitempty"`
+
+	// FSType and Mount are a filesystem's type and mount point.
+	// Mount is empty if the filesystem was grown unmounted.
+	FSType string `json:"fsType,omitempty"`
+	Mount  string `json:"mount,omitempty"`
+}
+
+// A Timing is how long one stage of a run took, such as resizing one
+// layer of the storage stack, whether or not it changed anything.
+type Timing struct {
+	Stage   string  `json:"stage"`   // "LVM PV /dev/sda3", "kernel update of /dev/sda3"
+	Seconds float64 `json:"seconds"` // 0.418
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package embiggen
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestResultJSON(t *testing.T) {
+	res := Result{
+		Version: Version,
+		Mount:   "/",
+		Changes: []Change{
+			{Resizer: "partition /dev/sda1", Before: "20969472 sectors", After: "41940992 sectors"},
+			{Resizer: "ext4 filesystem at /", Before: "2621184 blocks", After: "5242624 blocks"},
+		},
+	}
+	j, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"version":1,"mount":"/","changes":[` +
+		`{"resizer":"partition /dev/sda1","before":"20969472 sectors","after":"41940992 sectors"},` +
+		`{"resizer":"ext4 filesystem at /","before":"2621184 blocks","after":"5242624 blocks"}]}`
+	if string(j) != want {
+		t.Errorf("JSON mismatch\n got: %s\nwant: %s", j, want)
+	}
+
+	var back Result
+	if err := json.Unmarshal(j, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, res) {
+		t.Errorf("round trip = %+v; want %+v", back, res)
+	}
+}
+
+func TestChangeString(t *testing.T) {
+	c := Change{Resizer: "LVM PV /dev/sda3", Before: "sectors=100", After: "sectors=200"}
+	if got, want := c.String(), "LVM PV /dev/sda3: before: sectors=100, after: sectors=200"; got != want {
+		t.Errorf("String = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var explain = flag.Bool("explain", false, "print why each step was taken: which partition was chosen and how far it's grown, which layers (LVM, dm-crypt, VDO) are on the way, and which filesystem tool is used")
+
+// explained are the explanations printed by explainf so far in this
+// Run. DepResizer runs on every walk of the chain, so its decisions
+// would otherwise be explained several times.
+var explained = map[string]bool{}
+
+// explainf prints, with --explain, why embiggen-disk made a decision.
+// It's called where the decision is made, with the numbers it was
+// made from.
+func explainf(format string, args ...interface{}) {
+	if !*explain {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if explained[msg] {
+		return
+	}
+	explained[msg] = true
+	fmt.Fprintf(progress(), "why: %s\n", msg)
+}
+
+// endReserveReason says why endReserve leaves the sectors it does at
+// the end of a disk.
+func endReserveReason(isGPT, force bool) string {
+	switch {
+	case isGPT && force:
+		return "only what the GPT's backup header and partition entries need, because of --force"
+	case isGPT:
+		return fmt.Sprintf("--gpt-reserve=%s, for the GPT's backup header and to keep the partition's end aligned", *gptReserve)
+	case force:
+		return "nothing, because of --force"
+	}
+	return "1 MiB, to keep the partition's end aligned"
+}
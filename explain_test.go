@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExplain checks the explanations of a dry run growing an ext4 LV
+// on an LVM PV on an MBR partition.
+func TestExplain(t *testing.T) {
+	defer func(e, d bool) { *explain, *dry = e, d }(*explain, *dry)
+	*explain, *dry = true, true
+	defer func() { explained = map[string]bool{} }()
+	explained = map[string]bool{}
+
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdz", "41943040")
+	sys.file("block/vdz/queue/logical_block_size", "512\n")
+	sys.part("vdz", "vdz3", "3", "20969472")
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "lvs":
+			if containsString(args, "lv_path,vg_name,lv_size") {
+				return []byte("  /dev/vg/root:vg:20963328\n"), nil
+			}
+		case "pvdisplay":
+			return []byte("  /dev/vdz3:vg:20967424:-1:8:8:-1:4096:2559:0:2559:AAAA\n"), nil
+		case "/sbin/sfdisk":
+			if args[1] == "-d" {
+				return []byte("label: dos\ndevice: /dev/vdz\nunit: sectors\n\n/dev/vdz3 : start=2048, size=20969472, type=8e\n"), nil
+			}
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	out := captureStdout(t, func() {
+		fs := fsStat{dev: "/dev/mapper/vg-root", mnt: "/", fstype: "ext4"}
+		e, err := fsResizerFor(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for r := e; r != nil; {
+			// Walk the chain twice, as main does, to check that
+			// decisions are explained once.
+			if _, err := r.DepResizer(); err != nil {
+				t.Fatal(err)
+			}
+			if r, err = r.DepResizer(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := partitionResizer("/dev/vdz3").Resize(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{
+		"why: growing the ext4 filesystem at / with resize2fs, which grows ext2, ext3 and ext4 given their device, mounted or not\n",
+		"why: the filesystem is on device-mapper device /dev/mapper/vg-root, which isn't VDO or dm-crypt, so it's taken to be an LVM LV\n",
+		"why: LVM LV /dev/mapper/vg-root is in VG vg, whose only PV is /dev/vdz3, so that PV is grown first\n",
+		"why: LVM PV /dev/vdz3 is a partition, which must grow before it\n",
+		"why: /dev/vdz3 is the last partition on /dev/vdz, the only one that can grow into space added at the end of the disk\n",
+		"why: /dev/vdz is 41943040 sectors of 512 bytes; /dev/vdz3 ends at sector 20971520 (start 2048 + size 20969472), leaving 20971520 sectors after it\n",
+		"why: reserving 2048 sectors at the end of the disk (1 MiB, to keep the partition's end aligned), so growing by 20971520 - 2048 = 20969472 sectors\n",
+	} {
+		if n := strings.Count(out, want); n != 1 {
+			t.Errorf("explanation %q printed %d times; want once. Output:\n%s", want, n, out)
+		}
+	}
+}
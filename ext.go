@@ -0,0 +1,104 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var offline = flag.Bool("offline", false, "the argument is an unmounted ext2/3/4 or f2fs filesystem's device; grow it offline (checking ext with e2fsck first), for filesystems that can't be grown while mounted")
+
+var (
+	extFeaturesRx   = regexp.MustCompile(`(?m)^Filesystem features:\s*(.*)$`)
+	extBlockCountRx = regexp.MustCompile(`(?m)^Block count:\s*(\d+)$`)
+)
+
+// dumpe2fs returns the output of "dumpe2fs -h dev": the ext
+// filesystem's superblock.
+func dumpe2fs(dev string) ([]byte, error) {
+	out, err := cmdOutput(command("dumpe2fs", "-h", dev))
+	if err != nil {
+		return nil, fmt.Errorf("running dumpe2fs -h %s: %v", dev, execErrDetail(err))
+	}
+	return out, nil
+}
+
+// parseExtFeatures returns the feature flags in dumpe2fs -h output.
+func parseExtFeatures(out []byte) map[string]bool {
+	m := extFeaturesRx.FindSubmatch(out)
+	if m == nil {
+		return nil
+	}
+	features := map[string]bool{}
+	for _, f := range strings.Fields(string(m[1])) {
+		features[f] = true
+	}
+	return features
+}
+
+// checkOnlineGrowable returns an error explaining the alternative if
+// an ext filesystem with the given features can't be grown while
+// mounted. The kernel grows a mounted filesystem using the group
+// descriptor blocks reserved by resize_inode, or with meta_bg, puts
+// new ones in the new block groups; with neither, there's nowhere
+// for them to go.
+func checkOnlineGrowable(fs fsStat, features map[string]bool) error {
+	if features == nil || features["resize_inode"] || features["meta_bg"] {
+		return nil
+	}
+	return fmt.Errorf("%s filesystem at %s lacks the resize_inode feature, so it can't be grown while mounted, and resize2fs can grow it offline only by as much as its existing group descriptor blocks allow; unmount it and run embiggen-disk --offline %s", fs.fstype, fs.mnt, fs.dev)
+}
+
+// getOfflineFSResizer returns a Resizer for the unmounted ext or f2fs
+// filesystem on dev, for --offline.
+func getOfflineFSResizer(dev string) (Resizer, error) {
+	e, err := getUnmountedFSResizer(dev)
+	if err != nil {
+		return nil, err
+	}
+	fe := e.(fsResizer)
+	switch fe.fs.fstype {
+	case "ext2", "ext3", "ext4":
+		fe.cmd = command("resize2fs", fe.fs.dev)
+	case "f2fs":
+		if err := checkResizeF2FS(fe.fs.dev); err != nil {
+			return nil, err
+		}
+		fe.cmd = command("resize.f2fs", fe.fs.dev)
+	default:
+		return nil, fmt.Errorf("--offline only supports ext2/3/4 and f2fs filesystems; %s has %s", fe.fs.dev, fe.fs.fstype)
+	}
+	return fe, nil
+}
+
+// extBlockCount returns the size in blocks of the unmounted ext
+// filesystem on dev.
+func extBlockCount(dev string) (int64, error) {
+	out, err := dumpe2fs(dev)
+	if err != nil {
+		return 0, err
+	}
+	m := extBlockCountRx.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("no block count in dumpe2fs -h %s output", dev)
+	}
+	return strconv.ParseInt(string(m[1]), 10, 64)
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const dumpe2fsSample = `dumpe2fs 1.46.5 (30-Dec-2021)
+Filesystem volume name:   <none>
+Filesystem UUID:          0c7a5cf6-4e1f-4a4b-9d5c-2f3e1b6a8d90
+Filesystem magic number:  0xEF53
+Filesystem revision #:    1 (dynamic)
+Filesystem features:      has_journal ext_attr resize_inode dir_index filetype needs_recovery extent 64bit flex_bg sparse_super large_file huge_file dir_nlink extra_isize metadata_csum
+Default mount options:    user_xattr acl
+Block count:              2621440
+Block size:               4096
+`
+
+func TestOnlineGrowable(t *testing.T) {
+	fs := fsStat{dev: "/dev/sdb1", mnt: "/data", fstype: "ext3"}
+	features := parseExtFeatures([]byte(dumpe2fsSample))
+	if !features["resize_inode"] || !features["has_journal"] || features["meta_bg"] {
+		t.Fatalf("features = %v", features)
+	}
+	if err := checkOnlineGrowable(fs, features); err != nil {
+		t.Errorf("with resize_inode: %v", err)
+	}
+
+	noResize := parseExtFeatures([]byte(strings.Replace(dumpe2fsSample, " resize_inode", "", 1)))
+	err := checkOnlineGrowable(fs, noResize)
+	if err == nil || !strings.Contains(err.Error(), "lacks the resize_inode feature") || !strings.Contains(err.Error(), "--offline /dev/sdb1") {
+		t.Errorf("without resize_inode: %v; want the offline path explained", err)
+	}
+
+	noResize["meta_bg"] = true
+	if err := checkOnlineGrowable(fs, noResize); err != nil {
+		t.Errorf("with meta_bg: %v", err)
+	}
+	if err := checkOnlineGrowable(fs, parseExtFeatures([]byte("garbage"))); err != nil {
+		t.Errorf("without a feature list: %v; want no opinion", err)
+	}
+}
+
+func TestFSResizerForWithoutResizeInode(t *testing.T) {
+	out := strings.Replace(dumpe2fsSample, " resize_inode", "", 1)
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return []byte(out), nil })
+	defer restore()
+
+	_, err := fsResizerFor(fsStat{dev: "/dev/sdb1", mnt: "/data", fstype: "ext4"})
+	if err == nil || !strings.Contains(err.Error(), "--offline") {
+		t.Errorf("fsResizerFor = %v; want refusal suggesting --offline", err)
+	}
+	if len(*ran) != 1 || (*ran)[0][0] != "dumpe2fs" {
+		t.Errorf("ran %q; want just dumpe2fs", *ran)
+	}
+
+	if n, err := extBlockCount("/dev/sdb1"); err != nil || n != 2621440 {
+		t.Errorf("extBlockCount = %d, %v; want 2621440", n, err)
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The f2fs superblock is 1KB into the device, and starts with
+// f2fsMagic. Its block_count, a little-endian uint64, is 36 bytes in.
+const (
+	f2fsSuperOffset     = 1024
+	f2fsMagic           = 0xF2F52010
+	f2fsBlockCountField = 36
+)
+
+// f2fsMountedError explains that the f2fs filesystem fs can't be
+// grown where it's mounted: resize.f2fs only works offline.
+func f2fsMountedError(fs fsStat) error {
+	return fmt.Errorf("f2fs filesystem at %s can only be grown while unmounted, by resize.f2fs from f2fs-tools; unmount it and run embiggen-disk --offline %s", fs.mnt, fs.dev)
+}
+
+// checkResizeF2FS returns an error if resize.f2fs isn't installed.
+func checkResizeF2FS(dev string) error {
+	if _, err := lookPath("resize.f2fs"); err != nil {
+		return fmt.Errorf("growing the f2fs filesystem on %s needs resize.f2fs from f2fs-tools: %v", dev, err)
+	}
+	return nil
+}
+
+// f2fsBlockCount returns the size in blocks of the f2fs filesystem on
+// dev, from its superblock.
+func f2fsBlockCount(dev string) (int64, error) {
+	f, err := os.Open(dev)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var sb [f2fsBlockCountField + 8]byte
+	if _, err := f.ReadAt(sb[:], f2fsSuperOffset); err == io.EOF {
+		return 0, fmt.Errorf("%s is too small to hold an f2fs superblock", dev)
+	} else if err != nil {
+		return 0, fmt.Errorf("reading f2fs superblock of %s: %v", dev, err)
+	}
+	if binary.LittleEndian.Uint32(sb[:4]) != f2fsMagic {
+		return 0, fmt.Errorf("no f2fs superblock on %s", dev)
+	}
+	return int64(binary.LittleEndian.Uint64(sb[f2fsBlockCountField:])), nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestF2FSMountedRefused(t *testing.T) {
+	_, err := fsResizerFor(fsStat{dev: "/dev/vdb1", mnt: "/data", fstype: "f2fs"})
+	if err == nil || !strings.Contains(err.Error(), "resize.f2fs") || !strings.Contains(err.Error(), "--offline /dev/vdb1") {
+		t.Errorf("fsResizerFor = %v; want refusal suggesting --offline", err)
+	}
+}
+
+func TestOfflineF2FS(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return []byte("f2fs\n"), nil })
+	defer restore()
+
+	lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	if _, err := getOfflineFSResizer("/dev/vdb9"); err == nil || !strings.Contains(err.Error(), "f2fs-tools") {
+		t.Errorf("without resize.f2fs: %v; want it named", err)
+	}
+
+	lookPath = func(string) (string, error) { return "/usr/sbin/resize.f2fs", nil }
+	e, err := getOfflineFSResizer("/dev/vdb9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	*ran = nil
+	if err := e.Resize(); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 1 || strings.Join((*ran)[0], " ") != "resize.f2fs /dev/vdb9" {
+		t.Errorf("ran %q; want just resize.f2fs, without e2fsck", *ran)
+	}
+}
+
+func TestF2FSBlockCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "f2fs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dev := filepath.Join(dir, "dev")
+
+	img := make([]byte, 4096)
+	if err := ioutil.WriteFile(dev, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2fsBlockCount(dev); err == nil || !strings.Contains(err.Error(), "no f2fs superblock") {
+		t.Errorf("zeroed device: %v; want no superblock", err)
+	}
+
+	binary.LittleEndian.PutUint32(img[f2fsSuperOffset:], f2fsMagic)
+	binary.LittleEndian.PutUint64(img[f2fsSuperOffset+f2fsBlockCountField:], 262144)
+	if err := ioutil.WriteFile(dev, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := f2fsBlockCount(dev)
+	if err != nil || n != 262144 {
+		t.Errorf("f2fsBlockCount = %d, %v; want 262144", n, err)
+	}
+	if got, err := (fsResizer{fs: fsStat{dev: dev, fstype: "f2fs"}}).State(); err != nil || got != "262144 blocks" {
+		t.Errorf("State = %q, %v; want 262144 blocks", got, err)
+	}
+
+	if err := ioutil.WriteFile(dev, img[:512], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2fsBlockCount(dev); err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Errorf("tiny device: %v; want too small", err)
+	}
+}
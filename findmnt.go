@@ -0,0 +1,104 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// findmntColumns are the columns findmntMounts asks findmnt for.
+const findmntColumns = "SOURCE,TARGET,FSTYPE,FSROOT,MAJ:MIN,FS-OPTIONS,SIZE,AVAIL"
+
+// findmntMounts returns the system's mounts as listed by findmnt, if
+// it's installed and works. It reads the real /proc, so isn't used
+// when procDir is elsewhere.
+func findmntMounts() (mounts []mountInfo, ok bool) {
+	if procDir != "/proc" {
+		return nil, false
+	}
+	if _, err := lookPath("findmnt"); err != nil {
+		return nil, false
+	}
+	// -v leaves btrfs subvolumes out of SOURCE; they're in FSROOT.
+	cmd := command("findmnt", "--json", "--list", "-b", "-v", "-o", findmntColumns)
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		vlogf("running %s: %v; reading %s instead", strings.Join(cmd.Args, " "), execErrDetail(err), procDir)
+		return nil, false
+	}
+	mounts, err = parseFindmnt(out)
+	if err != nil {
+		vlogf("findmnt: %v; reading %s instead", err, procDir)
+		return nil, false
+	}
+	return mounts, true
+}
+
+// findmntInt is a number in findmnt's JSON output, which older
+// versions of findmnt quote, and which is null if unknown.
+type findmntInt int64
+
+func (n *findmntInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "null" || s == "" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	*n = findmntInt(v)
+	return err
+}
+
+// parseFindmnt parses the output of "findmnt --json --list -b -v -o
+// SOURCE,TARGET,FSTYPE,FSROOT,MAJ:MIN,FS-OPTIONS,SIZE,AVAIL".
+func parseFindmnt(out []byte) ([]mountInfo, error) {
+	var v struct {
+		Filesystems []struct {
+			Source    string     `json:"source"`
+			Target    string     `json:"target"`
+			FSType    string     `json:"fstype"`
+			FSRoot    string     `json:"fsroot"`
+			MajMin    string     `json:"maj:min"`
+			FSOptions string     `json:"fs-options"`
+			Size      findmntInt `json:"size"`
+			Avail     findmntInt `json:"avail"`
+		} `json:"filesystems"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return nil, err
+	}
+	if len(v.Filesystems) == 0 {
+		return nil, errors.New("no filesystems listed")
+	}
+	var mounts []mountInfo
+	for _, f := range v.Filesystems {
+		mounts = append(mounts, mountInfo{
+			dev:    f.Source,
+			mnt:    f.Target,
+			fstype: f.FSType,
+			root:   f.FSRoot,
+			devNum: f.MajMin,
+			opts:   f.FSOptions,
+			size:   int64(f.Size),
+			avail:  int64(f.Avail),
+		})
+	}
+	return mounts, nil
+}
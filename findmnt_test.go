@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+const findmntSample = `{
+   "filesystems": [
+      {
+         "source": "/dev/sda1",
+         "target": "/",
+         "fstype": "ext4",
+         "fsroot": "/",
+         "maj:min": "8:1",
+         "fs-options": "rw,errors=remount-ro",
+         "size": 52576591872,
+         "avail": 30112522240
+      },{
+         "source": "proc",
+         "target": "/proc",
+         "fstype": "proc",
+         "fsroot": "/",
+         "maj:min": "0:22",
+         "fs-options": "rw",
+         "size": null,
+         "avail": null
+      },{
+         "source": "/dev/sdb2",
+         "target": "/home/my files",
+         "fstype": "btrfs",
+         "fsroot": "/@home",
+         "maj:min": "0:45",
+         "fs-options": "rw,space_cache=v2,subvolid=257,subvol=/@home",
+         "size": "107374182400",
+         "avail": "53687091200"
+      }
+   ]
+}`
+
+func TestParseFindmnt(t *testing.T) {
+	got, err := parseFindmnt([]byte(findmntSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mountInfo{
+		{dev: "/dev/sda1", mnt: "/", fstype: "ext4", root: "/", devNum: "8:1", opts: "rw,errors=remount-ro", size: 52576591872, avail: 30112522240},
+		{dev: "proc", mnt: "/proc", fstype: "proc", root: "/", devNum: "0:22", opts: "rw"},
+		{dev: "/dev/sdb2", mnt: "/home/my files", fstype: "btrfs", root: "/@home", devNum: "0:45", opts: "rw,space_cache=v2,subvolid=257,subvol=/@home", size: 107374182400, avail: 53687091200},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFindmnt =\n%+v\nwant\n%+v", got, want)
+	}
+
+	for _, bad := range []string{"", `{"filesystems": []}`, `{"filesystems": [{"size": "big"}]}`} {
+		if _, err := parseFindmnt([]byte(bad)); err == nil {
+			t.Errorf("parseFindmnt(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestReadMountsFromFindmnt(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	defer func(d string) { procDir = d }(procDir)
+	procDir = "/proc"
+	findmntOut, findmntErr := []byte(findmntSample), error(nil)
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return findmntOut, findmntErr })
+	defer restore()
+
+	lookPath = func(string) (string, error) { return "/usr/bin/findmnt", nil }
+	mounts, err := readMounts()
+	if err != nil || len(mounts) != 3 || mounts[2].mnt != "/home/my files" {
+		t.Errorf("readMounts = %+v, %v; want findmnt's three", mounts, err)
+	}
+	if len(*ran) != 1 || (*ran)[0][0] != "findmnt" {
+		t.Errorf("ran %q; want findmnt", *ran)
+	}
+
+	// Without findmnt, or if it fails, or with procfs elsewhere,
+	// /proc is read instead.
+	fromProc := func(what string) {
+		t.Helper()
+		*ran = nil
+		mounts, err := readMounts()
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		for _, m := range mounts {
+			if m.mnt == "/home/my files" {
+				t.Errorf("%s: got findmnt's mounts; want those in %s", what, procDir)
+			}
+		}
+	}
+	lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	fromProc("no findmnt")
+	if len(*ran) != 0 {
+		t.Errorf("ran %q without findmnt installed", *ran)
+	}
+	lookPath = func(string) (string, error) { return "/usr/bin/findmnt", nil }
+	findmntErr = errors.New("exit status 1")
+	fromProc("findmnt failing")
+	findmntOut, findmntErr = []byte("findmnt: unknown column: FS-OPTIONS"), nil
+	fromProc("findmnt's output unparseable")
+
+	sys, cleanup := newFakeSysfs(t) // an empty directory to use as procfs
+	defer cleanup()
+	procDir = sys.dir
+	*ran = nil
+	if _, err := readMounts(); err == nil {
+		t.Error("readMounts with empty procfs succeeded; want error")
+	}
+	if len(*ran) != 0 {
+		t.Errorf("ran %q with procfs elsewhere", *ran)
+	}
+}
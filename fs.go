@@ -25,6 +25,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 
@@ -36,27 +38,277 @@ func getFileSystemResizer(mnt string) (Resizer, error) {
 	if err != nil {
 		return nil, err
 	}
+	if fs.fstype == "overlay" {
+		mounts, err := readMounts()
+		if err != nil {
+			return nil, err
+		}
+		upper, err := overlayUpperMount(mnt, mounts)
+		if err != nil {
+			return nil, err
+		}
+		notef("%s is an overlay whose writable layer is on %s at %s; growing that", mnt, upper.dev, upper.mnt)
+		if fs, err = statFS(upper.mnt); err != nil {
+			return nil, err
+		}
+	}
+	return fsResizerFor(fs)
+}
+
+// fsIdentity returns the LABEL and UUID of the filesystem on dev, so
+// the output says which filesystem was grown. Either is empty if it
+// can't be read.
+func fsIdentity(dev string) (label, uuid string) {
+	var err error
+	if label, err = blkidValue(dev, "LABEL"); err != nil {
+		vlogf("%v", err)
+	}
+	if uuid, err = blkidValue(dev, "UUID"); err != nil {
+		vlogf("%v", err)
+	}
+	return label, uuid
+}
+
+// nonBlockFSTypes are filesystem types that aren't backed by a local
+// block device, so there's nothing below them we could enlarge.
+var nonBlockFSTypes = map[string]bool{
+	"9p":       true,
+	"virtiofs": true,
+	"overlay":  true,
+	"tmpfs":    true,
+	"ramfs":    true,
+	"nfs":      true,
+	"nfs4":     true,
+	"cifs":     true,
+	"smb3":     true,
+	"fuse":     true,
+	"squashfs": true, // read-only
+}
+
+// fsResizerFor returns a Resizer for the filesystem fs.
+func fsResizerFor(fs fsStat) (Resizer, error) {
+	if fs.fstype == "squashfs" {
+		return nil, fmt.Errorf("squashfs at %s is read-only and can't be grown; grow the filesystem holding its writable overlay, if it has one", fs.mnt)
+	}
+	if nonBlockFSTypes[fs.fstype] || strings.HasPrefix(fs.fstype, "fuse.") {
+		return nil, fmt.Errorf("filesystem type %s at %s is not backed by a resizable block device", fs.fstype, fs.mnt)
+	}
+	target, err := fsTargetSize(fs)
+	if err != nil {
+		return nil, err
+	}
+	if note := fs.partialMountNote(); note != "" {
+		warnf("%s", note)
+	}
 	var cmd *exec.Cmd
+	var words int  // program and subcommand words in cmd.Args, before its flags
+	var why string // for --explain
 	switch fs.fstype {
 	case "ext2", "ext3", "ext4":
-		cmd = exec.Command("resize2fs", fs.dev)
-		return fsResizer{fs, cmd}, nil
+		out, err := dumpe2fs(fs.dev)
+		if err != nil {
+			vlogf("%v", err)
+		} else if err := checkOnlineGrowable(fs, parseExtFeatures(out)); err != nil {
+			return nil, err
+		}
+		cmd, words = command("resize2fs", fs.dev), 1
+		why = "which grows ext2, ext3 and ext4 given their device, mounted or not"
+		if target > 0 {
+			cmd.Args = append(cmd.Args, fmt.Sprintf("%dK", target>>10))
+		}
 	case "xfs":
-		cmd = exec.Command("xfs_growfs", "-d", fs.mnt)
-		return fsResizer{fs, cmd}, nil
+		mnt := wholeFSMount(fs)
+		cmd, words = command("xfs_growfs", "-d", mnt), 1
+		why = "as xfs can only be grown while mounted, given its mount point"
+		if target > 0 {
+			bsize, err := xfsBlockSize(mnt)
+			if err != nil {
+				return nil, err
+			}
+			cmd.Args = xfsGrowArgs(mnt, target, bsize)
+		}
 	case "btrfs":
-		cmd = exec.Command("btrfs", "filesystem", "resize", "max", fs.mnt)
-		return fsResizer{fs, cmd}, nil
+		whole := fs
+		whole.mnt = wholeFSMount(fs)
+		// A btrfs filesystem can span several devices, and "resize max"
+		// only grows devid 1, so name the devid of our device.
+		devid, err := btrfsDevid(whole)
+		if err != nil {
+			return nil, err
+		}
+		size := "max"
+		if target > 0 {
+			size = strconv.FormatInt(target, 10)
+		}
+		cmd, words = command("btrfs", "filesystem", "resize", devid+":"+size, whole.mnt), 3
+		why = fmt.Sprintf("naming %s's devid %s, as resizing without one only grows devid 1", fs.dev, devid)
+	case "bcachefs":
+		if _, err := lookPath("bcachefs"); err != nil {
+			return nil, fmt.Errorf("growing bcachefs at %s needs the bcachefs command from bcachefs-tools: %v", fs.mnt, err)
+		}
+		if fs.dev, err = bcachefsDev(fs); err != nil {
+			return nil, err
+		}
+		cmd, words = command("bcachefs", "device", "resize", fs.dev), 3
+		why = fmt.Sprintf("as %s is the bcachefs member device to grow", fs.dev)
+		if target > 0 {
+			cmd.Args = append(cmd.Args, strconv.FormatInt(target, 10))
+		}
+	case "f2fs":
+		return nil, f2fsMountedError(fs)
+	default:
+		return nil, fmt.Errorf("unsupported filesystem type %q", fs.fstype)
+	}
+	explainf("growing the %v with %s, %s", fsResizer{fs: fs}, cmd.Args[0], why)
+	args, err := insertFSArgs(cmd.Args, words, strings.Fields(*fsArgs))
+	if err != nil {
+		return nil, fmt.Errorf("--fs-args: %v", err)
+	}
+	cmd.Args = args
+	return fsResizer{fs: fs, cmd: cmd, target: target}, nil
+}
+
+// fsTargetSize returns the size in bytes to grow fs to from
+// --to-size, or zero to grow it to fill its device.
+func fsTargetSize(fs fsStat) (int64, error) {
+	if *toSize == "" {
+		return 0, nil
+	}
+	target, err := parseSize(*toSize)
+	if err != nil {
+		return 0, fmt.Errorf("--to-size: %v", err)
+	}
+	if cur := int64(fs.statfs.Blocks) * int64(fs.statfs.Bsize); target < cur {
+		return 0, fmt.Errorf("--to-size: %s filesystem at %s is already %d bytes, bigger than %d", fs.fstype, fs.mnt, cur, target)
+	}
+	return target, nil
+}
+
+// xfsBlockSize returns the data block size of the xfs filesystem
+// mounted at mnt.
+func xfsBlockSize(mnt string) (int64, error) {
+	out, err := cmdOutput(command("xfs_info", mnt))
+	if err != nil {
+		return 0, fmt.Errorf("running xfs_info %s: %v", mnt, execErrDetail(err))
+	}
+	return parseXFSBlockSize(out)
+}
+
+var xfsDataBsizeRx = regexp.MustCompile(`(?m)^data\s+=\s+bsize=(\d+)`)
+
+// parseXFSBlockSize parses the data block size from xfs_info output.
+func parseXFSBlockSize(out []byte) (int64, error) {
+	m := xfsDataBsizeRx.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("no data bsize in xfs_info output: %q", out)
+	}
+	n, err := strconv.ParseInt(string(m[1]), 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bogus data bsize in xfs_info output: %q", m[0])
+	}
+	return n, nil
+}
+
+// xfsGrowArgs returns the xfs_growfs command that grows the xfs
+// filesystem at mnt, which has bsize byte blocks, to target bytes.
+// xfs_growfs -D takes a size in filesystem blocks.
+func xfsGrowArgs(mnt string, target, bsize int64) []string {
+	return []string{"xfs_growfs", "-D", strconv.FormatInt(target/bsize, 10), mnt}
+}
+
+// insertFSArgs returns the filesystem resize command args with the
+// user's extra arguments from --fs-args inserted after its first words
+// words, ahead of our own flags and operands. It's an error for extra
+// to repeat any of those, except that xfs_growfs -D (grow to a given
+// size) replaces our -d (grow to the maximum).
+func insertFSArgs(args []string, words int, extra []string) ([]string, error) {
+	if len(extra) == 0 {
+		return args, nil
+	}
+	var ours []string
+	for _, a := range args[words:] {
+		if args[0] == "xfs_growfs" && a == "-d" && containsString(extra, "-D") {
+			continue
+		}
+		if containsString(extra, a) {
+			return nil, fmt.Errorf("%q conflicts with %s's own arguments %q", a, args[0], strings.Join(args[words:], " "))
+		}
+		ours = append(ours, a)
+	}
+	out := append([]string(nil), args[:words]...)
+	out = append(out, extra...)
+	return append(out, ours...), nil
+}
+
+// btrfsDevid returns the devid of fs.dev within the btrfs filesystem
+// mounted at fs.mnt.
+func btrfsDevid(fs fsStat) (string, error) {
+	out, err := cmdOutput(command("btrfs", "filesystem", "show", fs.mnt))
+	if err != nil {
+		return "", fmt.Errorf("running btrfs filesystem show %s: %v", fs.mnt, execErrDetail(err))
+	}
+	devid, ok := parseBtrfsDevids(out)[canonicalDev(fs.dev)]
+	if !ok {
+		return "", fmt.Errorf("device %s not found in btrfs filesystem show %s output: %s", fs.dev, fs.mnt, out)
+	}
+	return devid, nil
+}
+
+// bcachefsDev returns the member device of the bcachefs filesystem
+// fs to grow. A multi-device bcachefs is mounted from all its members
+// joined by colons ("/dev/sda2:/dev/sdb1"). Only one member is grown:
+// like with LVM PVs, the one with the most room to grow into, which is
+// usually the one on the disk that was enlarged.
+func bcachefsDev(fs fsStat) (string, error) {
+	devs := strings.Split(fs.dev, ":")
+	if len(devs) == 1 {
+		return devs[0], nil
+	}
+	best, bestUnused := devs[0], int64(0)
+	for _, dev := range devs {
+		size, err := devSizeBytes(dev)
+		if err != nil {
+			return "", fmt.Errorf("bcachefs at %s: %v", fs.mnt, err)
+		}
+		unused, err := unusedSectors(dev, size/512)
+		if err != nil {
+			return "", fmt.Errorf("bcachefs at %s: can't tell how much room %s has to grow into: %v", fs.mnt, dev, err)
+		}
+		if unused > bestUnused {
+			best, bestUnused = dev, unused
+		}
+	}
+	if bestUnused < minPVGrowth {
+		vlogf("bcachefs at %s: none of its %d members has room to grow", fs.mnt, len(devs))
+		return best, nil
+	}
+	warnf("bcachefs at %s spans %s; only growing %s, which has room to grow", fs.mnt, strings.Join(devs, ", "), best)
+	return best, nil
+}
+
+var btrfsDevidRx = regexp.MustCompile(`(?m)^\s*devid\s+(\d+)\s.*\spath\s+(\S+)\s*$`)
+
+// parseBtrfsDevids parses the output of "btrfs filesystem show" for a
+// single filesystem and returns a map from each member device's path,
+// with symlinks resolved, to its devid.
+func parseBtrfsDevids(out []byte) map[string]string {
+	m := map[string]string{}
+	for _, sm := range btrfsDevidRx.FindAllSubmatch(out, -1) {
+		m[canonicalDev(string(sm[2]))] = string(sm[1])
 	}
-	return nil, fmt.Errorf("unsupported filesystem type %q", fs.fstype)
+	return m
 }
 
 type fsResizer struct {
-	fs  fsStat
-	cmd *exec.Cmd
+	fs     fsStat
+	cmd    *exec.Cmd
+	target int64 // size in bytes to grow to, from --to-size; 0 means fill the device
 }
 
 func (e fsResizer) String() string {
+	if e.fs.mnt == "" {
+		return fmt.Sprintf("unmounted %s filesystem on %s", e.fs.fstype, e.fs.dev)
+	}
 	return fmt.Sprintf("%s filesystem at %s", e.fs.fstype, e.fs.mnt)
 }
 
@@ -67,34 +319,135 @@ func (e fsResizer) DepResizer() (Resizer, error) {
 	if dev == "/dev/root" {
 		return nil, errors.New("unexpected device /dev/root from statFS")
 	}
-	if (strings.HasPrefix(dev, "/dev/sd") ||
-		strings.HasPrefix(dev, "/dev/vd") ||
-		strings.HasPrefix(dev, "/dev/mmcblk") ||
-		strings.HasPrefix(dev, "/dev/nvme")) &&
-		devEndsInNumber(dev) {
+	if isPartitionDevName(dev) {
 		vlogf("fsResizer.DepResizer: returning partitionResizer(%q)", dev)
+		explainf("the filesystem is directly on partition %s, so there's no LVM, dm-crypt or VDO layer to grow", dev)
 		return partitionResizer(dev), nil
 	}
+	if isVDODev(dev) {
+		explainf("the filesystem is on %s, a VDO volume, which must grow before it", dev)
+		return vdoResizer(dev), nil
+	}
+	if isCryptDev(dev) {
+		explainf("the filesystem is on %s, a dm-crypt mapping, which must grow before it", dev)
+		return cryptResizer(dev), nil
+	}
 	if strings.HasPrefix(dev, "/dev/mapper") ||
 		strings.HasPrefix(filepath.Base(dev), "dm-") {
+		explainf("the filesystem is on device-mapper device %s, which isn't VDO or dm-crypt, so it's taken to be an LVM LV", dev)
 		return lvResizer(dev), nil
 	}
 	return nil, fmt.Errorf("don't know how to resize block device %q", dev)
 }
 
 func (e fsResizer) Resize() error {
+	for i, arg := range e.cmd.Args {
+		e.cmd.Args[i] = currentDev(arg) // in case the partition moved
+	}
 	if *dry {
-		fmt.Printf("[dry-run] would've run %v %v\n", e.cmd.Path, e.cmd.Args)
+		skipDryRun(e.cmd)
 		return nil
 	}
-	out, err := e.cmd.CombinedOutput()
+	if e.target > 0 {
+		devSize, err := devSizeBytes(currentDev(e.fs.dev))
+		if err != nil {
+			return err
+		}
+		if e.target > devSize {
+			return fmt.Errorf("--to-size %d is bigger than %s, which is %d bytes", e.target, e.fs.dev, devSize)
+		}
+	}
+	if e.fs.mnt == "" && isExt(e.fs.fstype) {
+		// resize2fs insists on a freshly checked filesystem when
+		// it's unmounted.
+		fsck := command("e2fsck", "-f", "-p", e.fs.dev)
+		if out, err := cmdCombinedOutput(fsck); err != nil {
+			return fmt.Errorf("running %s: %v, %s", strings.Join(fsck.Args, " "), err, capOutput(fsck.Args, out))
+		} else if err := checkOutput(fsck.Args, out); err != nil {
+			return err
+		}
+	}
+	out, err := cmdCombinedOutput(e.cmd)
 	if err != nil {
-		return fmt.Errorf("running %v %v: %v, %s", e.cmd.Path, e.cmd.Args, err, out)
+		if e.fs.fstype == "btrfs" {
+			if be := classifyBtrfsError(e.fs, out); be != nil {
+				return be
+			}
+		}
+		return fmt.Errorf("running %v %v: %v, %s", e.cmd.Path, e.cmd.Args, err, capOutput(e.cmd.Args, out))
+	}
+	if err := checkOutput(e.cmd.Args, out); err != nil {
+		return err
+	}
+	if s := e.noopSummary(out); s != "" {
+		notef("%s", s)
+	}
+	return nil
+}
+
+// Exit statuses for the btrfs resize failures classifyBtrfsError
+// recognizes.
+const (
+	exitBtrfsReadOnly    = 4
+	exitBtrfsNoSpace     = 5
+	exitBtrfsWrongDevice = 6
+)
+
+// A btrfsError is a "btrfs filesystem resize" failure whose cause is
+// known, with what to do about it.
+type btrfsError struct {
+	exitCode int
+	msg      string
+}
+
+func (e *btrfsError) Error() string { return e.msg }
+
+var btrfsResizeErrRx = regexp.MustCompile(`ERROR: unable to resize '[^']*': (.*)`)
+
+// classifyBtrfsError returns a *btrfsError for the output of a failed
+// btrfs resize of fs, or nil if the cause isn't one it knows.
+func classifyBtrfsError(fs fsStat, out []byte) error {
+	m := btrfsResizeErrRx.FindSubmatch(out)
+	if m == nil {
+		return nil
+	}
+	switch cause := strings.TrimSpace(string(m[1])); {
+	case strings.Contains(cause, "Read-only file system"):
+		return &btrfsError{exitBtrfsReadOnly, fmt.Sprintf("btrfs filesystem at %s is read-only; remount it read-write (mount -o remount,rw %s) and try again", fs.mnt, fs.mnt)}
+	case strings.Contains(cause, "No space left on device"):
+		return &btrfsError{exitBtrfsNoSpace, fmt.Sprintf("btrfs filesystem at %s has no space left to record the resize; free some space (or run btrfs balance) and try again", fs.mnt)}
+	case strings.Contains(cause, "No such device"):
+		return &btrfsError{exitBtrfsWrongDevice, fmt.Sprintf("device %s is not part of the btrfs filesystem at %s; see btrfs filesystem show %s", fs.dev, fs.mnt, fs.mnt)}
 	}
 	return nil
 }
 
+// noopRx matches the output of a filesystem resize tool that found the
+// filesystem already filling its device.
+var noopRx = regexp.MustCompile(`The filesystem is already \d+ \(\w+\) blocks long\.\s+Nothing to do!|data size unchanged, skipping`)
+
+// noopSummary returns a description of why resizing e did nothing,
+// given the output of its resize command, or the empty string if
+// the output doesn't say the resize was a no-op.
+func (e fsResizer) noopSummary(out []byte) string {
+	if !noopRx.Match(out) {
+		return ""
+	}
+	return fmt.Sprintf("%v already fills its device; nothing to do", e)
+}
+
 func (e fsResizer) State() (string, error) {
+	if e.fs.mnt == "" {
+		count := extBlockCount
+		if e.fs.fstype == "f2fs" {
+			count = f2fsBlockCount
+		}
+		n, err := count(e.fs.dev)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%v blocks", n), nil
+	}
 	st, err := statFS(e.fs.mnt)
 	if err != nil {
 		return "", err
@@ -106,6 +459,7 @@ type fsStat struct {
 	mnt    string
 	dev    string
 	fstype string
+	root   string // root of the mount within the filesystem; "/" unless a bind mount or btrfs subvolume
 	statfs unix.Statfs_t
 }
 
@@ -114,35 +468,215 @@ func statFS(mnt string) (fs fsStat, err error) {
 	if err != nil {
 		return
 	}
-	mounts, err := ioutil.ReadFile("/proc/mounts")
+	mounts, err := readMounts()
 	if err != nil {
 		return
 	}
-	bs := bufio.NewScanner(bytes.NewReader(mounts))
+	// Use the last matching mount, as that's the one visible at mnt
+	// if several were mounted on top of each other.
+	var m *mountInfo
+	for i := range mounts {
+		if mounts[i].dev == "rootfs" {
+			// See https://github.com/google/embiggen-disk/issues/6
+			continue
+		}
+		if mounts[i].mnt == mnt {
+			m = &mounts[i]
+		}
+	}
+	if m == nil {
+		return fs, errors.New("mount point not found")
+	}
+	fs.mnt = mnt
+	fs.dev = m.dev
+	fs.fstype = m.fstype
+	fs.root = m.root
+	if fs.dev == "/dev/root" {
+		dev, err := m.devRoot()
+		if err != nil {
+			return fs, fmt.Errorf("failed to map /dev/root to real device: %v", err)
+		}
+		fs.dev = dev
+	}
+	return fs, nil
+}
+
+// partialMountNote returns a warning if fs's mount shows only part of
+// its filesystem, as a bind mount or btrfs subvolume does, since it's
+// the whole filesystem that grows. It returns "" otherwise.
+func (fs fsStat) partialMountNote() string {
+	if fs.root == "" || fs.root == "/" {
+		return ""
+	}
+	what := "a bind mount of " + fs.root
+	if fs.fstype == "btrfs" {
+		what = "btrfs subvolume " + fs.root
+	}
+	return fmt.Sprintf("%s is %s on %s; growing it grows the whole %s filesystem", fs.mnt, what, fs.dev, fs.fstype)
+}
+
+// wholeFSMount returns where to point xfs_growfs or btrfs at to grow
+// fs: its mount point, unless that's a bind mount (or btrfs
+// subvolume) of part of the filesystem and the filesystem's root is
+// also mounted somewhere, in which case that.
+func wholeFSMount(fs fsStat) string {
+	if fs.root == "" || fs.root == "/" {
+		return fs.mnt
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return fs.mnt
+	}
+	if m, ok := rootMountOf(fs, mounts); ok {
+		notef("%s is a mount of %s within %s; growing it through %s, where its root is mounted", fs.mnt, fs.root, fs.dev, m.mnt)
+		return m.mnt
+	}
+	return fs.mnt
+}
+
+// rootMountOf returns the mount of the root of fs's filesystem among
+// mounts, if there's one. Overlays and other filesystems using fs
+// have other types and devices, so aren't mistaken for it.
+func rootMountOf(fs fsStat, mounts []mountInfo) (mountInfo, bool) {
+	for _, m := range mounts {
+		if m.dev == fs.dev && m.fstype == fs.fstype && m.root == "/" {
+			return m, true
+		}
+	}
+	return mountInfo{}, false
+}
+
+// isMountedDev reports whether the block device dev is mounted.
+func isMountedDev(dev string) (bool, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return false, err
+	}
+	for _, m := range mounts {
+		if m.dev == dev {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// mountInfo is a mount, as described by findmnt or by a line of
+// /proc/self/mountinfo or /proc/mounts.
+type mountInfo struct {
+	dev    string // "/dev/sda1"
+	mnt    string // "/"
+	fstype string // "ext4"
+	root   string // "/", or e.g. "/@home" for a btrfs subvolume; empty if unknown
+	devNum string // "8:1"; empty if unknown
+	opts   string // the filesystem's own options, e.g. "rw,errors=remount-ro"
+	size   int64  // bytes; 0 if unknown, as it is unless from findmnt
+	avail  int64  // bytes available to non-root users; 0 if unknown
+}
+
+// procDir is where procfs is mounted.
+var procDir = "/proc"
+
+// readMounts returns the system's mounts as listed by findmnt, or if
+// that's unavailable, from /proc/self/mountinfo, falling back to the
+// less detailed /proc/mounts if that's missing.
+func readMounts() ([]mountInfo, error) {
+	if mounts, ok := findmntMounts(); ok {
+		return mounts, nil
+	}
+	if all, err := ioutil.ReadFile(filepath.Join(procDir, "self", "mountinfo")); err == nil {
+		return parseMountInfo(all)
+	}
+	all, err := ioutil.ReadFile(filepath.Join(procDir, "mounts"))
+	if err != nil {
+		return nil, err
+	}
+	return parseProcMounts(all), nil
+}
+
+// parseMountInfo parses the contents of /proc/self/mountinfo. Lines look like:
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// See proc(5).
+func parseMountInfo(all []byte) ([]mountInfo, error) {
+	var mounts []mountInfo
+	bs := bufio.NewScanner(bytes.NewReader(all))
 	for bs.Scan() {
 		f := strings.Fields(bs.Text())
-		if len(f) < 3 {
+		if len(f) == 0 {
 			continue
 		}
-		if f[0] == "rootfs" {
-			// See https://github.com/google/embiggen-disk/issues/6
+		// The optional fields after the sixth end with a lone "-".
+		sep := -1
+		for i := 6; i < len(f); i++ {
+			if f[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(f) < 6 || sep == -1 || len(f) < sep+3 {
+			return nil, fmt.Errorf("malformed mountinfo line %q", bs.Text())
+		}
+		mounts = append(mounts, mountInfo{
+			devNum: f[2],
+			root:   unescapeMountField(f[3]),
+			mnt:    unescapeMountField(f[4]),
+			fstype: f[sep+1],
+			dev:    unescapeMountField(f[sep+2]),
+			opts:   strings.Join(f[sep+3:], " "),
+		})
+	}
+	return mounts, bs.Err()
+}
+
+// parseProcMounts parses the contents of /proc/mounts.
+func parseProcMounts(all []byte) []mountInfo {
+	var mounts []mountInfo
+	bs := bufio.NewScanner(bytes.NewReader(all))
+	for bs.Scan() {
+		f := strings.Fields(bs.Text())
+		if len(f) < 4 {
 			continue
 		}
-		if f[1] == mnt {
-			fs.mnt = mnt
-			fs.dev = f[0]
-			fs.fstype = f[2]
-			if fs.dev == "/dev/root" {
-				dev, err := findDevRoot()
-				if err != nil {
-					return fs, fmt.Errorf("failed to map /dev/root to real device: %v", err)
+		mounts = append(mounts, mountInfo{
+			dev:    unescapeMountField(f[0]),
+			mnt:    unescapeMountField(f[1]),
+			fstype: f[2],
+			opts:   f[3],
+		})
+	}
+	return mounts
+}
+
+var mountEscapeRx = regexp.MustCompile(`\\[0-7]{3}`)
+
+// unescapeMountField undoes the kernel's octal escaping of spaces,
+// tabs, newlines and backslashes in mount table fields.
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return mountEscapeRx.ReplaceAllStringFunc(s, func(esc string) string {
+		n, _ := strconv.ParseUint(esc[1:], 8, 8)
+		return string([]byte{byte(n)})
+	})
+}
+
+// devRoot maps m's "/dev/root" device to the real block device. It
+// uses the device number from mountinfo if known, and otherwise looks
+// for a device in /dev with the same device number as /dev/root.
+func (m *mountInfo) devRoot() (string, error) {
+	if m.devNum != "" {
+		uevent, err := ioutil.ReadFile(filepath.Join(sysfsDir, "dev", "block", m.devNum, "uevent"))
+		if err == nil {
+			for _, line := range strings.Split(string(uevent), "\n") {
+				if strings.HasPrefix(line, "DEVNAME=") {
+					return "/dev/" + strings.TrimPrefix(line, "DEVNAME="), nil
 				}
-				fs.dev = dev
 			}
-			return fs, err
 		}
 	}
-	return fs, errors.New("mount point not found")
+	return findDevRoot()
 }
 
 // findDevRoot finds which block device (e.g. "/dev/nvme0n1p1") patches the device number of /dev/root.
@@ -0,0 +1,349 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNoopSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		fstype string
+		out    string
+		want   string
+	}{
+		{
+			name:   "resize2fs_noop",
+			fstype: "ext4",
+			out:    "resize2fs 1.46.5 (30-Dec-2021)\nThe filesystem is already 26214139 (4k) blocks long.  Nothing to do!\n\n",
+			want:   "ext4 filesystem at / already fills its device; nothing to do",
+		},
+		{
+			name:   "resize2fs_grew",
+			fstype: "ext4",
+			out:    "resize2fs 1.46.5 (30-Dec-2021)\nFilesystem at /dev/sda1 is mounted on /; on-line resizing required\nold_desc_blocks = 7, new_desc_blocks = 13\nThe filesystem on /dev/sda1 is now 52428539 (4k) blocks long.\n\n",
+			want:   "",
+		},
+		{
+			name:   "xfs_noop",
+			fstype: "xfs",
+			out:    "meta-data=/dev/sdb1 isize=512 agcount=4, agsize=655296 blks\ndata size unchanged, skipping\n",
+			want:   "xfs filesystem at / already fills its device; nothing to do",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := fsResizer{fs: fsStat{mnt: "/", fstype: tt.fstype}}
+			if got := e.noopSummary([]byte(tt.out)); got != tt.want {
+				t.Errorf("noopSummary = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	const mountinfo = `22 28 0:20 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+28 1 8:2 / / rw,relatime shared:1 - ext4 /dev/sda2 rw,errors=remount-ro
+40 28 8:3 /@home /home rw,relatime shared:30 - btrfs /dev/sda3 rw,space_cache,subvolid=257,subvol=/@home
+41 28 8:3 /@data/srv /srv/my\040data rw,relatime shared:30 - btrfs /dev/sda3 rw,subvolid=258,subvol=/@data
+52 28 253:0 / /var rw,relatime - xfs /dev/mapper/vg-var rw
+`
+	got, err := parseMountInfo([]byte(mountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []mountInfo{
+		{dev: "sysfs", mnt: "/sys", fstype: "sysfs", root: "/", devNum: "0:20", opts: "rw"},
+		{dev: "/dev/sda2", mnt: "/", fstype: "ext4", root: "/", devNum: "8:2", opts: "rw,errors=remount-ro"},
+		{dev: "/dev/sda3", mnt: "/home", fstype: "btrfs", root: "/@home", devNum: "8:3", opts: "rw,space_cache,subvolid=257,subvol=/@home"},
+		{dev: "/dev/sda3", mnt: "/srv/my data", fstype: "btrfs", root: "/@data/srv", devNum: "8:3", opts: "rw,subvolid=258,subvol=/@data"},
+		{dev: "/dev/mapper/vg-var", mnt: "/var", fstype: "xfs", root: "/", devNum: "253:0", opts: "rw"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseMountInfo mismatch\n got: %+v\nwant: %+v", got, want)
+	}
+
+	if _, err := parseMountInfo([]byte("28 1 8:2 / / rw,relatime ext4 /dev/sda2 rw\n")); err == nil {
+		t.Error("expected error for line without separator")
+	}
+}
+
+func TestParseProcMounts(t *testing.T) {
+	const mounts = "rootfs / rootfs rw 0 0\n/dev/sda1 / ext4 rw,relatime 0 0\n/dev/sdb1 /mnt/with\\040space xfs rw 0 0\n"
+	got := parseProcMounts([]byte(mounts))
+	want := []mountInfo{
+		{dev: "rootfs", mnt: "/", fstype: "rootfs", opts: "rw"},
+		{dev: "/dev/sda1", mnt: "/", fstype: "ext4", opts: "rw,relatime"},
+		{dev: "/dev/sdb1", mnt: "/mnt/with space", fstype: "xfs", opts: "rw"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseProcMounts mismatch\n got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestParseBtrfsDevids(t *testing.T) {
+	const out = `Label: 'data'  uuid: 4d2f1e8c-5d6a-4a3e-9d6c-0f4f2f0a9b1c
+	Total devices 3 FS bytes used 1.00GiB
+	devid    1 size 10.00GiB used 2.02GiB path /dev/sda2
+	devid    2 size 20.00GiB used 0.00B path /dev/sdb1
+	devid    4 size 5.00GiB used 1.00GiB path /dev/vdc
+
+`
+	got := parseBtrfsDevids([]byte(out))
+	want := map[string]string{
+		"/dev/sda2": "1",
+		"/dev/sdb1": "2",
+		"/dev/vdc":  "4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseBtrfsDevids = %v; want %v", got, want)
+	}
+}
+
+func TestNonBlockFSTypes(t *testing.T) {
+	for _, fstype := range []string{"9p", "virtiofs", "overlay", "fuse.sshfs"} {
+		_, err := fsResizerFor(fsStat{mnt: "/", dev: "none", fstype: fstype})
+		want := "filesystem type " + fstype + " at / is not backed by a resizable block device"
+		if err == nil || err.Error() != want {
+			t.Errorf("fsResizerFor(%s) error = %v; want %q", fstype, err, want)
+		}
+	}
+	if _, err := fsResizerFor(fsStat{mnt: "/", dev: "/dev/sda1", fstype: "ext4"}); err != nil {
+		t.Errorf("fsResizerFor(ext4) = %v", err)
+	}
+}
+
+func TestBcachefsResizer(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	defer func() { warnings = nil }()
+	lookPath = func(string) (string, error) { return "/usr/sbin/bcachefs", nil }
+
+	// sdc was enlarged, leaving room after sdc1; sdb is full.
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sdb", "20971520")
+	sys.part("sdb", "sdb1", "1", "20969472")
+	sys.file("block/sdb/sdb1/start", "2048\n")
+	sys.disk("sdc", "41943040")
+	sys.part("sdc", "sdc1", "1", "20969472")
+	sys.file("block/sdc/sdc1/start", "2048\n")
+
+	e, err := fsResizerFor(fsStat{mnt: "/data", dev: "/dev/sdb1:/dev/sdc1", fstype: "bcachefs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := e.(fsResizer)
+	if got, want := strings.Join(fr.cmd.Args, " "), "bcachefs device resize /dev/sdc1"; got != want {
+		t.Errorf("command = %q; want %q", got, want)
+	}
+	if fr.fs.dev != "/dev/sdc1" {
+		t.Errorf("dev = %q; want /dev/sdc1", fr.fs.dev)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("warnings = %q; want one about the other member", warnings)
+	}
+
+	lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	if _, err := fsResizerFor(fsStat{mnt: "/data", dev: "/dev/sdb1", fstype: "bcachefs"}); err == nil || !strings.Contains(err.Error(), "bcachefs-tools") {
+		t.Errorf("without bcachefs command: %v; want error naming bcachefs-tools", err)
+	}
+}
+
+func TestInsertFSArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		words   int
+		extra   string
+		want    string
+		wantErr bool
+	}{
+		{args: []string{"resize2fs", "/dev/sda1"}, words: 1, extra: "", want: "resize2fs /dev/sda1"},
+		{args: []string{"resize2fs", "/dev/sda1"}, words: 1, extra: "-f -p", want: "resize2fs -f -p /dev/sda1"},
+		{args: []string{"xfs_growfs", "-d", "/"}, words: 1, extra: "-D 1000000", want: "xfs_growfs -D 1000000 /"},
+		{args: []string{"xfs_growfs", "-d", "/"}, words: 1, extra: "-d", wantErr: true},
+		{args: []string{"btrfs", "filesystem", "resize", "2:max", "/home"}, words: 3, extra: "--enqueue", want: "btrfs filesystem resize --enqueue 2:max /home"},
+		{args: []string{"btrfs", "filesystem", "resize", "2:max", "/home"}, words: 3, extra: "/home", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := insertFSArgs(tt.args, tt.words, strings.Fields(tt.extra))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("insertFSArgs(%q, %q) error = %v; want error: %v", tt.args, tt.extra, err, tt.wantErr)
+			continue
+		}
+		if err == nil && strings.Join(got, " ") != tt.want {
+			t.Errorf("insertFSArgs(%q, %q) = %q; want %q", tt.args, tt.extra, got, tt.want)
+		}
+	}
+}
+
+const xfsInfoOut = `meta-data=/dev/sdb1              isize=512    agcount=4, agsize=655296 blks
+         =                       sectsz=512   attr=2, projid32bit=1
+         =                       crc=1        finobt=1, sparse=1, rmapbt=0
+         =                       reflink=1    bigtime=1 inobtcount=1
+data     =                       bsize=4096   blocks=2621184, imaxpct=25
+         =                       sunit=0      swidth=0 blks
+naming   =version 2              bsize=4096   ascii-ci=0, ftype=1
+log      =internal log           bsize=4096   blocks=2560, version=2
+         =                       sectsz=512   sunit=0 blks, lazy-count=1
+realtime =none                   extsz=4096   blocks=0, rtextents=0
+`
+
+func TestXFSToSize(t *testing.T) {
+	bsize, err := parseXFSBlockSize([]byte(xfsInfoOut))
+	if err != nil || bsize != 4096 {
+		t.Fatalf("parseXFSBlockSize = %d, %v; want 4096", bsize, err)
+	}
+	if _, err := parseXFSBlockSize([]byte("naming   =version 2              bsize=4096\n")); err == nil {
+		t.Error("parseXFSBlockSize without data line: want error")
+	}
+	if got, want := strings.Join(xfsGrowArgs("/data", 100<<30, 4096), " "), "xfs_growfs -D 26214400 /data"; got != want {
+		t.Errorf("xfsGrowArgs = %q; want %q", got, want)
+	}
+
+	defer func(v string) { *toSize = v }(*toSize)
+	*toSize = "100G"
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "xfs_info" {
+			return []byte(xfsInfoOut), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	fs := fsStat{mnt: "/data", dev: "/dev/sdb1", fstype: "xfs"}
+	fs.statfs.Blocks, fs.statfs.Bsize = 2621184, 4096
+	e, err := fsResizerFor(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := e.(fsResizer)
+	if got, want := strings.Join(fr.cmd.Args, " "), "xfs_growfs -D 26214400 /data"; got != want {
+		t.Errorf("command = %q; want %q", got, want)
+	}
+	if fr.target != 100<<30 {
+		t.Errorf("target = %d; want %d", fr.target, int64(100<<30))
+	}
+
+	*toSize = "1G" // smaller than the filesystem already is
+	if _, err := fsResizerFor(fs); err == nil || !strings.Contains(err.Error(), "already") {
+		t.Errorf("--to-size smaller than filesystem: %v; want error", err)
+	}
+}
+
+func TestPartialMountNote(t *testing.T) {
+	tests := []struct {
+		fs   fsStat
+		want string
+	}{
+		{fsStat{mnt: "/", dev: "/dev/sda1", fstype: "ext4", root: "/"}, ""},
+		{fsStat{mnt: "/", dev: "/dev/sda1", fstype: "ext4"}, ""}, // from /proc/mounts; unknown
+		{fsStat{mnt: "/home", dev: "/dev/sda3", fstype: "btrfs", root: "/@home"},
+			"/home is btrfs subvolume /@home on /dev/sda3; growing it grows the whole btrfs filesystem"},
+		{fsStat{mnt: "/srv", dev: "/dev/sdb1", fstype: "xfs", root: "/data/srv"},
+			"/srv is a bind mount of /data/srv on /dev/sdb1; growing it grows the whole xfs filesystem"},
+	}
+	for _, tt := range tests {
+		if got := tt.fs.partialMountNote(); got != tt.want {
+			t.Errorf("partialMountNote(%+v) = %q; want %q", tt.fs, got, tt.want)
+		}
+	}
+}
+
+// TestWholeFSMount checks that xfs_growfs is pointed at the mount of
+// an xfs filesystem's root, not at a bind mount of part of it or an
+// overlay using it.
+func TestWholeFSMount(t *testing.T) {
+	defer func() { notes = nil }()
+	const mountinfo = `28 1 8:1 / / rw - ext4 /dev/sda1 rw
+40 28 8:17 /srv /srv rw - xfs /dev/sdb1 rw
+41 28 0:40 / /merged rw - overlay overlay rw,lowerdir=/data/lower,upperdir=/data/upper,workdir=/data/work
+42 28 8:17 / /data rw - xfs /dev/sdb1 rw
+`
+	mounts, err := parseMountInfo([]byte(mountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bind := fsStat{mnt: "/srv", dev: "/dev/sdb1", fstype: "xfs", root: "/srv"}
+	if m, ok := rootMountOf(bind, mounts); !ok || m.mnt != "/data" {
+		t.Errorf("rootMountOf(bind mount) = %+v, %v; want /data", m, ok)
+	}
+
+	proc, err := ioutil.TempDir("", "embiggen-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(proc)
+	if err := os.MkdirAll(filepath.Join(proc, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(proc, "self", "mountinfo"), []byte(mountinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { procDir = p }(procDir)
+	procDir = proc
+
+	e, err := fsResizerFor(bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.(fsResizer).cmd.Args, []string{"xfs_growfs", "-d", "/data"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("command = %q; want %q", got, want)
+	}
+	// A bind mount with no mount of its filesystem's root is used as is.
+	procDir = "/nonexistent"
+	if got := wholeFSMount(bind); got != "/srv" {
+		t.Errorf("wholeFSMount without a root mount = %q; want /srv", got)
+	}
+}
+
+func TestClassifyBtrfsError(t *testing.T) {
+	fs := fsStat{dev: "/dev/sdb1", mnt: "/data", fstype: "btrfs"}
+	tests := []struct {
+		out      string
+		wantCode int // 0 for unclassified
+		wantMsg  string
+	}{
+		{"Resize device id 1 (/dev/sdb1) from 10.00GiB to max\nERROR: unable to resize '/data': Read-only file system\n", exitBtrfsReadOnly, "mount -o remount,rw /data"},
+		{"ERROR: unable to resize '/data': No space left on device\n", exitBtrfsNoSpace, "no space left"},
+		{"ERROR: unable to resize '/data': No such device\n", exitBtrfsWrongDevice, "device /dev/sdb1 is not part of the btrfs filesystem at /data"},
+		{"ERROR: unable to resize '/data': Invalid argument\n", 0, ""},
+		{"ERROR: cannot access '/data': No such file or directory\n", 0, ""},
+	}
+	for _, tt := range tests {
+		err := classifyBtrfsError(fs, []byte(tt.out))
+		if tt.wantCode == 0 {
+			if err != nil {
+				t.Errorf("classifyBtrfsError(%q) = %v; want nil", tt.out, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+			t.Errorf("classifyBtrfsError(%q) = %v; want error containing %q", tt.out, err, tt.wantMsg)
+			continue
+		}
+		if code := errExitCode(fmt.Errorf("resizing: %w", err)); code != tt.wantCode {
+			t.Errorf("exit code for %q = %d; want %d", tt.out, code, tt.wantCode)
+		}
+	}
+}
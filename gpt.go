@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	repairGPT  = flag.Bool("repair-gpt", false, "if a GPT's backup header or partition entries are damaged, repair them with sgdisk before growing instead of refusing to continue")
+	gptReserve = flag.String("gpt-reserve", "1M", "space to leave free after the last partition on a GPT disk, for the backup GPT and alignment; at least what the backup GPT needs. --force leaves only that")
+)
+
+// gptMinReserve returns the number of sectors the backup GPT needs at
+// the end of the disk: its header and 128 partition entries of 128 bytes.
+func gptMinReserve(sectorSize int64) int64 {
+	return 1 + 16384/sectorSize
+}
+
+// gptReserveSectors returns the number of sectors to leave free after
+// the last partition on a GPT disk with the given sector size: the
+// size spec (a --gpt-reserve value) rounded up to whole sectors, but
+// no less than gptMinReserve.
+func gptReserveSectors(spec string, sectorSize int64) (int64, error) {
+	b, err := parseSize(spec)
+	if err != nil {
+		return 0, err
+	}
+	n := (b + sectorSize - 1) / sectorSize
+	if min := gptMinReserve(sectorSize); n < min {
+		n = min
+	}
+	return n, nil
+}
+
+// gptProblems returns the problems "sgdisk --verify" found in its
+// output out, ignoring the one every grown disk has: the backup GPT
+// no longer being at the end of the disk, which writing the table
+// fixes anyway.
+func gptProblems(out []byte) []string {
+	var problems []string
+	for _, para := range strings.Split(string(out), "\n\n") {
+		para = strings.Join(strings.Fields(para), " ")
+		switch {
+		case strings.HasPrefix(para, "Problem:"):
+			if strings.Contains(para, "doesn't reside at the end of the disk") {
+				continue
+			}
+		case strings.HasPrefix(para, "Caution: invalid"),
+			strings.HasPrefix(para, "Warning! Main and backup partition tables differ"):
+		default:
+			continue
+		}
+		problems = append(problems, para)
+	}
+	return problems
+}
+
+// checkGPT verifies the GPT on diskDev with sgdisk, if it's installed.
+// If the GPT is damaged, checkGPT repairs it with --repair-gpt, and
+// otherwise returns an error. It reports whether it rewrote the table.
+func checkGPT(diskDev string) (repaired bool, err error) {
+	if _, err := lookPath("sgdisk"); err != nil {
+		vlogf("sgdisk not found; not verifying GPT on %s", diskDev)
+		return false, nil
+	}
+	// sgdisk --verify exits non-zero when it finds problems, even
+	// ones we ignore, so go by its output.
+	verify := command("sgdisk", "--verify", diskDev)
+	out, err := cmdCombinedOutput(verify)
+	problems := gptProblems(out)
+	if len(problems) == 0 {
+		if err != nil && !strings.Contains(string(out), "Identified") {
+			return false, fmt.Errorf("sgdisk --verify %s: %v, %s", diskDev, err, capOutput(verify.Args, out))
+		}
+		return false, nil
+	}
+	if !*repairGPT {
+		return false, fmt.Errorf("GPT on %s is damaged; not growing it (use --repair-gpt to repair it first): %s", diskDev, strings.Join(problems, "; "))
+	}
+	warnf("repairing damaged GPT on %s: %s", diskDev, strings.Join(problems, "; "))
+	cmd := command("sgdisk", "-e", diskDev) // rewrites the backup GPT from the main one
+	if *dry {
+		skipDryRun(cmd)
+		return false, nil
+	}
+	out, err = cmdCombinedOutput(cmd)
+	if err != nil {
+		return false, fmt.Errorf("repairing GPT on %s: %v, %s", diskDev, err, capOutput(cmd.Args, out))
+	}
+	if err := checkOutput(cmd.Args, out); err != nil {
+		return true, err
+	}
+	return true, nil
+}
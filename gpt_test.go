@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	sgdiskGrownOut = `Problem: The secondary header's self-pointer indicates that it doesn't reside
+at the end of the disk. If you've added a disk to a RAID array, use the 'e'
+option on the experts' menu to adjust the secondary header's and partition
+table's locations.
+
+Identified 1 problems!
+`
+	sgdiskDamagedOut = `Caution: invalid backup GPT header, but valid main header; regenerating
+backup header from main header.
+
+Warning! Main and backup partition tables differ! Use the 'c' and 'e' options
+on the recovery & transformation menu to examine the two tables.
+
+Warning! One or more CRCs don't match. You should repair the disk!
+
+Problem: The CRC for the backup partition table is invalid. This table may be
+corrupt. Consider loading the main partition table ('c' on the recovery &
+transformation menu). This report may be a false alarm if you've already
+corrected other problems.
+
+Identified 1 problems!
+`
+)
+
+func TestCheckGPT(t *testing.T) {
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	defer func(v bool) { *repairGPT = v }(*repairGPT)
+	defer func() { warnings = nil }()
+	lookPath = func(string) (string, error) { return "/sbin/sgdisk", nil }
+
+	verifyOut := sgdiskGrownOut
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[1] == "--verify" {
+			return []byte(verifyOut), errors.New("exit status 3")
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	// A grown disk's misplaced backup GPT isn't damage.
+	if repaired, err := checkGPT("/dev/sda"); repaired || err != nil {
+		t.Fatalf("grown disk: checkGPT = %v, %v; want false, nil", repaired, err)
+	}
+
+	verifyOut = sgdiskDamagedOut
+	*repairGPT = false
+	_, err := checkGPT("/dev/sda")
+	if err == nil || !strings.Contains(err.Error(), "--repair-gpt") || !strings.Contains(err.Error(), "CRC for the backup partition table is invalid") {
+		t.Fatalf("damaged, no --repair-gpt: %v; want refusal", err)
+	}
+
+	*repairGPT = true
+	*ran = nil
+	if repaired, err := checkGPT("/dev/sda"); !repaired || err != nil {
+		t.Fatalf("damaged, --repair-gpt: checkGPT = %v, %v; want true, nil", repaired, err)
+	}
+	want := [][]string{
+		{"sgdisk", "--verify", "/dev/sda"},
+		{"sgdisk", "-e", "/dev/sda"},
+	}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %q; want %q", *ran, want)
+	}
+}
+
+func TestGPTProblems(t *testing.T) {
+	if p := gptProblems([]byte("No problems found. 2014 free sectors (1007.0 KiB) available in 1\nsegments, the largest of which is 2014 (1007.0 KiB) in size.\n")); len(p) != 0 {
+		t.Errorf("healthy GPT: problems %q", p)
+	}
+	if p := gptProblems([]byte(sgdiskDamagedOut)); len(p) != 3 {
+		t.Errorf("damaged GPT: got %d problems %q; want 3", len(p), p)
+	}
+}
+
+func TestGPTReserve(t *testing.T) {
+	tests := []struct {
+		spec       string
+		sectorSize int64
+		want       int64
+	}{
+		{spec: "1M", sectorSize: 512, want: 2048},
+		{spec: "1M", sectorSize: 4096, want: 256},
+		{spec: "4M", sectorSize: 4096, want: 1024},
+		{spec: "0", sectorSize: 512, want: 33},     // the backup GPT's minimum
+		{spec: "0", sectorSize: 4096, want: 5},     // header + 4 sectors of entries
+		{spec: "16K", sectorSize: 512, want: 33},   // 32 sectors is too few
+		{spec: "17000", sectorSize: 512, want: 34}, // rounded up
+		{spec: "20K", sectorSize: 4096, want: 5},
+	}
+	for _, tt := range tests {
+		got, err := gptReserveSectors(tt.spec, tt.sectorSize)
+		if err != nil {
+			t.Errorf("gptReserveSectors(%q, %d): %v", tt.spec, tt.sectorSize, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("gptReserveSectors(%q, %d) = %d; want %d", tt.spec, tt.sectorSize, got, tt.want)
+		}
+	}
+	if _, err := gptReserveSectors("lots", 512); err == nil {
+		t.Error("gptReserveSectors(lots) succeeded")
+	}
+
+	defer func(v string) { *gptReserve = v }(*gptReserve)
+	*gptReserve = "4M"
+	if got := growSectors(8192+4096, 512, true, false); got != 4096 {
+		t.Errorf("GPT growSectors with 4M reserve = %d; want 4096", got)
+	}
+	if got := growSectors(8192+4096, 512, false, false); got != 8192+2048 {
+		t.Errorf("MBR growSectors with 4M --gpt-reserve = %d; want the usual 1 MiB reserve", got)
+	}
+	if got := growSectors(1000, 512, true, true); got != 1000-33 {
+		t.Errorf("GPT growSectors with --force = %d; want only the backup GPT reserved", got)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var ignoreGrowpart = flag.Bool("ignore-growpart", false, "grow the root filesystem even if cloud-init's growpart module, which grows it at boot, manages it or is running")
+
+// cloudDir is the root of the filesystem holding cloud-init's
+// configuration and state.
+var cloudDir = "/"
+
+var growpartModuleRx = regexp.MustCompile(`(?m)^\s*-\s*\[?\s*['"]?growpart\b`)
+
+// growpartStatus reports whether cloud-init's growpart module is
+// enabled, and so grows the root partition at boot, and whether
+// cloud-init is still booting, so might be growing it now. why says
+// what gave growpart away.
+func growpartStatus() (enabled, booting bool, why string) {
+	files := []string{filepath.Join(cloudDir, "etc", "cloud", "cloud.cfg")}
+	more, _ := filepath.Glob(filepath.Join(cloudDir, "etc", "cloud", "cloud.cfg.d", "*.cfg"))
+	files = append(files, more...)
+	var listed, off bool
+	for _, f := range files {
+		cfg, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		if growpartModuleRx.Match(cfg) && !listed {
+			listed, why = true, f+" lists the growpart module"
+		}
+		off = off || growpartModeOff(cfg)
+	}
+	if _, err := os.Stat(filepath.Join(cloudDir, "etc", "growroot-disabled")); err == nil {
+		off = true
+	}
+	if !listed || off {
+		return false, false, ""
+	}
+	sem := filepath.Join(cloudDir, "var", "lib", "cloud", "instance", "sem", "config_growpart")
+	if _, err := os.Stat(sem); err == nil {
+		why = sem + " shows it ran this boot"
+	}
+	_, runErr := os.Stat(filepath.Join(cloudDir, "run", "cloud-init"))
+	_, doneErr := os.Stat(filepath.Join(cloudDir, "var", "lib", "cloud", "instance", "boot-finished"))
+	return true, runErr == nil && doneErr != nil, why
+}
+
+// growpartModeOff reports whether the cloud-init config cfg turns the
+// growpart module off, with "mode: off" (or false) in its top-level
+// growpart section.
+func growpartModeOff(cfg []byte) bool {
+	in := false
+	bs := bufio.NewScanner(bytes.NewReader(cfg))
+	for bs.Scan() {
+		line := bs.Text()
+		if t := strings.TrimSpace(line); t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			in = strings.HasPrefix(line, "growpart:")
+			continue
+		}
+		if f := strings.SplitN(strings.TrimSpace(line), ":", 2); in && len(f) == 2 && f[0] == "mode" {
+			switch strings.Trim(strings.TrimSpace(f[1]), `'"`) {
+			case "off", "false", "False":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkGrowpart warns if cloud-init's growpart module also grows the
+// root filesystem at mnt, and refuses to run alongside it while
+// cloud-init is still booting, unless --ignore-growpart.
+func checkGrowpart(mnt string) error {
+	if *ignoreGrowpart || mnt != "/" {
+		return nil
+	}
+	enabled, booting, why := growpartStatus()
+	if !enabled {
+		return nil
+	}
+	if booting && !*dry {
+		return fmt.Errorf("cloud-init is still booting, and its growpart module may be growing the root partition now (%s); wait for it with cloud-init status --wait, or pass --ignore-growpart", why)
+	}
+	warnf("cloud-init's growpart module grows the root partition at boot (%s), so growing it here too may be redundant; pass --ignore-growpart to skip this check", why)
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const cloudCfgSample = `# The modules that run in the 'init' stage
+cloud_init_modules:
+ - migrator
+ - seed_random
+ - bootcmd
+ - write-files
+ - growpart
+ - resizefs
+`
+
+func TestCheckGrowpart(t *testing.T) {
+	defer func(d string) { cloudDir = d }(cloudDir)
+	defer func(v bool) { *ignoreGrowpart = v }(*ignoreGrowpart)
+	defer func() { warnings = nil }()
+	td, err := ioutil.TempDir("", "embiggen-cloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	cloudDir = td
+	write := func(path, contents string) {
+		t.Helper()
+		p := filepath.Join(td, path)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(what, mnt string, wantWarn, wantErr bool) {
+		t.Helper()
+		warnings = nil
+		err := checkGrowpart(mnt)
+		if (err != nil) != wantErr {
+			t.Errorf("%s: err = %v; want error %v", what, err, wantErr)
+		}
+		if (len(warnings) > 0) != wantWarn {
+			t.Errorf("%s: warnings = %q; want warning %v", what, warnings, wantWarn)
+		}
+	}
+
+	check("no cloud-init", "/", false, false)
+
+	write("etc/cloud/cloud.cfg", cloudCfgSample)
+	write("var/lib/cloud/instance/sem/config_growpart", "")
+	write("var/lib/cloud/instance/boot-finished", "")
+	check("growpart enabled", "/", true, false)
+	if len(warnings) == 0 || !strings.Contains(warnings[0], "config_growpart") {
+		t.Errorf("warning %q doesn't say growpart ran", warnings)
+	}
+	check("not the root filesystem", "/data", false, false)
+
+	*ignoreGrowpart = true
+	check("--ignore-growpart", "/", false, false)
+	*ignoreGrowpart = false
+
+	write("run/cloud-init/status.json", "{}")
+	os.Remove(filepath.Join(td, "var/lib/cloud/instance/boot-finished"))
+	check("cloud-init booting", "/", false, true)
+
+	write("etc/cloud/cloud.cfg.d/99-growpart.cfg", "growpart:\n  mode: off\n  devices: ['/']\n")
+	check("mode: off", "/", false, false)
+}
+
+func TestGrowpartModeOff(t *testing.T) {
+	for cfg, want := range map[string]bool{
+		"growpart:\n  mode: auto\n":                        false,
+		"growpart:\n  mode: 'off'\n":                       true,
+		"growpart:\n  devices: [/]\n  mode: false\n":       true,
+		"resize_rootfs: false\nmode: off\n":                false,
+		"growpart:\n  mode: auto\nother:\n  mode: off\n":   false,
+		"growpart:\n  # mode: off\n  mode: growpart\n":     false,
+		"cloud_init_modules:\n - growpart\n":               false,
+		"growpart:\n\tmode: off\nresize_rootfs: noblock\n": true,
+	} {
+		if got := growpartModeOff([]byte(cfg)); got != want {
+			t.Errorf("growpartModeOff(%q) = %v; want %v", cfg, got, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+// layers are the layers Resize has grown so far, bottom first.
+var layers []embiggen.Layer
+
+// partTableTypes maps disks whose partitions were grown to the type
+// of their partition table: "gpt" or "dos".
+var partTableTypes = map[string]string{}
+
+// describeLayer returns the embiggen.Layer for e, which went from
+// state before to after.
+func describeLayer(e Resizer, before, after string) embiggen.Layer {
+	l := embiggen.Layer{Resizer: e.String(), Before: before, After: after, NoOp: before == after}
+	switch e := e.(type) {
+	case partitionResizer:
+		l.Kind, l.Device = "partition", string(e)
+		l.SectorSize = 512
+		if isPartitionDevName(string(e)) { // else e.g. on md, which diskDev can't map
+			if disk, err := diskDev(string(e)); err == nil {
+				l.PartitionTable = partTableTypes[disk]
+				if n, err := logicalSectorSize(disk); err == nil && n > 0 {
+					l.SectorSize = n
+				}
+			}
+		}
+		// sysfs sizes are in 512-byte units, whatever the disk's
+		// sector size.
+		var n int64
+		if _, err := fmt.Sscanf(before, "%d sectors", &n); err == nil {
+			l.BytesBefore, l.SectorsBefore = n*512, n*512/l.SectorSize
+		}
+		if _, err := fmt.Sscanf(after, "%d sectors", &n); err == nil {
+			l.BytesAfter, l.SectorsAfter = n*512, n*512/l.SectorSize
+		}
+	case pvResizer:
+		l.Kind, l.Device = "lvm-pv", string(e)
+	case lvResizer:
+		l.Kind, l.Device = "lvm-lv", string(e)
+		l.VG, l.LV = lvNames(string(e))
+	case cryptResizer:
+		l.Kind, l.Device = "dm-crypt", string(e)
+	case vdoResizer:
+		l.Kind, l.Device = "vdo", string(e)
+	case fsResizer:
+		l.Kind, l.Device = "filesystem", e.fs.dev
+		l.FSType, l.Mount = e.fs.fstype, e.fs.mnt
+	}
+	return l
+}
+
+// lvNames returns the VG and LV names of the LVM LV dev, from its
+// device name: /dev/vg/lv, or the device-mapper name "vg-lv", where
+// hyphens within either name are doubled. Both are empty if dev's
+// name isn't one of those.
+func lvNames(dev string) (vg, lv string) {
+	var dmName string
+	switch {
+	case strings.HasPrefix(dev, "/dev/mapper/"):
+		dmName = strings.TrimPrefix(dev, "/dev/mapper/")
+	case strings.HasPrefix(filepath.Base(dev), "dm-"):
+		name, err := ioutil.ReadFile(filepath.Join(sysfsDir, "block", filepath.Base(dev), "dm", "name"))
+		if err != nil {
+			return "", ""
+		}
+		dmName = strings.TrimSpace(string(name))
+	default:
+		if f := strings.Split(strings.TrimPrefix(dev, "/dev/"), "/"); len(f) == 2 {
+			return f[0], f[1]
+		}
+		return "", ""
+	}
+	unescape := func(s string) string { return strings.Replace(s, "--", "-", -1) }
+	for i := 0; i < len(dmName); i++ {
+		switch {
+		case dmName[i] != '-':
+		case i+1 < len(dmName) && dmName[i+1] == '-':
+			i++ // an escaped hyphen
+		default:
+			return unescape(dmName[:i]), unescape(dmName[i+1:])
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestDescribeLayer(t *testing.T) {
+	defer func() { partTableTypes = map[string]string{} }()
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("nvme0n1", "1000")
+	sys.file("block/nvme0n1/queue/logical_block_size", "4096\n")
+	sys.dm("dm-0", "data--vg-root", "LVM-abc")
+	partTableTypes["/dev/nvme0n1"] = "gpt"
+
+	tests := []struct {
+		e             Resizer
+		before, after string
+		want          embiggen.Layer
+	}{
+		{
+			e: partitionResizer("/dev/nvme0n1p2"), before: "2048 sectors", after: "4096 sectors",
+			want: embiggen.Layer{
+				Kind: "partition", Resizer: "partition /dev/nvme0n1p2", Device: "/dev/nvme0n1p2",
+				Before: "2048 sectors", After: "4096 sectors",
+				PartitionTable: "gpt", SectorSize: 4096,
+				SectorsBefore: 256, SectorsAfter: 512, BytesBefore: 1 << 20, BytesAfter: 2 << 20,
+			},
+		},
+		{
+			e: pvResizer("/dev/nvme0n1p2"), before: "sectors=10", after: "sectors=10",
+			want: embiggen.Layer{
+				Kind: "lvm-pv", Resizer: "LVM PV /dev/nvme0n1p2", Device: "/dev/nvme0n1p2",
+				Before: "sectors=10", After: "sectors=10", NoOp: true,
+			},
+		},
+		{
+			e: lvResizer("/dev/mapper/data--vg-root"), before: "sectors=10", after: "sectors=20",
+			want: embiggen.Layer{
+				Kind: "lvm-lv", Resizer: "LVM LV /dev/mapper/data--vg-root", Device: "/dev/mapper/data--vg-root",
+				Before: "sectors=10", After: "sectors=20", VG: "data-vg", LV: "root",
+			},
+		},
+		{
+			e: fsResizer{fs: fsStat{dev: "/dev/mapper/data--vg-root", mnt: "/", fstype: "ext4"}}, before: "1 blocks", after: "2 blocks",
+			want: embiggen.Layer{
+				Kind: "filesystem", Resizer: "ext4 filesystem at /", Device: "/dev/mapper/data--vg-root",
+				Before: "1 blocks", After: "2 blocks", FSType: "ext4", Mount: "/",
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := describeLayer(tt.e, tt.before, tt.after); got != tt.want {
+			t.Errorf("describeLayer(%v) =\n%+v\nwant\n%+v", tt.e, got, tt.want)
+		}
+	}
+}
+
+func TestLVNames(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.dm("dm-3", "vg0-my--lv", "LVM-abc")
+
+	for dev, want := range map[string][2]string{
+		"/dev/mapper/debvg-root":   {"debvg", "root"},
+		"/dev/mapper/a--b-c--d--e": {"a-b", "c-d-e"},
+		"/dev/debvg/root":          {"debvg", "root"},
+		"/dev/dm-3":                {"vg0", "my-lv"},
+		"/dev/dm-9":                {"", ""},
+		"/dev/mapper/nohyphen":     {"", ""},
+		"/dev/sda3":                {"", ""},
+	} {
+		if vg, lv := lvNames(dev); vg != want[0] || lv != want[1] {
+			t.Errorf("lvNames(%q) = %q, %q; want %q, %q", dev, vg, lv, want[0], want[1])
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+var lockWait = flag.Bool("lock-wait", false, "if another embiggen-disk is changing the same disk, wait for it to finish instead of failing")
+
+// lockDir is where the per-disk lock files go.
+var lockDir = "/run/lock"
+
+// diskLocks are the locks held by lockDisk, by disk device.
+var diskLocks = map[string]*os.File{}
+
+// lockDisk takes an exclusive lock on diskDev, so two runs don't
+// rewrite its partition table at once. The lock is held until
+// unlockDisks. Without --lock-wait it fails at once if another run
+// holds the lock.
+func lockDisk(diskDev string) error {
+	if *dry || diskLocks[diskDev] != nil {
+		return nil
+	}
+	if err := os.MkdirAll(lockDir, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(lockDir, "embiggen-disk-"+filepath.Base(diskDev)+".lock")
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	how := unix.LOCK_EX
+	if !*lockWait {
+		how |= unix.LOCK_NB
+	}
+	if err := unix.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		if err == unix.EWOULDBLOCK {
+			return fmt.Errorf("another embiggen-disk is operating on %s", diskDev)
+		}
+		return fmt.Errorf("locking %s: %v", name, err)
+	}
+	diskLocks[diskDev] = f
+	return nil
+}
+
+// unlockDisks releases the locks taken by lockDisk.
+func unlockDisks() {
+	for dev, f := range diskLocks {
+		f.Close()
+		delete(diskLocks, dev)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLockDisk(t *testing.T) {
+	defer func(d string) { lockDir = d }(lockDir)
+	defer func(v bool) { *lockWait = v }(*lockWait)
+	td, err := ioutil.TempDir("", "embiggen-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	lockDir = td
+	defer unlockDisks()
+
+	// Another run holds the lock.
+	other, err := os.Create(filepath.Join(lockDir, "embiggen-disk-sda.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := unix.Flock(int(other.Fd()), unix.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	*lockWait = false
+	if err := lockDisk("/dev/sda"); err == nil || !strings.Contains(err.Error(), "another embiggen-disk is operating on /dev/sda") {
+		t.Fatalf("lockDisk while locked = %v; want failure", err)
+	}
+	if err := lockDisk("/dev/sdb"); err != nil {
+		t.Fatalf("lockDisk of another disk = %v", err)
+	}
+
+	*lockWait = true
+	done := make(chan error, 1)
+	go func() { done <- lockDisk("/dev/sda") }()
+	select {
+	case err := <-done:
+		t.Fatalf("lockDisk with --lock-wait returned %v while locked; want it to wait", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	other.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("lockDisk with --lock-wait after unlock = %v", err)
+	}
+}
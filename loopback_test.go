@@ -0,0 +1,233 @@
+// +build linux,loopback
+
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// This file tests the whole pipeline against real tools on a loop
+// device. It needs root:
+//
+//    sudo go test -tags=loopback -run=Loopback -v
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLoopbackGrowExt4(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("skipping; must be root")
+	}
+	for _, tool := range []string{"losetup", "sfdisk", "mkfs.ext4", "resize2fs", "mount", "umount"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("skipping; %s not found", tool)
+		}
+	}
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v, %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	dir, err := ioutil.TempDir("", "embiggen-loopback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := filepath.Join(dir, "disk.img")
+	if err := ioutil.WriteFile(img, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(img, 64<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	loop := run("losetup", "--find", "--show", "--partscan", img)
+	defer run("losetup", "-d", loop)
+
+	// One small partition, leaving most of the disk free after it.
+	sfdisk := exec.Command("sfdisk", loop)
+	sfdisk.Stdin = strings.NewReader("label: gpt\nstart=2048, size=32768, type=" + linuxGPTTypeID + "\n")
+	if out, err := sfdisk.CombinedOutput(); err != nil {
+		t.Fatalf("sfdisk: %v, %s", err, out)
+	}
+	part := loop + "p1"
+	for i := 0; ; i++ {
+		if _, err := os.Stat(part); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	run("mkfs.ext4", "-q", part)
+	mnt := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run("mount", part, mnt)
+	defer run("umount", mnt)
+
+	blocks := func() uint64 {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(mnt, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st.Blocks
+	}
+	before := blocks()
+
+	// Grow the "disk".
+	if err := os.Truncate(img, 128<<20); err != nil {
+		t.Fatal(err)
+	}
+	run("losetup", "-c", loop)
+
+	res, err := Run(mnt, Options{})
+	if err != nil {
+		t.Fatalf("Run: %v; result: %+v", err, res)
+	}
+	var grewPart, grewFS bool
+	for _, c := range res.Changes {
+		t.Logf("change: %v", c)
+		grewPart = grewPart || c.Resizer == "partition "+part
+		grewFS = grewFS || strings.HasPrefix(c.Resizer, "ext4 filesystem")
+	}
+	if !grewPart || !grewFS {
+		t.Errorf("changes = %v; want partition and filesystem grown", res.Changes)
+	}
+	if after := blocks(); after < before*3 {
+		t.Errorf("filesystem went from %d to %d blocks; want it to have grown to nearly the whole 128 MiB", before, after)
+	}
+
+	// And a second run has nothing to do.
+	res, err = Run(mnt, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Changes) != 0 {
+		t.Errorf("second run changed %v; want nothing", res.Changes)
+	}
+}
+
+// TestLoopbackDMLinear grows an ext4 filesystem on a partition of a
+// dm-linear "disk" over a loop device, with its partitions mapped by
+// kpartx.
+func TestLoopbackDMLinear(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("skipping; must be root")
+	}
+	for _, tool := range []string{"losetup", "dmsetup", "kpartx", "sfdisk", "mkfs.ext4", "resize2fs", "mount", "umount"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("skipping; %s not found", tool)
+		}
+	}
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: %v, %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	dir, err := ioutil.TempDir("", "embiggen-dmlinear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	img := filepath.Join(dir, "disk.img")
+	if err := ioutil.WriteFile(img, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(img, 64<<20); err != nil {
+		t.Fatal(err)
+	}
+	loop := run("losetup", "--find", "--show", img)
+	defer run("losetup", "-d", loop)
+
+	name := "embiggen-test-" + filepath.Base(dir)
+	disk := "/dev/mapper/" + name
+	// kpartx only gives the partitions UUIDs, which is how they're
+	// told apart from other device-mapper devices, if the disk has one.
+	run("dmsetup", "create", name, "-u", "EMBIGGEN-"+name, "--table", "0 "+strconv.Itoa(64<<20/512)+" linear "+loop+" 0")
+	defer run("dmsetup", "remove", name)
+
+	sfdisk := exec.Command("sfdisk", disk)
+	sfdisk.Stdin = strings.NewReader("label: gpt\nstart=2048, size=32768, type=" + linuxGPTTypeID + "\n")
+	if out, err := sfdisk.CombinedOutput(); err != nil {
+		t.Fatalf("sfdisk: %v, %s", err, out)
+	}
+	run("kpartx", "-a", disk)
+	defer run("kpartx", "-d", disk)
+	var part string
+	for i := 0; part == ""; i++ {
+		for _, p := range []string{disk + "1", disk + "p1", disk + "-part1"} {
+			if _, err := os.Stat(p); err == nil {
+				part = p
+			}
+		}
+		if part == "" && i == 50 {
+			t.Fatalf("kpartx made no partition device for %s", disk)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	run("mkfs.ext4", "-q", part)
+	mnt := filepath.Join(dir, "mnt")
+	if err := os.Mkdir(mnt, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run("mount", part, mnt)
+	defer run("umount", mnt)
+
+	blocks := func() uint64 {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(mnt, &st); err != nil {
+			t.Fatal(err)
+		}
+		return st.Blocks
+	}
+	before := blocks()
+
+	// Grow the backing store; the dm-linear disk stays 64 MiB until
+	// embiggen-disk reloads its table.
+	if err := os.Truncate(img, 128<<20); err != nil {
+		t.Fatal(err)
+	}
+	run("losetup", "-c", loop)
+
+	res, err := Run(mnt, Options{})
+	if err != nil {
+		t.Fatalf("Run: %v; result: %+v", err, res)
+	}
+	if got := run("blockdev", "--getsize64", disk); got != strconv.Itoa(128<<20) {
+		t.Errorf("dm-linear disk is %s bytes; want %d", got, 128<<20)
+	}
+	if after := blocks(); after < before*3 {
+		t.Errorf("filesystem went from %d to %d blocks; want it to have grown to nearly the whole 128 MiB", before, after)
+	}
+}
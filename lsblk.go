@@ -0,0 +1,62 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// treeDev returns the device whose lsblk tree shows every layer of
+// e: the disk below it, or its bottom device if that isn't a
+// partition of a disk.
+func treeDev(e Resizer) (string, error) {
+	bottom, err := bottomResizer(e)
+	if err != nil {
+		return "", err
+	}
+	devs := resizerDevs(bottom)
+	if len(devs) == 0 {
+		return "", fmt.Errorf("no device below %v", e)
+	}
+	return devs[len(devs)-1], nil
+}
+
+// lsblkTree returns the lsblk tree of dev and what's on it, with sizes
+// in bytes.
+func lsblkTree(dev string) (string, error) {
+	cmd := command("lsblk", "-b", "-o", "NAME,SIZE,TYPE,FSTYPE,MOUNTPOINT", dev)
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("running %s: %v", strings.Join(cmd.Args, " "), execErrDetail(err))
+	}
+	return string(out), nil
+}
+
+// captureTree returns the lsblk tree of e's disk, or the empty string
+// if it can't be had; it's only for the operator's benefit.
+func captureTree(e Resizer) string {
+	dev, err := treeDev(e)
+	if err == nil {
+		var tree string
+		if tree, err = lsblkTree(dev); err == nil {
+			return tree
+		}
+	}
+	vlogf("not showing lsblk tree: %v", err)
+	return ""
+}
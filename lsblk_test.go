@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTreeDev(t *testing.T) {
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "lvs":
+			if containsString(args, "lv_path,vg_name,lv_size") {
+				return []byte("  /dev/vg/root:vg:20963328\n"), nil
+			}
+		case "pvdisplay":
+			return []byte("  /dev/vdz3:vg:20967424:-1:8:8:-1:4096:2559:0:2559:AAAA\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	fs, err := fsResizerFor(fsStat{dev: "/dev/mapper/vg-root", mnt: "/", fstype: "ext4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		e    Resizer
+		want string
+	}{
+		{fs, "/dev/vdz"},
+		{partitionResizer("/dev/nvme0n1p2"), "/dev/nvme0n1"},
+		{pvResizer("/dev/vdb"), "/dev/vdb"},
+	} {
+		if got, err := treeDev(tt.e); err != nil || got != tt.want {
+			t.Errorf("treeDev(%v) = %q, %v; want %q", tt.e, got, err, tt.want)
+		}
+	}
+}
+
+// TestTreeAfterGrow checks that a run that grows something captures
+// the lsblk tree before and after, and reports them.
+func TestTreeAfterGrow(t *testing.T) {
+	defer func(r, v bool) { *raw, *verbose = r, v }(*raw, *verbose)
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	blkpgResizePartition = func(string, sfdiskLine) error { return nil }
+	*raw = true
+
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdz", "41943040")
+	sys.part("vdz", "vdz3", "3", "20969472")
+	trees := []string{
+		"NAME          SIZE TYPE FSTYPE MOUNTPOINT\nvdz    21474836480 disk\n`-vdz3 10736369664 part\n",
+		"NAME          SIZE TYPE FSTYPE MOUNTPOINT\nvdz    21474836480 disk\n`-vdz3 21473787904 part\n",
+	}
+	var lsblks int
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "lsblk":
+			if args[len(args)-1] != "/dev/vdz" {
+				t.Errorf("ran %q; want the tree of /dev/vdz", args)
+			}
+			lsblks++
+			return []byte(trees[lsblks-1]), nil
+		case "/sbin/sfdisk":
+			switch args[1] {
+			case "-d":
+				return []byte("label: dos\ndevice: /dev/vdz\nunit: sectors\n\n/dev/vdz3 : start=2048, size=20969472, type=83\n"), nil
+			case "--version":
+				return []byte("sfdisk from util-linux 2.34\n"), nil
+			case "-f":
+				sys.file("block/vdz/vdz3/size", "41938944\n")
+			}
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	res, err := Run("/dev/vdz3", Options{SysfsRoot: sys.dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Changes) != 1 {
+		t.Fatalf("changes = %v; want the partition grown", res.Changes)
+	}
+	if res.TreeBefore != trees[0] || res.TreeAfter != trees[1] {
+		t.Errorf("trees = %q, %q; want %q, %q", res.TreeBefore, res.TreeAfter, trees[0], trees[1])
+	}
+	js, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(js), `"treeAfter":"NAME`) {
+		t.Errorf("JSON = %s; want treeAfter", js)
+	}
+
+	out := captureStdout(t, func() { printResult(res) })
+	if !strings.Contains(out, "Now:\n"+trees[1]) || strings.Contains(out, "Before:") {
+		t.Errorf("report = %q; want only the tree after", out)
+	}
+	*verbose = true
+	out = captureStdout(t, func() { printResult(res) })
+	if !strings.Contains(out, "Before:\n"+trees[0]) || !strings.Contains(out, "Now:\n"+trees[1]) {
+		t.Errorf("verbose report = %q; want both trees", out)
+	}
+}
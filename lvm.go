@@ -22,6 +22,8 @@ import (
 	"errors"
 	"fmt"
 	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -31,55 +33,135 @@ type lvResizer string // /dev/mapper/debianvg-root
 func (r lvResizer) String() string { return fmt.Sprintf("LVM LV %s", string(r)) }
 
 type lvState struct {
-	dev        string // 0th element in lvdisplay -c
-	vg         string // 1
-	numSectors int64  // 6
+	dev        string // as given: /dev/mapper/debvg-root
+	name       string // base name of its lv_path: "root" for /dev/debvg/root
+	vg         string // vg_name
+	numSectors int64  // lv_size, in 512-byte sectors
 }
 
 func (r lvResizer) state() (s lvState, err error) {
 	s.dev = string(r)
-	// # lvdisplay -c /dev/mapper/debvg-root
-	//   /dev/debvg/root:debvg:3:1:-1:1:8434778112:1029636:-1:0:-1:254:0
-	outb, err := exec.Command("lvdisplay", "-c", s.dev).Output()
+	// # lvs --noheadings --nosuffix --units s --separator : -o lv_path,vg_name,lv_size /dev/mapper/debvg-root
+	//   /dev/debvg/root:debvg:8434778112
+	cmd := command("lvs", "--noheadings", "--nosuffix", "--units", "s", "--separator", ":",
+		"-o", "lv_path,vg_name,lv_size", s.dev)
+	outb, err := cmdOutput(cmd)
 	if err != nil {
-		return s, fmt.Errorf("running lvdisplay -c %s: %v", s.dev, execErrDetail(err))
+		return s, fmt.Errorf("running %s: %v", strings.Join(cmd.Args, " "), execErrDetail(err))
 	}
-	f := strings.Split(strings.TrimSpace(string(outb)), ":")
-	if len(f) < 13 {
-		return s, fmt.Errorf("too few expected fields in lvdisplay -c %s output: %q", s.dev, outb)
-	}
-	s.vg = f[1]
-	s.numSectors, err = strconv.ParseInt(f[6], 10, 64)
+	lvs, err := parseLVs(outb)
 	if err != nil {
-		return s, fmt.Errorf("bogus field at index 6 in lvdisplay -c %s output: %q: %v", s.dev, outb, err)
+		return s, fmt.Errorf("lvs %s: %v", s.dev, err)
+	}
+	if len(lvs) != 1 {
+		return s, fmt.Errorf("lvs %s: got %d LVs; want 1", s.dev, len(lvs))
 	}
+	s.name, s.vg, s.numSectors = lvs[0].name, lvs[0].vg, lvs[0].numSectors
 	return s, nil
 }
 
+// parseLVs parses the output of "lvs --noheadings --nosuffix --units s
+// --separator : -o lv_path,vg_name,lv_size" into LVs by their lv_path.
+func parseLVs(out []byte) ([]lvState, error) {
+	var lvs []lvState
+	bs := bufio.NewScanner(bytes.NewReader(out))
+	for bs.Scan() {
+		line := strings.TrimSpace(bs.Text())
+		if line == "" {
+			continue
+		}
+		f := strings.Split(line, ":")
+		if len(f) != 3 {
+			return nil, fmt.Errorf("bogus line %q; want lv_path:vg_name:lv_size", line)
+		}
+		n, err := strconv.ParseInt(f[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bogus lv_size in line %q: %v", line, err)
+		}
+		lvs = append(lvs, lvState{dev: f[0], name: filepath.Base(f[0]), vg: f[1], numSectors: n})
+	}
+	return lvs, bs.Err()
+}
+
 func (r lvResizer) DepResizer() (Resizer, error) {
 	lvs, err := r.state()
 	if err != nil {
 		return nil, err
 	}
 
-	out, err := exec.Command("pvdisplay", "-c").Output()
+	out, err := cmdOutput(command("pvdisplay", "-c"))
 	if err != nil {
 		return nil, fmt.Errorf("running pvdisplay -c: %v", execErrDetail(err))
 	}
-	bs := bufio.NewScanner(bytes.NewReader(out))
+	pvs := vgPVs(out, lvs.vg)
+	if len(pvs) == 0 {
+		return nil, nil
+	}
+	if len(pvs) == 1 {
+		explainf("LVM LV %s is in VG %s, whose only PV is %s, so that PV is grown first", string(r), lvs.vg, pvs[0].dev)
+		return pvResizer(pvs[0].dev), nil
+	}
+	// The VG spans several PVs, probably on different disks. Grow
+	// the one with room to grow into, which is usually the one on
+	// the disk that was enlarged; lvextend then takes the new space
+	// from the VG as a whole.
+	// TODO: grow more than one, if more than one disk grew. Probably
+	// change the DepResizer method to return []Resizer.
+	best, bestUnused := pvs[0], int64(0)
+	for _, pv := range pvs {
+		unused, err := unusedSectors(pv.dev, pv.sectors)
+		if err != nil {
+			vlogf("can't tell how much space %s has to grow into: %v", pv.dev, err)
+			continue
+		}
+		if unused > bestUnused {
+			best, bestUnused = pv, unused
+		}
+	}
+	if bestUnused < minPVGrowth {
+		vlogf("LVM VG %s: none of its %d PVs has room to grow", lvs.vg, len(pvs))
+		explainf("LVM VG %s has %d PVs, none with room to grow, so only the LV is grown, into the VG's free space", lvs.vg, len(pvs))
+		return pvResizer(best.dev), nil
+	}
+	disk := best.dev
+	if disks, err := backingDisks(best.dev); err == nil && len(disks) == 1 {
+		disk = disks[0]
+	}
+	// DepResizer runs on every walk of the chain, so leave reporting
+	// this to Resize, which runs once.
+	explainf("LVM VG %s has %d PVs; %s has the most room to grow into (%d sectors), so it's the one grown", lvs.vg, len(pvs), best.dev, bestUnused)
+	lvGrowthSources[string(r)] = fmt.Sprintf("LVM VG %s spans %d PVs; grew PV %s with the %d new sectors on %s", lvs.vg, len(pvs), best.dev, bestUnused, disk)
+	return pvResizer(best.dev), nil
+}
+
+// lvGrowthSources maps LVs in multi-PV VGs to which PV and disk their
+// new space came from, for lvResizer.Resize to report.
+var lvGrowthSources = map[string]string{}
+
+// minPVGrowth is the least space, in 512-byte sectors, worth growing
+// a PV into: a single extent of the default size, 4 MiB.
+const minPVGrowth = 8192
+
+// A pvInfo is a PV's line in "pvdisplay -c" output.
+type pvInfo struct {
+	dev     string
+	sectors int64 // size in 512-byte sectors
+}
+
+// vgPVs returns the PVs of the volume group vg from the output of
+// "pvdisplay -c".
+func vgPVs(pvdisplayOut []byte, vg string) []pvInfo {
+	var pvs []pvInfo
+	bs := bufio.NewScanner(bytes.NewReader(pvdisplayOut))
 	for bs.Scan() {
 		f := strings.Split(strings.TrimSpace(bs.Text()), ":")
-		if len(f) < 2 || f[1] != lvs.vg {
+		if len(f) < 3 || f[1] != vg {
 			continue
 		}
-		dev := f[0]
-		// TODO: support LVs with more than one PV. But that's
-		// not a problem I have with cloudy things. So skip
-		// for now. Probably change the DepResizer method to
-		// return []Resizer.
-		return pvResizer(dev), nil
+		n, _ := strconv.ParseInt(f[2], 10, 64)
+		pvs = append(pvs, pvInfo{dev: f[0], sectors: n})
 	}
-	return nil, nil
+	return pvs
 }
 
 func (r lvResizer) State() (string, error) {
@@ -90,23 +172,166 @@ func (r lvResizer) State() (string, error) {
 	return fmt.Sprintf("sectors=%d", lvs.numSectors), nil
 }
 
+var (
+	lvExtendPercentRx = regexp.MustCompile(`^\+?(\d+)%FREE$`)
+	lvExtendSizeRx    = regexp.MustCompile(`^\+\d+[bBsSkKmMgGtTpPeE]?$`)
+)
+
+// lvExtendArgs returns the lvextend flags that grow an LV by spec,
+// the value of --lv-extend: "80%FREE" or "+50G".
+func lvExtendArgs(spec string) ([]string, error) {
+	if m := lvExtendPercentRx.FindStringSubmatch(spec); m != nil {
+		if pct, err := strconv.Atoi(m[1]); err != nil || pct < 1 || pct > 100 {
+			return nil, fmt.Errorf("percentage in %q must be from 1 to 100", spec)
+		}
+		return []string{"-l", "+" + m[1] + "%FREE"}, nil
+	}
+	if lvExtendSizeRx.MatchString(spec) {
+		return []string{"-L", spec}, nil
+	}
+	return nil, fmt.Errorf("invalid size %q; want a form like 80%%FREE or +50G", spec)
+}
+
 func (r lvResizer) Resize() error {
 	lvDev := string(r)
+	var cmds []*exec.Cmd
+	if *lvPlan != "" {
+		var err error
+		if cmds, err = r.planCommands(); err != nil {
+			return err
+		}
+	} else {
+		args, err := lvExtendArgs(*lvExtend)
+		if err != nil {
+			return err
+		}
+		cmds = append(cmds, command("lvextend", append(args, lvDev)...))
+	}
+	if err := r.growThinMetadata(); err != nil {
+		warnf("%v", err)
+	}
+	for _, cmd := range cmds {
+		if err := runLVExtend(cmd); err != nil {
+			return err
+		}
+	}
+	if *dry {
+		return nil
+	}
+	if src, ok := lvGrowthSources[lvDev]; ok {
+		lvs, err := r.state()
+		if err != nil {
+			return err
+		}
+		notef("%s; %v is now %d sectors", src, r, lvs.numSectors)
+	}
+	return nil
+}
+
+// runLVExtend runs the lvextend command cmd, which isn't an error if
+// there was nothing to grow.
+func runLVExtend(cmd *exec.Cmd) error {
 	if *dry {
-		fmt.Printf("[dry-run] would've run lvextend -l +100%%FREE %s", lvDev)
+		skipDryRun(cmd)
 		return nil
 	}
-	_, err := exec.Command("lvextend", "-l", "+100%FREE", lvDev).Output()
+	// Combined, so --strict sees the warnings lvextend prints to stderr.
+	out, err := cmdCombinedOutput(cmd)
 	if err != nil {
-		ee, ok := err.(*exec.ExitError)
-		if ok && strings.Contains(string(ee.Stderr), "matches existing size") {
+		if strings.Contains(string(out), "matches existing size") {
 			return nil
 		}
-		var extraMsg string
-		if ok && len(ee.Stderr) > 0 {
-			extraMsg = fmt.Sprintf("; stderr=%s", ee.Stderr)
-		}
-		return fmt.Errorf("lvextend on %s: %v%s", lvDev, err, extraMsg)
+		return fmt.Errorf("lvextend on %s: %v; output=%s", cmd.Args[len(cmd.Args)-1], err, capOutput(cmd.Args, out))
+	}
+	return checkOutput(cmd.Args, out)
+}
+
+// thinMetaFullPercent is how full a thin pool's metadata LV can be
+// before growing the pool's data has us grow its metadata too.
+const thinMetaFullPercent = 75
+
+// A thinPoolUsage is what lvs reports about a possible thin pool.
+type thinPoolUsage struct {
+	isPool      bool
+	metaPercent float64
+	metaSectors int64
+}
+
+// parseThinPoolUsage parses the output of
+// "lvs --noheadings --nosuffix --units s --separator : -o lv_attr,metadata_percent,lv_metadata_size".
+func parseThinPoolUsage(out []byte) (u thinPoolUsage, err error) {
+	f := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(f) != 3 || f[0] == "" {
+		return u, fmt.Errorf("bogus lvs output %q", out)
+	}
+	if f[0][0] != 't' {
+		return u, nil // not a thin pool
+	}
+	u.isPool = true
+	if u.metaPercent, err = strconv.ParseFloat(f[1], 64); err != nil {
+		return u, fmt.Errorf("bogus metadata_percent in lvs output %q", out)
+	}
+	if u.metaSectors, err = strconv.ParseInt(strings.TrimSuffix(f[2], "S"), 10, 64); err != nil {
+		return u, fmt.Errorf("bogus lv_metadata_size in lvs output %q", out)
+	}
+	return u, nil
+}
+
+// parseThinPool parses the output of
+// "lvs --noheadings --separator : -o vg_name,lv_name,pool_lv,lv_attr"
+// and returns the thin pool ("vg/pool") that the LV is, or whose thin
+// volume it is, or the empty string if neither.
+func parseThinPool(out []byte) (string, error) {
+	f := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(f) != 4 || f[0] == "" || f[1] == "" || f[3] == "" {
+		return "", fmt.Errorf("bogus lvs output %q", out)
+	}
+	vg, lv, pool, attr := f[0], f[1], f[2], f[3]
+	switch {
+	case attr[0] == 't':
+		return vg + "/" + lv, nil
+	case attr[0] == 'V' && pool != "":
+		return vg + "/" + pool, nil
+	}
+	return "", nil
+}
+
+// growThinMetadata doubles the metadata LV (the pool's _tmeta) of the
+// thin pool that r is, or that r is a thin volume in, if it's nearly
+// full, so it can track the bigger data area that growing r will use.
+func (r lvResizer) growThinMetadata() error {
+	lvDev := string(r)
+	out, err := cmdOutput(command("lvs", "--noheadings", "--separator", ":",
+		"-o", "vg_name,lv_name,pool_lv,lv_attr", lvDev))
+	if err != nil {
+		return fmt.Errorf("checking whether %s is thin: %v", lvDev, execErrDetail(err))
+	}
+	pool, err := parseThinPool(out)
+	if err != nil || pool == "" {
+		return err
+	}
+	out, err = cmdOutput(command("lvs", "--noheadings", "--nosuffix", "--units", "s", "--separator", ":",
+		"-o", "lv_attr,metadata_percent,lv_metadata_size", pool))
+	if err != nil {
+		return fmt.Errorf("checking thin pool metadata of %s: %v", pool, execErrDetail(err))
+	}
+	u, err := parseThinPoolUsage(out)
+	if err != nil {
+		return fmt.Errorf("checking thin pool metadata of %s: %v", pool, err)
+	}
+	if !u.isPool || u.metaPercent < thinMetaFullPercent {
+		return nil
+	}
+	cmd := command("lvextend", "--poolmetadatasize", fmt.Sprintf("+%ds", u.metaSectors), pool)
+	if *dry {
+		skipDryRun(cmd)
+		return nil
+	}
+	vlogf("thin pool %s metadata is %.1f%% full; growing it", pool, u.metaPercent)
+	if out, err := cmdCombinedOutput(cmd); err != nil {
+		return fmt.Errorf("thin pool %s metadata is %.1f%% full and growing it failed (grow it before the pool fills): %v, %s", pool, u.metaPercent, err, capOutput(cmd.Args, out))
+	} else if err := checkOutput(cmd.Args, out); err != nil {
+		return err
 	}
 	return nil
 }
@@ -116,35 +341,142 @@ type pvResizer string // "/dev/sda3" or potentially a whole disk e.g. "/dev/sdb"
 func (r pvResizer) String() string { return fmt.Sprintf("LVM PV %s", string(r)) }
 
 func (r pvResizer) State() (string, error) {
-	dev := string(r)
-	out, err := exec.Command("pvdisplay", "-c", dev).Output()
+	n, err := r.sectors()
 	if err != nil {
-		return "", errors.New(execErrDetail(err))
+		return "", err
+	}
+	return fmt.Sprintf("sectors=%v", n), nil
+}
+
+// sectors returns the size of the PV, in 512-byte sectors.
+func (r pvResizer) sectors() (int64, error) {
+	dev := currentDev(string(r))
+	out, err := cmdOutput(command("pvdisplay", "-c", dev))
+	if err != nil {
+		return 0, errors.New(execErrDetail(err))
 	}
 	f := strings.Split(strings.TrimSpace(string(out)), ":")
 	if len(f) < 3 {
-		return "", fmt.Errorf("bogus pvdisplay -c %s output: %q", dev, out)
+		return 0, fmt.Errorf("bogus pvdisplay -c %s output: %q", dev, out)
 	}
-	return fmt.Sprintf("sectors=%v", f[2]), nil
+	n, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bogus size field in pvdisplay -c %s output: %q", dev, out)
+	}
+	return n, nil
 }
 
 func (r pvResizer) Resize() error {
-	dev := string(r)
+	dev := currentDev(string(r))
 	if *dry {
-		fmt.Printf("[dry-run] would've run pvresize %v", dev)
+		skipDryRun(command("pvresize", dev))
 		return nil
 	}
-	out, err := exec.Command("pvresize", dev).CombinedOutput()
+	before, err := r.sectors()
+	if err != nil {
+		return err
+	}
+	cmd := command("pvresize", dev)
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("pvresize %s: %v, %s", dev, err, capOutput(cmd.Args, out))
+	}
+	if err := checkOutput(cmd.Args, out); err != nil {
+		return err
+	}
+	res, err := parsePVResize(out)
 	if err != nil {
-		return fmt.Errorf("pvresize %s: %v, %s", dev, err, out)
+		return fmt.Errorf("pvresize %s: %v", dev, err)
 	}
+	if res.notResized > 0 || res.resized == 0 {
+		return fmt.Errorf("pvresize %s didn't resize the PV: %s", dev, capOutput(cmd.Args, out))
+	}
+	after, err := r.sectors()
+	if err != nil {
+		return err
+	}
+	notef("%s", pvResizeSummary(r, before, after))
 	return nil
 }
 
+// pvResizeResult is the summary pvresize prints after running.
+type pvResizeResult struct {
+	resized    int // physical volume(s) resized or updated
+	notResized int // physical volume(s) not resized
+}
+
+var (
+	pvResizedRx    = regexp.MustCompile(`(\d+) physical volume\(s\) resized`)
+	pvNotResizedRx = regexp.MustCompile(`(\d+) physical volume\(s\) not resized`)
+)
+
+// parsePVResize parses the output of pvresize.
+//
+// pvresize reports success the same way whether or not the PV's size
+// changed, so callers need to compare the PV size before and after
+// to see whether it grew.
+func parsePVResize(out []byte) (res pvResizeResult, err error) {
+	m := pvResizedRx.FindSubmatch(out)
+	if m == nil {
+		return res, fmt.Errorf("no summary line in pvresize output: %q", out)
+	}
+	res.resized, _ = strconv.Atoi(string(m[1]))
+	if m := pvNotResizedRx.FindSubmatch(out); m != nil {
+		res.notResized, _ = strconv.Atoi(string(m[1]))
+	}
+	return res, nil
+}
+
+// pvResizeSummary describes the outcome of a successful pvresize of r,
+// given its size in sectors before and after.
+func pvResizeSummary(r pvResizer, before, after int64) string {
+	if after <= before {
+		return fmt.Sprintf("%v: no free space to grow into; size unchanged at %d sectors", r, after)
+	}
+	return fmt.Sprintf("%v: grew by %d sectors, from %d to %d", r, after-before, before, after)
+}
+
+// getOrphanPVResizer returns a Resizer for dev, an LVM PV that isn't
+// in any VG. Such a PV can still be grown into its partition's new
+// space, but has no LVs or filesystems above it to grow.
+func getOrphanPVResizer(dev string) (Resizer, error) {
+	dev = canonicalDev(dev)
+	out, err := cmdOutput(command("pvdisplay", "-c", dev))
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a mount point nor an LVM PV: %v", dev, execErrDetail(err))
+	}
+	f := strings.Split(strings.TrimSpace(string(out)), ":")
+	if len(f) < 3 {
+		return nil, fmt.Errorf("bogus pvdisplay -c %s output: %q", dev, out)
+	}
+	if vg := f[1]; !isOrphanVG(vg) {
+		return nil, fmt.Errorf("%s is a PV in LVM VG %s; pass the mount point of a filesystem on it instead", dev, vg)
+	}
+	notef("%s is an LVM PV in no VG; growing only the PV", dev)
+	return pvResizer(dev), nil
+}
+
+// isOrphanVG reports whether vg, the VG field of "pvdisplay -c"
+// output, means the PV isn't in a VG. Depending on the LVM version
+// that's either empty or "#orphans_lvm2".
+func isOrphanVG(vg string) bool {
+	return vg == "" || strings.HasPrefix(vg, "#orphans")
+}
+
 func (r pvResizer) DepResizer() (Resizer, error) {
 	dev := string(r)
-	if devEndsInNumber(dev) {
+	if isVDODev(dev) {
+		explainf("LVM PV %s is a VDO volume, which must grow before it", dev)
+		return vdoResizer(dev), nil
+	}
+	if isCryptDev(dev) {
+		explainf("LVM PV %s is a dm-crypt mapping, which must grow before it", dev)
+		return cryptResizer(dev), nil
+	}
+	if isPartitionDevName(dev) {
+		explainf("LVM PV %s is a partition, which must grow before it", dev)
 		return partitionResizer(dev), nil
 	}
+	explainf("LVM PV %s is a whole disk, so there's no partition to grow", dev)
 	return nil, nil
 }
@@ -0,0 +1,337 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPVResize(t *testing.T) {
+	const pvresizeOut = "  Physical volume \"/dev/sda3\" changed\n  1 physical volume(s) resized or updated / 0 physical volume(s) not resized\n"
+	tests := []struct {
+		name          string
+		out           string
+		before, after int64
+		wantErr       bool
+		want          pvResizeResult
+		wantSummary   string
+	}{
+		{
+			name:        "grew",
+			out:         pvresizeOut,
+			before:      8442544128,
+			after:       8444641280,
+			want:        pvResizeResult{resized: 1},
+			wantSummary: "LVM PV /dev/sda3: grew by 2097152 sectors, from 8442544128 to 8444641280",
+		},
+		{
+			name:        "no_change",
+			out:         pvresizeOut,
+			before:      8444641280,
+			after:       8444641280,
+			want:        pvResizeResult{resized: 1},
+			wantSummary: "LVM PV /dev/sda3: no free space to grow into; size unchanged at 8444641280 sectors",
+		},
+		{
+			name: "not_resized",
+			out:  "  Failed to find physical volume \"/dev/sda9\".\n  0 physical volume(s) resized or updated / 1 physical volume(s) not resized\n",
+			want: pvResizeResult{notResized: 1},
+		},
+		{
+			name:    "garbage",
+			out:     "something unexpected\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePVResize([]byte(tt.out))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePVResize error = %v; want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parsePVResize = %+v; want %+v", got, tt.want)
+			}
+			if tt.wantSummary == "" {
+				return
+			}
+			if got := pvResizeSummary(pvResizer("/dev/sda3"), tt.before, tt.after); got != tt.wantSummary {
+				t.Errorf("summary = %q; want %q", got, tt.wantSummary)
+			}
+		})
+	}
+}
+
+func TestLVExtendArgs(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{spec: "100%FREE", want: "-l +100%FREE"},
+		{spec: "80%FREE", want: "-l +80%FREE"},
+		{spec: "+80%FREE", want: "-l +80%FREE"},
+		{spec: "+50G", want: "-L +50G"},
+		{spec: "+512m", want: "-L +512m"},
+		{spec: "50G", wantErr: true}, // absolute size; could shrink
+		{spec: "0%FREE", wantErr: true},
+		{spec: "101%FREE", wantErr: true},
+		{spec: "80%VG", wantErr: true},
+		{spec: "+1.5G", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		args, err := lvExtendArgs(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("lvExtendArgs(%q) error = %v; want error: %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got := strings.Join(args, " "); got != tt.want {
+			t.Errorf("lvExtendArgs(%q) = %q; want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestLVDepResizerTwoDiskVG(t *testing.T) {
+	defer func() { notes, lvGrowthSources = nil, map[string]string{} }()
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	// sda is full; sdb grew from 20 GiB to 30 GiB.
+	sys.disk("sda", "41943040")
+	sys.part("sda", "sda1", "1", "1048576")
+	sys.file("block/sda/sda1/start", "2048\n")
+	sys.part("sda", "sda2", "2", "40890368")
+	sys.file("block/sda/sda2/start", "1050624\n")
+	sys.disk("sdb", "62914560")
+	sys.part("sdb", "sdb1", "1", "41940992")
+	sys.file("block/sdb/sdb1/start", "2048\n")
+
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "lvs":
+			if containsString(args, "lv_path,vg_name,lv_size") {
+				return []byte("  /dev/vg/root:vg:82821120\n"), nil
+			}
+		case "pvdisplay":
+			return []byte("  /dev/sda2:vg:40886272:-1:8:8:-1:4096:4991:0:4991:AAAA\n" +
+				"  /dev/sdb1:vg:41936896:-1:8:8:-1:4096:5119:0:5119:BBBB\n" +
+				"  /dev/sdc1:othervg:41936896:-1:8:8:-1:4096:5119:0:5119:CCCC\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	dep, err := lvResizer("/dev/mapper/vg-root").DepResizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dep != pvResizer("/dev/sdb1") {
+		t.Errorf("DepResizer = %v; want LVM PV /dev/sdb1, on the disk that grew", dep)
+	}
+	if len(notes) != 0 {
+		t.Errorf("notes = %q before resizing; want none", notes)
+	}
+
+	if err := lvResizer("/dev/mapper/vg-root").Resize(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"LVM VG vg spans 2 PVs; grew PV /dev/sdb1 with the 20975616 new sectors on /dev/sdb; LVM LV /dev/mapper/vg-root is now 82821120 sectors"}
+	if !reflect.DeepEqual(notes, want) {
+		t.Errorf("notes = %q; want %q", notes, want)
+	}
+}
+
+// Some installers put the PV on a partition typed as a generic Linux
+// filesystem rather than as LVM. What's on the partition decides the
+// path taken, so that must grow the same way.
+func TestPVOnLinuxTypedPartition(t *testing.T) {
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "lvs":
+			if containsString(args, "lv_path,vg_name,lv_size") {
+				return []byte("  /dev/vg/root:vg:82821120\n"), nil
+			}
+		case "pvdisplay":
+			return []byte("  /dev/sda3:vg:41936896:-1:8:8:-1:4096:5119:0:5119:AAAA\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	pv, err := lvResizer("/dev/mapper/vg-root").DepResizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := pv.DepResizer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part != partitionResizer("/dev/sda3") {
+		t.Fatalf("PV's DepResizer = %v; want partition /dev/sda3", part)
+	}
+	pt, err := parsePartitionTable([]byte(`label: gpt
+device: /dev/sda
+unit: sectors
+
+/dev/sda1 : start=2048, size=1048576, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+/dev/sda3 : start=1050624, size=41940992, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, _ := pt.lastPartition()
+	if err := checkGrowableType(last, true); err != nil {
+		t.Errorf("Linux-typed PV partition rejected: %v", err)
+	}
+}
+
+func TestOrphanPV(t *testing.T) {
+	defer func() { notes = nil }()
+	pvdisplay := ""
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "pvdisplay" {
+			return []byte(pvdisplay), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	for _, out := range []string{
+		"  /dev/sdb1::20969472:-1:0:0:-1:0:0:0:0:AAAA\n",
+		"  /dev/sdb1:#orphans_lvm2:20969472:-1:0:0:-1:0:0:0:0:AAAA\n",
+	} {
+		pvdisplay = out
+		e, err := getOrphanPVResizer("/dev/sdb1")
+		if err != nil {
+			t.Fatalf("pvdisplay %q: %v", out, err)
+		}
+		if e != pvResizer("/dev/sdb1") {
+			t.Errorf("pvdisplay %q: resizer = %v; want LVM PV /dev/sdb1", out, e)
+		}
+		if dep, _ := e.DepResizer(); dep != partitionResizer("/dev/sdb1") {
+			t.Errorf("pvdisplay %q: dep = %v; want the partition", out, dep)
+		}
+	}
+	if len(notes) != 2 || !strings.Contains(notes[0], "in no VG") {
+		t.Errorf("notes = %q; want the orphan PV reported", notes)
+	}
+
+	pvdisplay = "  /dev/sdb1:vg:20969472:-1:8:8:-1:4096:2559:0:2559:AAAA\n"
+	if _, err := getOrphanPVResizer("/dev/sdb1"); err == nil || !strings.Contains(err.Error(), "in LVM VG vg") {
+		t.Errorf("PV in a VG: err = %v; want refusal", err)
+	}
+}
+
+func TestGrowThinMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		lv      string // lvs output for the LV: vg, name, pool and attr
+		pool    string // lvs output for its pool: attr, metadata use and size
+		wantRan string // last command run, if it's not lvs
+	}{
+		{name: "plain_lv", lv: "  vg:root::-wi-ao----\n"},
+		{name: "pool_roomy", lv: "  vg:pool::twi-aotz--\n", pool: "  twi-aotz--:12.50:8192S\n"},
+		{name: "pool_nearly_full", lv: "  vg:pool::twi-aotz--\n", pool: "  twi-aotz--:85.20:8192S\n", wantRan: "lvextend --poolmetadatasize +8192s vg/pool"},
+		{name: "thin_volume_roomy", lv: "  vg:data:pool:Vwi-aotz--\n", pool: "  twi-aotz--:12.50:8192S\n"},
+		{name: "thin_volume_nearly_full", lv: "  vg:data:pool:Vwi-aotz--\n", pool: "  twi-aotz--:85.20:8192S\n", wantRan: "lvextend --poolmetadatasize +8192s vg/pool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+				if args[0] != "lvs" {
+					return nil, nil
+				}
+				switch last := args[len(args)-1]; last {
+				case "/dev/mapper/vg-lv":
+					return []byte(tt.lv), nil
+				case "vg/pool":
+					return []byte(tt.pool), nil
+				default:
+					t.Fatalf("lvs of unexpected LV %q", last)
+				}
+				return nil, nil
+			})
+			defer restore()
+			if err := lvResizer("/dev/mapper/vg-lv").growThinMetadata(); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if last := (*ran)[len(*ran)-1]; last[0] != "lvs" {
+				got = strings.Join(last, " ")
+			}
+			if got != tt.wantRan {
+				t.Errorf("ran %q; want %q", got, tt.wantRan)
+			}
+		})
+	}
+}
+
+func TestParseLVs(t *testing.T) {
+	const out = `  /dev/debvg/root:debvg:8434778112
+  /dev/debvg/swap_1:debvg:16777216
+`
+	got, err := parseLVs([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lvState{
+		{dev: "/dev/debvg/root", name: "root", vg: "debvg", numSectors: 8434778112},
+		{dev: "/dev/debvg/swap_1", name: "swap_1", vg: "debvg", numSectors: 16777216},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseLVs = %+v; want %+v", got, want)
+	}
+	// lvdisplay -c's format, which this replaced, is rejected.
+	if _, err := parseLVs([]byte("  /dev/debvg/root:debvg:3:1:-1:1:8434778112:1029636:-1:0:-1:254:0\n")); err == nil {
+		t.Error("parseLVs of lvdisplay -c output: want error")
+	}
+}
+
+// TestLVMDryRun checks that --dry-run only reads LVM's state, and says
+// what it would've run.
+func TestLVMDryRun(t *testing.T) {
+	defer func(v bool) { *dry = v }(*dry)
+	*dry = true
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "lvs" {
+			return []byte("  vg:root::-wi-ao----\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	out := captureStdout(t, func() {
+		if err := lvResizer("/dev/mapper/vg-root").Resize(); err != nil {
+			t.Fatal(err)
+		}
+		if err := pvResizer("/dev/sda3").Resize(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, args := range *ran {
+		if args[0] != "lvs" {
+			t.Errorf("dry run ran %q", args)
+		}
+	}
+	want := "[dry-run] would've run lvextend -l +100%FREE /dev/mapper/vg-root\n" +
+		"[dry-run] would've run pvresize /dev/sda3\n"
+	if out != want {
+		t.Errorf("dry run printed %q; want %q", out, want)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var lvPlan = flag.String("lv-plan", "", `instead of growing just the filesystem's LV by --lv-extend, grow named LVs in its VG: a comma-separated list of name=size, with sizes as for --lv-extend (e.g. "root=+10G,data=100%FREE"). Sizes are taken from the VG's free space before percentages. The other LVs' filesystems are grown with lvextend --resizefs`)
+
+var growOrder = flag.String("grow-order", "as-listed", `with --lv-plan, the order to grow the LVs given percentages of the VG's free space in, each taking its share of what those before it left: "as-listed", "by-name", or "by-usage", fullest filesystem first`)
+
+// An lvPlanEntry is one LV to grow in an --lv-plan.
+type lvPlanEntry struct {
+	lv   string   // "data"
+	spec string   // "+10G" or "100%FREE"
+	args []string // lvextend flags from lvExtendArgs
+}
+
+// isPercent reports whether e grows its LV by a percentage of the VG's
+// free space, rather than by a size.
+func (e lvPlanEntry) isPercent() bool { return e.args[0] == "-l" }
+
+var lvNameRx = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+
+// parseLVPlan parses the value of --lv-plan. Entries that grow by a
+// size come first, in order, so that percentages of the free space
+// are of what's left after them.
+func parseLVPlan(s string) ([]lvPlanEntry, error) {
+	var sizes, percents []lvPlanEntry
+	seen := map[string]bool{}
+	for _, f := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) != 2 || !lvNameRx.MatchString(kv[0]) {
+			return nil, fmt.Errorf("bad entry %q; want name=size", f)
+		}
+		if seen[kv[0]] {
+			return nil, fmt.Errorf("LV %q listed twice", kv[0])
+		}
+		seen[kv[0]] = true
+		args, err := lvExtendArgs(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("LV %s: %v", kv[0], err)
+		}
+		e := lvPlanEntry{lv: kv[0], spec: kv[1], args: args}
+		if e.isPercent() {
+			percents = append(percents, e)
+		} else {
+			sizes = append(sizes, e)
+		}
+	}
+	return append(sizes, percents...), nil
+}
+
+// checkGrowOrder returns an error if --grow-order is invalid.
+func checkGrowOrder() error {
+	switch *growOrder {
+	case "as-listed":
+		return nil
+	case "by-name", "by-usage":
+		if *lvPlan == "" {
+			return fmt.Errorf("--grow-order=%s needs --lv-plan", *growOrder)
+		}
+		return nil
+	}
+	return fmt.Errorf(`--grow-order %q is not "as-listed", "by-name" or "by-usage"`, *growOrder)
+}
+
+// orderLVPlan reorders the entries of plan, as from parseLVPlan, that
+// grow by a percentage of the VG vg's free space, as order says. Those
+// that grow by a size stay first.
+func orderLVPlan(plan []lvPlanEntry, order, vg string) []lvPlanEntry {
+	i := 0
+	for i < len(plan) && !plan[i].isPercent() {
+		i++
+	}
+	percents := plan[i:]
+	switch order {
+	case "by-name":
+		sort.SliceStable(percents, func(i, j int) bool { return percents[i].lv < percents[j].lv })
+	case "by-usage":
+		used := map[string]float64{}
+		for _, e := range percents {
+			if pct, ok := lvUsedPercent(vg, e.lv); ok {
+				used[e.lv] = pct
+			} else {
+				used[e.lv] = -1 // unmounted; last
+			}
+		}
+		sort.SliceStable(percents, func(i, j int) bool { return used[percents[i].lv] > used[percents[j].lv] })
+	}
+	return plan
+}
+
+// lvUsedPercent returns how full the filesystem on the LV vg/lv is, as
+// usedPercent reports it, or false if it isn't mounted.
+var lvUsedPercent = func(vg, lv string) (float64, bool) {
+	dev := canonicalDev("/dev/" + vg + "/" + lv)
+	mounts, err := readMounts()
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range mounts {
+		if canonicalDev(m.dev) != dev {
+			continue
+		}
+		var st unix.Statfs_t
+		if err := unix.Statfs(m.mnt, &st); err != nil {
+			return 0, false
+		}
+		return usedPercent(st), true
+	}
+	return 0, false
+}
+
+// lvSizeBytes returns the number of bytes in an lvextend -L size such
+// as "+10G" or "+2048s". Unit letters are powers of 1024, as in LVM.
+func lvSizeBytes(spec string) (int64, error) {
+	s := strings.TrimPrefix(spec, "+")
+	if s == "" {
+		return 0, fmt.Errorf("invalid size %q", spec)
+	}
+	switch s[len(s)-1] {
+	case 's', 'S':
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q", spec)
+		}
+		return sectorBytes(n, 512)
+	case 'b', 'B':
+		s = s[:len(s)-1]
+	}
+	return parseSize(s)
+}
+
+// checkLVPlanFits returns an error if the sizes in plan add up to more
+// than free, the VG's free space in bytes.
+func checkLVPlanFits(plan []lvPlanEntry, free int64) error {
+	var total int64
+	for _, e := range plan {
+		if e.isPercent() {
+			continue
+		}
+		n, err := lvSizeBytes(e.spec)
+		if err != nil {
+			return err
+		}
+		if total += n; total < 0 {
+			return fmt.Errorf("--lv-plan sizes overflow")
+		}
+	}
+	if total > free {
+		return fmt.Errorf("--lv-plan grows LVs by %d bytes in all, but the VG has only %d bytes free", total, free)
+	}
+	return nil
+}
+
+// lvPlanCommands returns the lvextend commands that carry out plan in
+// the VG vg. self is the name of the LV whose filesystem embiggen-disk
+// grows itself; the others are grown with their filesystems.
+func lvPlanCommands(vg, self string, plan []lvPlanEntry) []*exec.Cmd {
+	var cmds []*exec.Cmd
+	for _, e := range plan {
+		var args []string
+		if e.lv != self {
+			args = append(args, "--resizefs")
+		}
+		args = append(args, e.args...)
+		args = append(args, "/dev/"+vg+"/"+e.lv)
+		cmds = append(cmds, command("lvextend", args...))
+	}
+	return cmds
+}
+
+// vgFreeBytes returns the free space in the VG vg, in bytes.
+func vgFreeBytes(vg string) (int64, error) {
+	out, err := cmdOutput(command("vgs", "--noheadings", "--nosuffix", "--units", "b", "-o", "vg_free", vg))
+	if err != nil {
+		return 0, fmt.Errorf("running vgs %s: %v", vg, execErrDetail(err))
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bogus vgs %s output %q", vg, out)
+	}
+	return n, nil
+}
+
+// planCommands returns the lvextend commands for --lv-plan, after
+// checking the plan fits in r's VG.
+func (r lvResizer) planCommands() ([]*exec.Cmd, error) {
+	plan, err := parseLVPlan(*lvPlan)
+	if err != nil {
+		return nil, fmt.Errorf("--lv-plan: %v", err)
+	}
+	lvs, err := r.state()
+	if err != nil {
+		return nil, err
+	}
+	plan = orderLVPlan(plan, *growOrder, lvs.vg)
+	free, err := vgFreeBytes(lvs.vg)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLVPlanFits(plan, free); err != nil {
+		if !*dry {
+			return nil, err
+		}
+		// The PV below hasn't really grown.
+		warnf("%v (before growing its PV)", err)
+	}
+	var planned bool
+	for _, e := range plan {
+		planned = planned || e.lv == lvs.name
+	}
+	if !planned {
+		warnf("--lv-plan doesn't grow %s, the LV of the filesystem being grown", lvs.name)
+	}
+	return lvPlanCommands(lvs.vg, lvs.name, plan), nil
+}
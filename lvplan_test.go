@@ -0,0 +1,168 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLVPlan(t *testing.T) {
+	plan, err := parseLVPlan("data=100%FREE, root=+10G,var=+2048s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range plan {
+		got = append(got, e.lv+"="+strings.Join(e.args, " "))
+	}
+	want := []string{"root=-L +10G", "var=-L +2048s", "data=-l +100%FREE"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("plan = %q; want %q", got, want)
+	}
+
+	for _, bad := range []string{
+		"",
+		"root",
+		"=+10G",
+		"root=10G",
+		"root=+10G,root=+1G",
+		"root=150%FREE",
+		"root/x=+1G",
+	} {
+		if _, err := parseLVPlan(bad); err == nil {
+			t.Errorf("parseLVPlan(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestCheckLVPlanFits(t *testing.T) {
+	plan, err := parseLVPlan("root=+10G,data=+5G,rest=100%FREE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkLVPlanFits(plan, 15<<30); err != nil {
+		t.Errorf("15 GiB free: %v", err)
+	}
+	if err := checkLVPlanFits(plan, 15<<30-1); err == nil {
+		t.Error("1 byte short: want error")
+	}
+}
+
+func TestLVPlanCommands(t *testing.T) {
+	plan, err := parseLVPlan("root=+10G,data=100%FREE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, cmd := range lvPlanCommands("vg0", "root", plan) {
+		got = append(got, strings.Join(cmd.Args, " "))
+	}
+	// root's filesystem is grown by embiggen-disk itself; data's
+	// by lvextend.
+	want := []string{
+		"lvextend -L +10G /dev/vg0/root",
+		"lvextend --resizefs -l +100%FREE /dev/vg0/data",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q; want %q", got, want)
+	}
+}
+
+func TestLVResizeWithPlan(t *testing.T) {
+	defer func(p string) { *lvPlan = p }(*lvPlan)
+	*lvPlan = "root=+1G,data=100%FREE"
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "lvs":
+			if containsString(args, "lv_path,vg_name,lv_size") {
+				return []byte("  /dev/vg0/root:vg0:20971520\n"), nil
+			}
+			return []byte("  vg0:root::-wi-ao----\n"), nil
+		case "vgs":
+			return []byte("  4294967296\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	if err := lvResizer("/dev/mapper/vg0-root").Resize(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, args := range *ran {
+		if args[0] == "lvextend" {
+			got = append(got, strings.Join(args, " "))
+		}
+	}
+	want := []string{
+		"lvextend -L +1G /dev/vg0/root",
+		"lvextend --resizefs -l +100%FREE /dev/vg0/data",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ran %q; want %q", got, want)
+	}
+
+	*lvPlan = "root=+8G"
+	if err := lvResizer("/dev/mapper/vg0-root").Resize(); err == nil || !strings.Contains(err.Error(), "only 4294967296 bytes free") {
+		t.Errorf("plan bigger than VG: %v; want error", err)
+	}
+}
+
+func TestGrowOrder(t *testing.T) {
+	defer func(f func(string, string) (float64, bool)) { lvUsedPercent = f }(lvUsedPercent)
+	lvUsedPercent = func(vg, lv string) (float64, bool) {
+		pct, ok := map[string]float64{"data": 40, "logs": 95}[lv]
+		return pct, ok
+	}
+
+	for order, want := range map[string][]string{
+		"as-listed": {"root", "data", "logs", "archive"},
+		"by-name":   {"root", "archive", "data", "logs"},
+		"by-usage":  {"root", "logs", "data", "archive"},
+	} {
+		plan, err := parseLVPlan("data=50%FREE,logs=50%FREE,root=+10G,archive=100%FREE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, cmd := range lvPlanCommands("vg0", "root", orderLVPlan(plan, order, "vg0")) {
+			got = append(got, strings.TrimPrefix(cmd.Args[len(cmd.Args)-1], "/dev/vg0/"))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("--grow-order=%s grows %q; want %q", order, got, want)
+		}
+	}
+}
+
+func TestCheckGrowOrder(t *testing.T) {
+	defer func(o, p string) { *growOrder, *lvPlan = o, p }(*growOrder, *lvPlan)
+	for _, tt := range []struct {
+		order, plan string
+		ok          bool
+	}{
+		{"as-listed", "", true},
+		{"by-usage", "", false},
+		{"by-usage", "data=100%FREE", true},
+		{"by-size", "data=100%FREE", false},
+	} {
+		*growOrder, *lvPlan = tt.order, tt.plan
+		if err := checkGrowOrder(); (err == nil) != tt.ok {
+			t.Errorf("--grow-order=%s --lv-plan=%q: %v; want ok %v", tt.order, tt.plan, err, tt.ok)
+		}
+	}
+}
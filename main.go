@@ -20,19 +20,43 @@ limitations under the License.
 // block device.
 package main
 
-// TODO: test/fix on disks with non-512 byte sectors ( /sys/block/sda/queue/hw_sector_size)
-
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
 )
 
 var (
-	dry     = flag.Bool("dry-run", false, "don't make changes")
-	verbose = flag.Bool("verbose", false, "verbose output")
+	dry           = flag.Bool("dry-run", false, "don't make changes")
+	verbose       = flag.Bool("verbose", false, "verbose output")
+	force         = flag.Bool("force", false, "grow even when less than the usual 1 MiB is free after the last partition, possibly leaving it poorly aligned")
+	raw           = flag.Bool("raw", false, "the argument is a partition device with no filesystem or LVM PV on it (e.g. used directly by a database); grow only the partition")
+	devFromRoot   = flag.Bool("dev-from-root", false, "grow the root filesystem and the local disk it's on; no argument is needed")
+	preflight     = flag.Bool("preflight", false, "don't make changes; just classify the risk of growing the argument as low, medium or high")
+	shrinkTo      = flag.String("shrink-to", "", "instead of growing, shrink the filesystem and then its partition to this size (e.g. 20G). Dangerous. ext filesystems must be unmounted and passed by device; xfs can't be shrunk")
+	confirmShrink = flag.String("confirm-shrink", "", "the partition device being shrunk, confirming that --shrink-to should proceed")
+	jsonOut       = flag.Bool("json", false, "print the result as JSON (see package github.com/bradfitz/embiggen-disk/embiggen) instead of text")
+	lvExtend      = flag.String("lv-extend", "100%FREE", "how much to grow an LVM LV by: a percentage of the VG's free space (e.g. 80%FREE, to leave room for snapshots) or a size (e.g. +50G)")
+	toSize        = flag.String("to-size", "", "grow the filesystem only to this size (e.g. 100G) rather than to fill its device; the layers below it still grow fully")
+	toPercent     = flag.Int("to-percent", 0, "if non-zero, grow the last partition only until it ends this percentage (1-100) of the way into its disk, leaving the rest unallocated")
+	alignOptimal  = flag.Bool("align-optimal", false, "if the disk reports an optimal I/O size, end the grown partition on a multiple of it, leaving any remainder unallocated")
+	fsArgs        = flag.String("fs-args", "", "extra space-separated flags for the filesystem resize command (resize2fs, xfs_growfs, btrfs filesystem resize or bcachefs device resize), inserted before its operands. Passed through unchecked beyond conflicts with embiggen-disk's own arguments; for experts only. For xfs, -D replaces the default -d")
+	noopExitCode  = flag.Int("noop-exit-code", 0, "exit status to use when nothing needed changing, to tell that apart from a successful resize")
+	record        = flag.String("record", "", "if non-empty, a file to write every external command run (or, with --dry-run, that would've been run) to, as JSON, or as a shell script if it ends in .sh")
+	recordOutput  = flag.Bool("record-output", false, "with --record, also record each command's output")
+	sysfsRoot     = flag.String("sysfs-root", "/sys", "where sysfs is mounted, for tests and containers")
+	procRoot      = flag.String("proc-root", "/proc", "where procfs is mounted, for tests and containers")
 )
 
 func init() {
@@ -41,16 +65,110 @@ func init() {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of embiggen-disk:\n\n")
-	fmt.Fprintf(os.Stderr, "# embiggen-disk [flags] <mount-point-to-enlarge>\n\n")
-	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "# embiggen-disk [flags] <mount-point-to-enlarge>\n")
+	fmt.Fprintf(os.Stderr, "# embiggen-disk --dev-from-root [flags]\n")
+	fmt.Fprintf(os.Stderr, "# embiggen-disk --raw [flags] <partition-device-to-enlarge>\n")
+	fmt.Fprintf(os.Stderr, "# embiggen-disk --part-name=<gpt-partition-name> [flags] <disk-device>\n")
+	fmt.Fprintf(os.Stderr, "# embiggen-disk [flags] <lvm-pv-device-in-no-vg>\n")
+	fmt.Fprintf(os.Stderr, "# embiggen-disk --offline [flags] <unmounted-ext-or-f2fs-filesystem-device>\n")
+	fmt.Fprintf(os.Stderr, "# embiggen-disk --shrink-to=<size> --confirm-shrink=<partition-device> [flags] <mount-point-or-device>\n\n")
+	printVisibleDefaults()
+	fmt.Fprintf(os.Stderr, "\nEach flag can also be set by an environment variable named like\n")
+	fmt.Fprintf(os.Stderr, "%sDRY_RUN for --dry-run. Flags take precedence. The argument\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "can be set with %sMOUNT.\n", envPrefix)
+	fmt.Fprintf(os.Stderr, "\nIt exits 1 on error, or %d if sysfs isn't available. If btrfs can't be\n", exitNoSysfs)
+	fmt.Fprintf(os.Stderr, "grown, it exits %d if it's read-only, %d if it's out of space, or %d if\n", exitBtrfsReadOnly, exitBtrfsNoSpace, exitBtrfsWrongDevice)
+	fmt.Fprintf(os.Stderr, "the device isn't part of it. With --expect-plan, it exits %d if the\n", exitPlanMismatch)
+	fmt.Fprintf(os.Stderr, "planned commands aren't those expected.\n")
 	os.Exit(1)
 }
 
+// hiddenFlags aren't shown by usage. They're for tests and unusual
+// setups, not everyday use.
+var hiddenFlags = map[string]bool{
+	"sysfs-root": true,
+	"proc-root":  true,
+}
+
+// printVisibleDefaults is flag.PrintDefaults without hiddenFlags.
+func printVisibleDefaults() {
+	fs := flag.NewFlagSet("embiggen-disk", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			fs.Var(f.Value, f.Name, f.Usage)
+			fs.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	fs.PrintDefaults()
+}
+
+// envPrefix is the prefix of environment variables that set flags.
+const envPrefix = "EMBIGGEN_"
+
+// envName returns the environment variable corresponding to the flag
+// with the given name: "dry-run" is EMBIGGEN_DRY_RUN.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// applyEnv sets each flag in fs that wasn't set on the command line
+// from its environment variable (see envName), if present.
+func applyEnv(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) error {
+	onCmdLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { onCmdLine[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || onCmdLine[f.Name] {
+			return
+		}
+		env := envName(f.Name)
+		if v, ok := lookupEnv(env); ok {
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("invalid value %q for $%s: %v", v, env, e)
+			}
+		}
+	})
+	return err
+}
+
 func fatalf(format string, args ...interface{}) {
 	log.SetFlags(0)
 	log.Fatalf(format, args...)
 }
 
+// timings are how long each stage timed by timed has taken so far.
+var timings []embiggen.Timing
+
+// timed runs f, recording how long it took as stage.
+func timed(stage string, f func() error) error {
+	t0 := time.Now()
+	err := f()
+	timings = append(timings, embiggen.Timing{Stage: stage, Seconds: time.Since(t0).Seconds()})
+	return err
+}
+
+// notes are the informational messages reported by notef so far.
+var notes []string
+
+// notef logs, with --verbose, what a layer found or did, and records
+// it for the --json output.
+func notef(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	notes = append(notes, msg)
+	vlogf("%s", msg)
+}
+
+// warnings are the non-fatal problems reported by warnf so far.
+var warnings []string
+
+// warnf logs a non-fatal problem and records it for the --json output.
+func warnf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	warnings = append(warnings, msg)
+	log.Printf("%s %s", paint(os.Stderr, colorYellow, "warning:"), msg)
+}
+
 func vlogf(format string, args ...interface{}) {
 	if *verbose {
 		log.Printf(format, args...)
@@ -59,31 +177,437 @@ func vlogf(format string, args ...interface{}) {
 
 func main() {
 	flag.Parse()
-	if flag.NArg() != 1 {
+	if err := applyEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		fatalf("%v", err)
+	}
+	if *showVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+	args := flag.Args()
+	if v, ok := os.LookupEnv(envPrefix + "MOUNT"); ok && len(args) == 0 {
+		args = []string{v}
+	}
+	if *devFromRoot {
+		if len(flag.Args()) != 0 {
+			usage()
+		}
+		args = []string{"/"}
+	}
+	if len(args) != 1 {
 		usage()
 	}
 	if runtime.GOOS != "linux" {
 		fatalf("embiggen-disk only runs on Linux.")
 	}
 
-	mnt := flag.Arg(0)
-	e, err := getFileSystemResizer(mnt)
-	vlogf("getFileSystemResizer(%q) = %#v, %v", mnt, e, err)
+	if *serveAddr != "" {
+		fatalf("%v", serve(*serveAddr, args[0], Options{SysfsRoot: *sysfsRoot, ProcRoot: *procRoot}))
+	}
+	if *record != "" {
+		startRecording()
+	}
+	res, err := Run(args[0], Options{SysfsRoot: *sysfsRoot, ProcRoot: *procRoot})
+	if *record != "" {
+		if err := saveRecord(*record); err != nil {
+			log.Printf("error writing --record file: %v", err)
+		}
+	}
+	if *reportURL != "" {
+		if err := sendReport(*reportURL, res); err != nil {
+			log.Printf("error sending result to --report-url: %v", err)
+		}
+	}
+	if *notify != "" {
+		if err := sendNotification(*notify, res); err != nil {
+			log.Printf("error sending --notify notification: %v", err)
+		}
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(res); err != nil {
+			fatalf("error writing JSON: %v", err)
+		}
+		if err != nil {
+			os.Exit(errExitCode(err))
+		}
+	} else {
+		printResult(res)
+		if err != nil {
+			log.SetFlags(0)
+			log.Printf("%s %v", paint(os.Stderr, colorRed, "error:"), err)
+			os.Exit(errExitCode(err))
+		}
+	}
+	os.Exit(successExitCode(res))
+}
+
+// exitNoSysfs is the exit status when sysfs isn't available.
+const exitNoSysfs = 3
+
+// errExitCode returns the exit status for a run that failed with err.
+func errExitCode(err error) int {
+	if err == errNoSysfs {
+		return exitNoSysfs
+	}
+	var be *btrfsError
+	if errors.As(err, &be) {
+		return be.exitCode
+	}
+	var pe *planMismatchError
+	if errors.As(err, &pe) {
+		return exitPlanMismatch
+	}
+	return 1
+}
+
+// successExitCode returns the exit status for a run that produced res
+// without error: --noop-exit-code if it changed nothing, else 0.
+func successExitCode(res embiggen.Result) int {
+	if *reportReclaimable {
+		if res.ReclaimableBytes > 0 {
+			return exitReclaimable
+		}
+		return 0
+	}
+	if len(res.Changes) > 0 || res.Risk != "" || len(res.Diagnosis) > 0 || *reportMinSize {
+		return 0 // resized, or --preflight, --doctor or --report-min-size, which never change anything
+	}
+	return *noopExitCode
+}
+
+// saveRecord writes the commands recorded for --record to file.
+func saveRecord(file string) error {
+	var buf bytes.Buffer
+	if err := writeRecord(&buf, strings.HasSuffix(file, ".sh")); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// Options are the settings for Run that aren't flags.
+type Options struct {
+	SysfsRoot string // where sysfs is mounted; "/sys" if empty
+	ProcRoot  string // where procfs is mounted; "/proc" if empty
+}
+
+// use makes the package's reads of sysfs and procfs go to o's roots,
+// returning a func to restore the previous ones.
+func (o Options) use() (restore func()) {
+	oldSys, oldProc := sysfsDir, procDir
+	if o.SysfsRoot != "" {
+		sysfsDir = o.SysfsRoot
+	}
+	if o.ProcRoot != "" {
+		procDir = o.ProcRoot
+	}
+	return func() { sysfsDir, procDir = oldSys, oldProc }
+}
+
+// Run enlarges the filesystem mounted at mnt (or with --raw, the
+// partition mnt) and everything below it, as configured by the flags
+// and opts, and returns what it did.
+func Run(mnt string, opts Options) (embiggen.Result, error) {
+	defer opts.use()()
+	defer unlockDisks()
+	// Start afresh, in case of an earlier Run.
+	warnings, notes, timings = nil, nil, nil
+	movedDevs = map[string]string{}
+	lvGrowthSources = map[string]string{}
+	layers, partTableTypes = nil, map[string]string{}
+	explained = map[string]bool{}
+	res := embiggen.Result{
+		Version:     embiggen.Version,
+		ToolVersion: toolVersion(),
+		ToolCommit:  commit,
+		BuildDate:   buildDate,
+		Mount:       mnt,
+		Changes:     []embiggen.Change{},
+	}
+	if *expectPlan != "" && !recording {
+		startRecording()
+	}
+	recorded = nil
+	err := run(&res)
+	if err == nil && *expectPlan != "" {
+		err = checkExpectedPlan(*expectPlan)
+	}
+	finishResult(&res, err)
+	return res, err
+}
+
+// finishResult records in res the outcome of run: err and any
+// warnings logged along the way.
+func finishResult(res *embiggen.Result, err error) {
+	res.Warnings = warnings
+	res.Notes = notes
+	res.Timings = timings
+	res.Layers = layers
 	if err != nil {
-		fatalf("error preparing to enlarge %s: %v", mnt, err)
+		res.Error = err.Error()
 	}
-	changes, err := Resize(e)
-	if len(changes) > 0 {
-		fmt.Printf("Changes made:\n")
-		for _, c := range changes {
+}
+
+// progress returns where to print progress and dry-run messages:
+// stdout, unless that's reserved for --json output.
+func progress() io.Writer {
+	if *jsonOut {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// printResult prints the human-readable form of res.
+func printResult(res embiggen.Result) {
+	if id := identityString(res); id != "" {
+		fmt.Printf("Filesystem: %s\n", id)
+	}
+	if res.Risk != "" {
+		fmt.Printf("Risk: %s\n", res.Risk)
+		for _, r := range res.RiskReasons {
+			fmt.Printf("  * %s\n", r)
+		}
+		return
+	}
+	if *reportReclaimable && res.Error == "" {
+		if res.ReclaimableBytes > 0 {
+			fmt.Printf("%s: %d bytes (%.1f GiB) reclaimable\n", res.Mount, res.ReclaimableBytes, float64(res.ReclaimableBytes)/(1<<30))
+		} else {
+			fmt.Printf("%s: nothing reclaimable\n", res.Mount)
+			for _, n := range res.Notes {
+				fmt.Printf("  * %s\n", n)
+			}
+		}
+		return
+	}
+	if *reportMinSize && res.Error == "" {
+		fmt.Printf("%s: could be shrunk to %d bytes (%.1f GiB), freeing %d bytes (%.1f GiB)\n", res.Mount, res.MinSizeBytes, float64(res.MinSizeBytes)/(1<<30), res.ShrinkableBytes, float64(res.ShrinkableBytes)/(1<<30))
+		return
+	}
+	if len(res.Diagnosis) > 0 {
+		fmt.Printf("Diagnosis:\n")
+		for _, d := range res.Diagnosis {
+			fmt.Printf("  * %s\n", d)
+		}
+		return
+	}
+	if res.Skipped != "" {
+		fmt.Printf("%s\n", res.Skipped)
+		return
+	}
+	if len(res.Changes) > 0 {
+		fmt.Printf("%s\n", paint(os.Stdout, colorGreen, "Changes made:"))
+		for _, c := range res.Changes {
 			fmt.Printf("  * %s\n", c)
 		}
-	} else if err == nil {
-		fmt.Printf("No changes made.\n")
+	} else if res.Error == "" {
+		fmt.Printf("%s\n", paint(os.Stdout, colorGreen, "No changes made."))
+	}
+	if res.UsableBytesGained != 0 {
+		fmt.Printf("Usable space gained, less what's reserved for root: %d bytes (%.1f GiB).\n", res.UsableBytesGained, float64(res.UsableBytesGained)/(1<<30))
+	}
+	if res.TrimmedBytes > 0 {
+		fmt.Printf("Trimmed %d bytes (%.1f GiB).\n", res.TrimmedBytes, float64(res.TrimmedBytes)/(1<<30))
+	}
+	if res.TreeAfter != "" {
+		if *verbose && res.TreeBefore != "" {
+			fmt.Printf("Before:\n%s", res.TreeBefore)
+		}
+		fmt.Printf("Now:\n%s", res.TreeAfter)
+	}
+	if *verbose && len(res.Timings) > 0 {
+		fmt.Printf("Timings:\n")
+		for _, t := range res.Timings {
+			fmt.Printf("  * %s: %.3fs\n", t.Stage, t.Seconds)
+		}
+	}
+	if *raw && res.Error == "" {
+		if st, err := partitionResizer(res.Mount).State(); err == nil {
+			fmt.Printf("Raw partition %s is now %s.\n", res.Mount, st)
+		}
+	}
+}
+
+// identityString describes the filesystem res is about, such as
+// "LABEL=data UUID=...", or returns "" if it isn't known.
+func identityString(res embiggen.Result) string {
+	var f []string
+	if res.Label != "" {
+		f = append(f, "LABEL="+res.Label)
+	}
+	if res.UUID != "" {
+		f = append(f, "UUID="+res.UUID)
+	}
+	return strings.Join(f, " ")
+}
+
+// run enlarges the filesystem mounted at res.Mount and everything
+// below it, recording what it did in res. With --raw, res.Mount is
+// instead a partition device to enlarge.
+func run(res *embiggen.Result) error {
+	if *simulate || *expectPlan != "" {
+		*dry = true
+	}
+	if err := checkSysfs(); err != nil {
+		return err
+	}
+	if *devFromRoot {
+		mounts, err := readMounts()
+		if err != nil {
+			return err
+		}
+		res.Disk, err = diskForMount(mounts, "/")
+		if err != nil {
+			return fmt.Errorf("--dev-from-root: %v", err)
+		}
+		vlogf("root filesystem is on disk %s", res.Disk)
+	}
+	if *toPercent < 0 || *toPercent > 100 {
+		return fmt.Errorf("--to-percent %d is not between 1 and 100", *toPercent)
+	}
+	if *lvPlan != "" {
+		if _, err := parseLVPlan(*lvPlan); err != nil {
+			return fmt.Errorf("--lv-plan: %v", err)
+		}
+	}
+	if err := checkGrowOrder(); err != nil {
+		return err
+	}
+	if _, err := gptReserveSectors(*gptReserve, 512); err != nil {
+		return fmt.Errorf("--gpt-reserve: %v", err)
+	}
+	if err := checkDevSizeFlags(); err != nil {
+		return err
+	}
+	if *allowedHours != "" {
+		if _, err := parseWindow(*allowedHours); err != nil {
+			return fmt.Errorf("--allowed-hours: %v", err)
+		}
+	}
+	if *extReservedPercent != -1 && (*extReservedPercent < 0 || *extReservedPercent > 50) {
+		return fmt.Errorf("--ext-reserved-percent %v is not between 0 and 50", *extReservedPercent)
+	}
+	if _, err := lvExtendArgs(*lvExtend); err != nil {
+		return fmt.Errorf("--lv-extend: %v", err)
+	}
+	if *growIfUsedAbove < 0 || *growIfUsedAbove >= 100 {
+		return fmt.Errorf("--grow-if-used-above %v is not between 0 and 100", *growIfUsedAbove)
+	}
+	e, err := getResizer(res.Mount)
+	if err != nil {
+		return err
+	}
+	if fe, ok := e.(fsResizer); ok {
+		res.Label, res.UUID = fsIdentity(fe.fs.dev)
+	}
+	if *preflight {
+		level, reasons, err := assessRisk(e)
+		if err != nil {
+			return err
+		}
+		res.Risk = level.String()
+		res.RiskReasons = reasons
+		return nil
+	}
+	if *reportReclaimable {
+		res.ReclaimableBytes, err = reclaimableBytes(e)
+		return err
+	}
+	if *doctor {
+		res.Diagnosis, err = doctorDiagnosis(e)
+		return err
+	}
+	if *reportMinSize {
+		return minSizeReport(e, res)
+	}
+	if *savePlan != "" {
+		return writePlan(*savePlan, res.Mount, e)
+	}
+	if *applyPlan != "" {
+		if err := checkPlan(*applyPlan, res.Mount, e); err != nil {
+			return err
+		}
+	}
+	if *shrinkTo != "" {
+		size, err := parseSize(*shrinkTo)
+		if err != nil {
+			return fmt.Errorf("--shrink-to: %v", err)
+		}
+		changes, err := shrink(e, size)
+		res.Changes = append(res.Changes, changes...)
+		return err
+	}
+	if err := checkGrowpart(res.Mount); err != nil {
+		return err
+	}
+	if *growIfUsedAbove > 0 {
+		skip, err := usageSkip(e)
+		if err != nil {
+			return err
+		}
+		if skip != "" {
+			res.Skipped = skip
+			return nil
+		}
+	}
+	var before string
+	if !*dry {
+		before = captureTree(e)
+	}
+	extBefore, isExt := readExtCounts(e)
+	changes, err := Resize(e)
+	res.Changes = append(res.Changes, changes...)
+	if err == nil && *extReservedPercent >= 0 && (len(changes) > 0 || *dry) {
+		setExtReserved(e)
+	}
+	if err == nil && isExt && len(changes) > 0 && !*dry {
+		if extAfter, ok := readExtCounts(e); ok {
+			res.UsableBytesGained = extAfter.usableBytes() - extBefore.usableBytes()
+			notef("%s", usableGainNote(e, extBefore, extAfter))
+		}
+	}
+	if err == nil && *trim && (len(changes) > 0 || *dry) {
+		res.TrimmedBytes = trimGrown(e)
+	}
+	if err == nil && len(changes) > 0 && !*dry {
+		res.TreeBefore, res.TreeAfter = before, captureTree(e)
+	}
+	return err
+}
+
+// getResizer returns the top Resizer for the command line argument
+// arg, after checking it's permitted to be resized.
+func getResizer(arg string) (e Resizer, err error) {
+	if *partName != "" {
+		e, err = getNamedPartitionResizer(arg)
+		vlogf("getNamedPartitionResizer(%q) = %#v, %v", arg, e, err)
+	} else if *raw {
+		e, err = getRawResizer(arg)
+		vlogf("getRawResizer(%q) = %#v, %v", arg, e, err)
+	} else if (*shrinkTo != "" || *reportMinSize) && strings.HasPrefix(arg, "/dev/") {
+		e, err = getUnmountedFSResizer(arg)
+		vlogf("getUnmountedFSResizer(%q) = %#v, %v", arg, e, err)
+	} else if *offline {
+		e, err = getOfflineFSResizer(arg)
+		vlogf("getOfflineFSResizer(%q) = %#v, %v", arg, e, err)
+	} else if strings.HasPrefix(arg, "/dev/") {
+		e, err = getOrphanPVResizer(arg)
+		vlogf("getOrphanPVResizer(%q) = %#v, %v", arg, e, err)
+	} else {
+		e, err = getFileSystemResizer(arg)
+		vlogf("getFileSystemResizer(%q) = %#v, %v", arg, e, err)
 	}
 	if err != nil {
-		fatalf("error: %v", err)
+		return nil, fmt.Errorf("preparing to enlarge %s: %v", arg, err)
+	}
+	if err := checkAllowed(e); err != nil {
+		return nil, fmt.Errorf("refusing to enlarge %s: %v", arg, err)
+	}
+	if err := checkDiskIntent(e); err != nil {
+		return nil, fmt.Errorf("refusing to enlarge %s: %v", arg, err)
 	}
+	return e, nil
 }
 
 // An Resizer is anything that can enlarge something and describe its state.
@@ -96,7 +620,7 @@ type Resizer interface {
 }
 
 // Resize resizes e's dependencies and then resizes e.
-func Resize(e Resizer) (changes []string, err error) {
+func Resize(e Resizer) (changes []embiggen.Change, err error) {
 	s0, err := e.State()
 	if err != nil {
 		return
@@ -111,7 +635,7 @@ func Resize(e Resizer) (changes []string, err error) {
 			return
 		}
 	}
-	err = e.Resize()
+	err = timed(e.String(), e.Resize)
 	if err != nil {
 		return
 	}
@@ -121,7 +645,8 @@ func Resize(e Resizer) (changes []string, err error) {
 		return
 	}
 	if s0 != s1 {
-		changes = append(changes, fmt.Sprintf("%v: before: %v, after: %v", e, s0, s1))
+		changes = append(changes, embiggen.Change{Resizer: e.String(), Before: s0, After: s1})
 	}
+	layers = append(layers, describeLayer(e, s0, s1))
 	return
 }
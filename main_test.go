@@ -0,0 +1,233 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestResultWarnings(t *testing.T) {
+	defer func() { warnings = nil }()
+	warnings = nil
+
+	// Forcing a grow into the end reserve is a known warning condition.
+	growSectors(1000, 512, false, true)
+	var res embiggen.Result
+	finishResult(&res, errors.New("boom"))
+	want := []string{"--force: growing into the last MiB of the disk; the end of the partition may be poorly aligned"}
+	if !reflect.DeepEqual(res.Warnings, want) {
+		t.Errorf("warnings = %q; want %q", res.Warnings, want)
+	}
+	if res.Error != "boom" {
+		t.Errorf("error = %q; want boom", res.Error)
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	dry := fs.Bool("dry-run", false, "")
+	verbose := fs.Bool("verbose", false, "")
+	jsonOut := fs.Bool("json", false, "")
+	var allow devList
+	fs.Var(&allow, "allow", "")
+	if err := fs.Parse([]string{"--verbose=false"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"EMBIGGEN_DRY_RUN": "true",
+		"EMBIGGEN_VERBOSE": "true", // overridden by the command line
+		"EMBIGGEN_ALLOW":   "/dev/sdb,/dev/sdc",
+	}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	if err := applyEnv(fs, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if !*dry {
+		t.Error("EMBIGGEN_DRY_RUN didn't set --dry-run")
+	}
+	if *verbose {
+		t.Error("EMBIGGEN_VERBOSE overrode --verbose=false from the command line")
+	}
+	if *jsonOut {
+		t.Error("--json set without its environment variable")
+	}
+	if got, want := allow.String(), "/dev/sdb,/dev/sdc"; got != want {
+		t.Errorf("allow = %q; want %q", got, want)
+	}
+
+	env = map[string]string{"EMBIGGEN_JSON": "maybe"}
+	if err := applyEnv(fs, lookup); err == nil {
+		t.Error("expected error for invalid boolean EMBIGGEN_JSON")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for flagName, want := range map[string]string{
+		"dry-run": "EMBIGGEN_DRY_RUN",
+		"json":    "EMBIGGEN_JSON",
+		"allow":   "EMBIGGEN_ALLOW",
+	} {
+		if got := envName(flagName); got != want {
+			t.Errorf("envName(%q) = %q; want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestSuccessExitCode(t *testing.T) {
+	defer func(v int) { *noopExitCode = v }(*noopExitCode)
+	changed := embiggen.Result{Changes: []embiggen.Change{{Resizer: "partition /dev/sda1", Before: "1 sectors", After: "2 sectors"}}}
+	unchanged := embiggen.Result{Changes: []embiggen.Change{}}
+
+	*noopExitCode = 0
+	if got := successExitCode(unchanged); got != 0 {
+		t.Errorf("default, no change: exit %d; want 0", got)
+	}
+	*noopExitCode = 3
+	if got := successExitCode(unchanged); got != 3 {
+		t.Errorf("--noop-exit-code=3, no change: exit %d; want 3", got)
+	}
+	if got := successExitCode(changed); got != 0 {
+		t.Errorf("--noop-exit-code=3, changed: exit %d; want 0", got)
+	}
+	if got := successExitCode(embiggen.Result{Risk: "low"}); got != 0 {
+		t.Errorf("--noop-exit-code=3, --preflight: exit %d; want 0", got)
+	}
+}
+
+func TestResultTimings(t *testing.T) {
+	defer func() { timings = nil }()
+	timings = nil
+	part := &stubResizer{name: "partition /dev/sda1", state: "1 sectors"}
+	fs := &stubResizer{name: "ext4 filesystem at /", state: "1 blocks", dep: part}
+	if _, err := Resize(fs); err != nil {
+		t.Fatal(err)
+	}
+	var res embiggen.Result
+	finishResult(&res, nil)
+	if len(res.Timings) != 2 {
+		t.Fatalf("timings = %+v; want 2", res.Timings)
+	}
+	for i, want := range []string{"partition /dev/sda1", "ext4 filesystem at /"} {
+		if tm := res.Timings[i]; tm.Stage != want || tm.Seconds < 0 {
+			t.Errorf("timing %d = %+v; want stage %q", i, tm, want)
+		}
+	}
+}
+
+func TestProgressWithJSON(t *testing.T) {
+	defer func(v bool) { *jsonOut = v }(*jsonOut)
+	*jsonOut = false
+	if progress() != os.Stdout {
+		t.Error("progress() isn't stdout without --json")
+	}
+	*jsonOut = true
+	if progress() != os.Stderr {
+		t.Error("progress() isn't stderr with --json")
+	}
+}
+
+func TestResultNotes(t *testing.T) {
+	defer func() { notes = nil }()
+	notes = nil
+
+	notef("%s", pvResizeSummary(pvResizer("/dev/sda3"), 100, 100))
+	var res embiggen.Result
+	finishResult(&res, nil)
+	want := []string{"LVM PV /dev/sda3: no free space to grow into; size unchanged at 100 sectors"}
+	if !reflect.DeepEqual(res.Notes, want) {
+		t.Errorf("notes = %q; want %q", res.Notes, want)
+	}
+}
+
+func TestRunResetsState(t *testing.T) {
+	defer func() { warnings, notes, timings, movedDevs = nil, nil, nil, map[string]string{} }()
+	sys, cleanup := newFakeSysfs(t) // empty, so Run fails at checkSysfs
+	defer cleanup()
+
+	warnings = []string{"left over"}
+	notes = []string{"left over"}
+	timings = []embiggen.Timing{{Stage: "left over"}}
+	layers = []embiggen.Layer{{Resizer: "left over"}}
+	movedDevs["/dev/sda2"] = "/dev/sdb2"
+	res, err := Run("/", Options{SysfsRoot: sys.dir})
+	if err != errNoSysfs {
+		t.Fatalf("Run = %v; want errNoSysfs", err)
+	}
+	if len(res.Warnings)+len(res.Notes)+len(res.Timings)+len(res.Layers) != 0 {
+		t.Errorf("result has state from before Run: %+v", res)
+	}
+	if len(movedDevs) != 0 {
+		t.Errorf("movedDevs = %v; want empty", movedDevs)
+	}
+}
+
+// captureStdout returns what f prints to os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer func(f *os.File) { os.Stdout = f }(os.Stdout)
+	os.Stdout = w
+	f()
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestReportIdentity(t *testing.T) {
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[len(args)-2] {
+		case "LABEL":
+			return []byte("data\n"), nil
+		case "UUID":
+			return []byte("0c7a5cf6-4e1f-4a4b-9d5c-2f3e1b6a8d90\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	res := embiggen.Result{Mount: "/data", Changes: []embiggen.Change{}}
+	res.Label, res.UUID = fsIdentity("/dev/sdb1")
+	want := "LABEL=data UUID=0c7a5cf6-4e1f-4a4b-9d5c-2f3e1b6a8d90"
+	if out := captureStdout(t, func() { printResult(res) }); !strings.Contains(out, "Filesystem: "+want+"\n") {
+		t.Errorf("report = %q; want it to include %q", out, want)
+	}
+	js, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(js), `"label":"data","uuid":"0c7a5cf6-4e1f-4a4b-9d5c-2f3e1b6a8d90"`) {
+		t.Errorf("JSON = %s; want label and uuid", js)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+var reportMinSize = flag.Bool("report-min-size", false, "don't make changes; just print how small the argument's ext filesystem could be shrunk to, per resize2fs -P, and how much space that would free")
+
+var (
+	extMinSizeRx   = regexp.MustCompile(`(?m)^Estimated minimum size of the filesystem:\s*(\d+)\s*$`)
+	extBlockSizeRx = regexp.MustCompile(`(?m)^Block size:\s*(\d+)$`)
+)
+
+// parseExtMinSize returns the minimum size in blocks in the output of
+// "resize2fs -P".
+func parseExtMinSize(out []byte) (int64, error) {
+	m := extMinSizeRx.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("no estimated minimum size in resize2fs -P output: %q", out)
+	}
+	return strconv.ParseInt(string(m[1]), 10, 64)
+}
+
+// extMinSize returns the size in bytes of the ext filesystem on dev
+// and the smallest resize2fs estimates it could be shrunk to. Nothing
+// is changed.
+func extMinSize(dev string) (size, min int64, err error) {
+	sb, err := dumpe2fs(dev)
+	if err != nil {
+		return 0, 0, err
+	}
+	bs := extBlockSizeRx.FindSubmatch(sb)
+	bc := extBlockCountRx.FindSubmatch(sb)
+	if bs == nil || bc == nil {
+		return 0, 0, fmt.Errorf("no block size or count in dumpe2fs -h %s output", dev)
+	}
+	blockSize, _ := strconv.ParseInt(string(bs[1]), 10, 64)
+	blocks, _ := strconv.ParseInt(string(bc[1]), 10, 64)
+
+	cmd := command("resize2fs", "-P", dev)
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return 0, 0, fmt.Errorf("running resize2fs -P %s: %v", dev, execErrDetail(err))
+	}
+	minBlocks, err := parseExtMinSize(out)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size, err = sectorBytes(blocks, blockSize); err != nil {
+		return 0, 0, err
+	}
+	if min, err = sectorBytes(minBlocks, blockSize); err != nil {
+		return 0, 0, err
+	}
+	return size, min, nil
+}
+
+// minSizeReport fills in res with how small e, an ext filesystem,
+// could be shrunk to, for --report-min-size.
+func minSizeReport(e Resizer, res *embiggen.Result) error {
+	fe, ok := e.(fsResizer)
+	if !ok {
+		return fmt.Errorf("--report-min-size: %v isn't a filesystem", e)
+	}
+	switch fe.fs.fstype {
+	case "ext2", "ext3", "ext4":
+	default:
+		return fmt.Errorf("--report-min-size only supports ext2/3/4 filesystems; %s is %s", fe.fs.dev, fe.fs.fstype)
+	}
+	size, min, err := extMinSize(fe.fs.dev)
+	if err != nil {
+		return err
+	}
+	res.MinSizeBytes = min
+	if size > min {
+		res.ShrinkableBytes = size - min
+	}
+	return nil
+}
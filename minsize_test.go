@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestParseExtMinSize(t *testing.T) {
+	n, err := parseExtMinSize([]byte("Estimated minimum size of the filesystem: 1843513\n"))
+	if err != nil || n != 1843513 {
+		t.Errorf("parseExtMinSize = %d, %v; want 1843513", n, err)
+	}
+	if _, err := parseExtMinSize([]byte("resize2fs: Bad magic number in super-block while trying to open /dev/sdb1\n")); err == nil {
+		t.Error("parseExtMinSize of an error: want error")
+	}
+}
+
+func TestReportMinSize(t *testing.T) {
+	defer func(v bool) { *reportMinSize = v }(*reportMinSize)
+	*reportMinSize = true
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "dumpe2fs":
+			return []byte("Filesystem features:      has_journal ext_attr resize_inode\nBlock count:              2621440\nBlock size:               4096\n"), nil
+		case "resize2fs":
+			return []byte("Estimated minimum size of the filesystem: 1843513\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	res := embiggen.Result{Mount: "/data"}
+	e := fsResizer{fs: fsStat{dev: "/dev/sdb1", mnt: "/data", fstype: "ext4"}}
+	if err := minSizeReport(e, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.MinSizeBytes != 1843513*4096 || res.ShrinkableBytes != (2621440-1843513)*4096 {
+		t.Errorf("min = %d, shrinkable = %d; want %d, %d", res.MinSizeBytes, res.ShrinkableBytes, 1843513*4096, (2621440-1843513)*4096)
+	}
+	for _, args := range *ran {
+		if args[0] == "resize2fs" && (len(args) != 3 || args[1] != "-P") {
+			t.Errorf("ran %q; want only resize2fs -P", args)
+		}
+	}
+	out := captureStdout(t, func() { printResult(res) })
+	if want := "/data: could be shrunk to 7551029248 bytes (7.0 GiB), freeing 3186388992 bytes (3.0 GiB)\n"; out != want {
+		t.Errorf("report = %q; want %q", out, want)
+	}
+	if code := successExitCode(res); code != 0 {
+		t.Errorf("exit code = %d; want 0", code)
+	}
+
+	xfs := fsResizer{fs: fsStat{dev: "/dev/sdc1", mnt: "/xfs", fstype: "xfs"}}
+	if err := minSizeReport(xfs, &res); err == nil || !strings.Contains(err.Error(), "only supports ext") {
+		t.Errorf("xfs: %v; want error", err)
+	}
+}
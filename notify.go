@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+var notify = flag.String("notify", "", "if non-empty, where to send a one-line summary of the run, success or failure: slack:URL for a Slack incoming webhook, or webhook:URL for a generic JSON webhook. Failing to notify doesn't fail the run.")
+
+// A webhookPayload is what --notify=webhook:URL posts.
+type webhookPayload struct {
+	Host    string          `json:"host"`
+	Mount   string          `json:"mount"`
+	OK      bool            `json:"ok"`
+	Message string          `json:"message"`
+	Result  embiggen.Result `json:"result"`
+}
+
+// notifyMessage returns a one-line, human-readable summary of res from
+// host: what grew and by how much, or what went wrong.
+func notifyMessage(host string, res embiggen.Result) string {
+	if res.Error != "" {
+		return fmt.Sprintf("embiggen-disk on %s failed to grow %s: %s", host, res.Mount, res.Error)
+	}
+	if len(res.Changes) == 0 {
+		return fmt.Sprintf("embiggen-disk on %s: %s didn't need growing", host, res.Mount)
+	}
+	msg := fmt.Sprintf("embiggen-disk on %s grew %s", host, res.Mount)
+	for _, c := range res.Changes {
+		if !strings.HasPrefix(c.Resizer, "partition ") {
+			continue
+		}
+		before, err1 := strconv.ParseInt(strings.TrimSuffix(c.Before, " sectors"), 10, 64)
+		after, err2 := strconv.ParseInt(strings.TrimSuffix(c.After, " sectors"), 10, 64)
+		if err1 == nil && err2 == nil {
+			msg += fmt.Sprintf(": %s by %.1f GiB", strings.TrimPrefix(c.Resizer, "partition "), float64(after-before)*512/(1<<30))
+		}
+		break
+	}
+	return msg
+}
+
+// notifyPayload returns what to post for the --notify value dest, and
+// the URL to post it to.
+func notifyPayload(dest, host string, res embiggen.Result) (url string, body []byte, err error) {
+	msg := notifyMessage(host, res)
+	switch {
+	case strings.HasPrefix(dest, "slack:"):
+		body, err = json.Marshal(map[string]string{"text": msg})
+		return strings.TrimPrefix(dest, "slack:"), body, err
+	case strings.HasPrefix(dest, "webhook:"):
+		body, err = json.Marshal(webhookPayload{
+			Host:    host,
+			Mount:   res.Mount,
+			OK:      res.Error == "",
+			Message: msg,
+			Result:  res,
+		})
+		return strings.TrimPrefix(dest, "webhook:"), body, err
+	}
+	return "", nil, fmt.Errorf("unsupported --notify %q; want slack:URL or webhook:URL", dest)
+}
+
+// sendNotification sends the --notify summary of res to dest.
+func sendNotification(dest string, res embiggen.Result) error {
+	host, _ := os.Hostname()
+	url, body, err := notifyPayload(dest, host, res)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return fmt.Errorf("--notify URL %q isn't http:// or https://", url)
+	}
+	return deliver(url, body)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestNotify(t *testing.T) {
+	defer func(d time.Duration) { reportRetryDelay = d }(reportRetryDelay)
+	reportRetryDelay = 0
+	var posted []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	grew := embiggen.Result{
+		Version: embiggen.Version,
+		Mount:   "/",
+		Changes: []embiggen.Change{
+			{Resizer: "partition /dev/sda3", Before: "104857600 sectors", After: "209715200 sectors"},
+			{Resizer: "ext4 filesystem at /", Before: "13107200 blocks", After: "26214400 blocks"},
+		},
+	}
+	url, body, err := notifyPayload("slack:"+ts.URL, "web1", grew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != ts.URL {
+		t.Errorf("url = %q; want %q", url, ts.URL)
+	}
+	if want := `{"text":"embiggen-disk on web1 grew /: /dev/sda3 by 50.0 GiB"}`; string(body) != want {
+		t.Errorf("slack payload = %s; want %s", body, want)
+	}
+	if err := deliver(url, body); err != nil {
+		t.Fatal(err)
+	}
+	if string(posted) != string(body) {
+		t.Errorf("posted %s; want %s", posted, body)
+	}
+
+	failed := embiggen.Result{Version: embiggen.Version, Mount: "/data", Changes: []embiggen.Change{}, Error: "pvresize /dev/sdb1: exit status 5"}
+	_, body, err = notifyPayload("webhook:"+ts.URL, "db2", failed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Host != "db2" || p.Mount != "/data" || p.OK || p.Result.Error != failed.Error {
+		t.Errorf("webhook payload = %+v", p)
+	}
+	if want := "embiggen-disk on db2 failed to grow /data: pvresize /dev/sdb1: exit status 5"; p.Message != want {
+		t.Errorf("webhook message = %q; want %q", p.Message, want)
+	}
+
+	if _, _, err := notifyPayload("email:ops@example.com", "web1", grew); err == nil {
+		t.Error("notifyPayload(email:) succeeded")
+	}
+}
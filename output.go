@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	outputCap  = flag.Int("output-cap", 8<<10, "at most how many bytes of a command's output to put in an error message or log; only the end is kept. Zero means no limit")
+	fullOutput = flag.String("full-output", "", "if non-empty, a file to append the full output of any command that --output-cap truncated to")
+)
+
+// capOutput returns out, the output of the command args, for an error
+// message or log: all of it if it's within --output-cap, else its last
+// --output-cap bytes after a marker saying how much was left out. The
+// full output is then appended to --full-output, if set. args is nil
+// if the command isn't known.
+func capOutput(args []string, out []byte) string {
+	if *outputCap <= 0 || len(out) <= *outputCap {
+		return string(out)
+	}
+	where := "set --full-output to keep it"
+	if *fullOutput != "" {
+		if err := appendFullOutput(*fullOutput, args, out); err != nil {
+			log.Printf("error writing --full-output: %v", err)
+		} else {
+			where = "full output in " + *fullOutput
+		}
+	}
+	return fmt.Sprintf("[... %d bytes truncated; %s ...]\n%s", len(out)-*outputCap, where, out[len(out)-*outputCap:])
+}
+
+// appendFullOutput appends out, the output of the command args, to
+// file.
+func appendFullOutput(file string, args []string, out []byte) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	what := "command"
+	if args != nil {
+		what = strings.Join(args, " ")
+	}
+	_, err = fmt.Fprintf(f, "==> output of %s <==\n%s\n", what, out)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCapOutput(t *testing.T) {
+	defer func(n int, f string) { *outputCap, *fullOutput = n, f }(*outputCap, *fullOutput)
+	td, err := ioutil.TempDir("", "embiggen-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	*outputCap = 16
+
+	if got := capOutput([]string{"resize2fs", "/dev/sda1"}, []byte("short")); got != "short" {
+		t.Errorf("capOutput of short output = %q; want it unchanged", got)
+	}
+
+	out := []byte(strings.Repeat("noise\n", 20) + "the real error\n")
+	want := "[... 119 bytes truncated; set --full-output to keep it ...]\n" + "\nthe real error\n"
+	if got := capOutput([]string{"resize2fs", "/dev/sda1"}, out); got != want {
+		t.Errorf("capOutput = %q; want %q", got, want)
+	}
+
+	*fullOutput = filepath.Join(td, "full.log")
+	got := capOutput([]string{"resize2fs", "/dev/sda1"}, out)
+	if want := "[... 119 bytes truncated; full output in " + *fullOutput + " ...]\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("capOutput = %q; want prefix %q", got, want)
+	}
+	full, err := ioutil.ReadFile(*fullOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "==> output of resize2fs /dev/sda1 <==\n" + string(out) + "\n"; string(full) != want {
+		t.Errorf("--full-output file = %q; want %q", full, want)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// overlayUpperMount returns the mount holding the writable (upper)
+// layer of the overlay filesystem mounted at mnt, as on appliances
+// with a read-only squashfs root. That's the filesystem to grow, as
+// the overlay's lower layers are read-only.
+func overlayUpperMount(mnt string, mounts []mountInfo) (mountInfo, error) {
+	ov := -1
+	for i, m := range mounts {
+		if m.mnt == mnt {
+			ov = i
+		}
+	}
+	if ov == -1 {
+		return mountInfo{}, fmt.Errorf("overlay at %s: mount point not found", mnt)
+	}
+	upper := mountOption(mounts[ov].opts, "upperdir")
+	if upper == "" {
+		return mountInfo{}, fmt.Errorf("overlay at %s has no writable layer, and its lower layers (%s) are read-only; there's nothing to grow", mnt, overlayLowerDesc(mounts[ov], mounts))
+	}
+	m, ok := mountHolding(upper, mounts[:ov])
+	if !ok {
+		return mountInfo{}, fmt.Errorf("overlay at %s: can't find the mount holding its writable layer %s", mnt, upper)
+	}
+	if m.fstype == "squashfs" || nonBlockFSTypes[m.fstype] || strings.HasPrefix(m.fstype, "fuse.") {
+		return mountInfo{}, fmt.Errorf("overlay at %s keeps its writable layer %s on %s at %s, which isn't a growable block device filesystem, and its lower layers (%s) are read-only; there's nothing to grow", mnt, upper, m.fstype, m.mnt, overlayLowerDesc(mounts[ov], mounts))
+	}
+	return m, nil
+}
+
+// overlayLowerDesc describes the lower layers of the overlay ov, such
+// as "squashfs at /media/root-ro", for error messages.
+func overlayLowerDesc(ov mountInfo, mounts []mountInfo) string {
+	var descs []string
+	for _, dir := range strings.Split(mountOption(ov.opts, "lowerdir"), ":") {
+		if dir == "" {
+			continue
+		}
+		if m, ok := mountHolding(dir, mounts); ok {
+			descs = append(descs, fmt.Sprintf("%s at %s", m.fstype, m.mnt))
+		} else {
+			descs = append(descs, dir)
+		}
+	}
+	return strings.Join(descs, ", ")
+}
+
+// mountHolding returns the deepest of mounts whose mount point is dir
+// or a parent of it, the last if several are mounted there.
+func mountHolding(dir string, mounts []mountInfo) (m mountInfo, ok bool) {
+	dir = filepath.Clean(dir)
+	for _, mi := range mounts {
+		if mi.mnt != "/" && dir != mi.mnt && !strings.HasPrefix(dir, mi.mnt+"/") {
+			continue
+		}
+		if !ok || len(mi.mnt) >= len(m.mnt) {
+			m, ok = mi, true
+		}
+	}
+	return m, ok
+}
+
+// mountOption returns the value of the key=value option key in the
+// comma-separated mount options opts, or "" if it's not there.
+func mountOption(opts, key string) string {
+	for _, o := range strings.Split(opts, ",") {
+		if strings.HasPrefix(o, key+"=") {
+			return strings.TrimPrefix(o, key+"=")
+		}
+	}
+	return ""
+}
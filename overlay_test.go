@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverlayUpperMount(t *testing.T) {
+	const squashfs = "20 1 7:0 / /media/root-ro ro,relatime - squashfs /dev/loop0 ro\n"
+	const overlay = "24 1 0:25 / / rw,relatime - overlay overlay rw,lowerdir=/media/root-ro,upperdir=/media/root-rw/overlay,workdir=/media/root-rw/overlay-workdir\n"
+	tests := []struct {
+		name      string
+		mountinfo string
+		wantDev   string
+		wantErr   string
+	}{
+		{
+			name:      "upper on ext4",
+			mountinfo: squashfs + "21 1 8:3 / /media/root-rw rw,relatime - ext4 /dev/sda3 rw\n" + overlay,
+			wantDev:   "/dev/sda3",
+		},
+		{
+			name:      "upper on tmpfs",
+			mountinfo: squashfs + "21 1 0:22 / /media/root-rw rw - tmpfs tmpfs rw\n" + overlay,
+			wantErr:   "keeps its writable layer /media/root-rw/overlay on tmpfs at /media/root-rw, which isn't a growable block device filesystem, and its lower layers (squashfs at /media/root-ro) are read-only",
+		},
+		{
+			name:      "no upper",
+			mountinfo: squashfs + "24 1 0:25 / / ro - overlay overlay ro,lowerdir=/media/root-ro\n",
+			wantErr:   "overlay at / has no writable layer, and its lower layers (squashfs at /media/root-ro) are read-only",
+		},
+	}
+	for _, tt := range tests {
+		mounts, err := parseMountInfo([]byte(tt.mountinfo))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := overlayUpperMount("/", mounts)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v; want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if m.dev != tt.wantDev || m.mnt != "/media/root-rw" || m.fstype != "ext4" {
+			t.Errorf("%s: got %+v; want ext4 %s at /media/root-rw", tt.name, m, tt.wantDev)
+		}
+	}
+
+	if _, err := fsResizerFor(fsStat{mnt: "/media/root-ro", dev: "/dev/loop0", fstype: "squashfs"}); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("fsResizerFor(squashfs) = %v; want read-only error", err)
+	}
+}
@@ -21,11 +21,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -36,40 +35,200 @@ import (
 )
 
 const (
-	lvmGPTTypeID       = "E6D6D379-F507-44C2-A23C-238F2A3DF928"
-	rootx8664GPTTypeID = "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709"
-	linuxGPTTypeID     = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+	lvmGPTTypeID   = "E6D6D379-F507-44C2-A23C-238F2A3DF928"
+	linuxGPTTypeID = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+
+	// Root partition types from the Discoverable Partitions Specification:
+	// https://uapi-group.org/specifications/specs/discoverable_partitions_specification/
+	rootx86GPTTypeID         = "44479540-F297-41B2-9AF7-D131D5F0458A"
+	rootx8664GPTTypeID       = "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709"
+	rootARMGPTTypeID         = "69DAD710-2CE4-4E3C-B16C-21A1D49ABED3"
+	rootARM64GPTTypeID       = "B921B045-1DF0-41C3-AF44-4C6F280D3FAE"
+	rootRISCV32GPTTypeID     = "60D5A7FE-8E7D-435C-B714-3DD8162144E1"
+	rootRISCV64GPTTypeID     = "72EC70A6-CF74-40E6-BD49-4BDA08E8F224"
+	rootPPC64LEGPTTypeID     = "C31C45E6-3F39-412E-80FB-4809C4980599"
+	rootS390XGPTTypeID       = "5EEAD9A9-FE09-4A1E-A1D7-520D00531306"
+	rootLoongArch64GPTTypeID = "77055800-792C-4F94-B39A-98C91B762BB6"
 )
 
+// growableGPTTypes are the GPT partition types we know how to grow.
+// What to do after growing the partition is decided by what's on it,
+// not its type: a PV on a generic Linux partition is fine.
+var growableGPTTypes = map[string]bool{
+	lvmGPTTypeID:             true,
+	linuxGPTTypeID:           true,
+	rootx86GPTTypeID:         true,
+	rootx8664GPTTypeID:       true,
+	rootARMGPTTypeID:         true,
+	rootARM64GPTTypeID:       true,
+	rootRISCV32GPTTypeID:     true,
+	rootRISCV64GPTTypeID:     true,
+	rootPPC64LEGPTTypeID:     true,
+	rootS390XGPTTypeID:       true,
+	rootLoongArch64GPTTypeID: true,
+}
+
+// checkGrowableType returns an error if part's type isn't one we
+// know is safe to grow.
+func checkGrowableType(part sfdiskLine, isGPT bool) error {
+	t := part.Type()
+	if isESPType(t) {
+		return fmt.Errorf("last partition %s is an EFI system partition; not growing it", part.dev)
+	}
+	if isGPT {
+		if !growableGPTTypes[strings.ToUpper(t)] {
+			return fmt.Errorf("unknown GPT partition type %q for %s", t, part.dev)
+		}
+		return nil
+	}
+	if !growableMBRTypes[strings.ToLower(t)] {
+		return fmt.Errorf("unknown MBR partition type %q for %s", t, part.dev)
+	}
+	return nil
+}
+
+// bootMounts are where a system's boot or EFI system partition is
+// mounted.
+var bootMounts = []string{"/boot", "/boot/efi", "/efi"}
+
+// checkBootPartition returns an error if part is mounted at one of
+// bootMounts, unless --force. Growing such a partition is rarely what
+// was meant, and a bootloader that can't cope with its new size can
+// leave the machine unbootable. An ESP is refused by its type; this
+// catches one of another type, or a /boot partition, that an unusual
+// layout put last.
+func checkBootPartition(part sfdiskLine) error {
+	mounts, err := readMounts()
+	if err != nil {
+		return err
+	}
+	for _, m := range mounts {
+		if !containsString(bootMounts, m.mnt) || canonicalDev(m.dev) != part.dev {
+			continue
+		}
+		if *force {
+			warnf("--force: growing %s, which is mounted at %s", part.dev, m.mnt)
+			return nil
+		}
+		return fmt.Errorf("last partition %s is mounted at %s; growing it is rarely intended, and the bootloader may not cope. Use --force to grow it anyway", part.dev, m.mnt)
+	}
+	return nil
+}
+
+// growableMBRTypes are the MBR partition types we know how to grow.
+var growableMBRTypes = map[string]bool{
+	"83": true, // Linux
+	"8e": true, // Linux LVM
+	"fd": true, // Linux RAID autodetect
+}
+
 type partitionResizer string // "/dev/sda3"
 
 // diskDev maps "/dev/sda3" to "/dev/sda".
-func diskDev(partDev string) string {
+func diskDev(partDev string) (string, error) {
 	if !strings.HasPrefix(partDev, "/dev/") {
-		panic("bogus partition dev " + partDev)
+		return "", fmt.Errorf("bogus partition dev %q", partDev)
 	}
 	if strings.HasPrefix(partDev, "/dev/sd") || strings.HasPrefix(partDev, "/dev/vd") {
-		return strings.TrimRight(partDev, "0123456789")
+		return strings.TrimRight(partDev, "0123456789"), nil
 	}
-	if strings.HasPrefix(partDev, "/dev/mmcblk") {
-		v := strings.TrimRight(partDev, "0123456789")
-		v = strings.TrimSuffix(v, "p")
-		return v
+	if hasNumberedDiskPrefix(partDev) {
+		disk := diskFromPartition(partDev)
+		if disk == "" {
+			return "", fmt.Errorf("partition %q doesn't look like an nvme, mmc, loop or nbd partition", partDev)
+		}
+		return disk, nil
 	}
-	if strings.HasPrefix(partDev, "/dev/nvme") {
-		chopP := regexp.MustCompile(`p\d+$`)
-		if !chopP.MatchString(partDev) {
-			panic(fmt.Sprintf("partition %q doesn't look like an nvme partition", partDev))
+	if isDMPartition(partDev) {
+		disk, err := dmDiskOf(partDev)
+		if err != nil {
+			return "", fmt.Errorf("finding the disk of device-mapper partition %s: %v", partDev, err)
 		}
-		return chopP.ReplaceAllString(partDev, "")
+		return disk, nil
 	}
-	panic(fmt.Sprintf("Unsupport device %q; TODO: handle other device types; ask kernel", partDev))
+	return "", fmt.Errorf("unsupported device %q; TODO: handle other device types; ask kernel", partDev)
+}
+
+// isPartitionDevName reports whether dev names a partition on a kind
+// of disk that diskDev understands.
+func isPartitionDevName(dev string) bool {
+	switch {
+	case strings.HasPrefix(dev, "/dev/sd"), strings.HasPrefix(dev, "/dev/vd"):
+		return devEndsInNumber(dev)
+	case hasNumberedDiskPrefix(dev):
+		return diskFromPartition(dev) != ""
+	case strings.HasPrefix(dev, "/dev/mapper/"), strings.HasPrefix(dev, "/dev/dm-"):
+		return isDMPartition(dev)
+	}
+	return false
+}
+
+// numberedDiskPrefixes are the device names of the kinds of disk whose
+// names end in a number, and so whose partitions have a "p" before
+// their own number.
+var numberedDiskPrefixes = []string{"/dev/nvme", "/dev/mmcblk", "/dev/loop", "/dev/nbd"}
+
+func hasNumberedDiskPrefix(dev string) bool {
+	for _, p := range numberedDiskPrefixes {
+		if strings.HasPrefix(dev, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// partitionDevName returns the device of partition num of disk:
+// "/dev/sda1", or with a "p" if disk's name ends in a number, as in
+// "/dev/nvme0n1p1" and "/dev/mmcblk0p1".
+func partitionDevName(disk string, num int) string {
+	if devEndsInNumber(disk) {
+		return fmt.Sprintf("%sp%d", disk, num)
+	}
+	return fmt.Sprintf("%s%d", disk, num)
+}
+
+// diskFromPartition returns the disk of the partition device part,
+// undoing partitionDevName, or "" if part isn't named like a
+// partition: its name doesn't end in a number, or it's on a kind of
+// disk whose names end in a number but lacks the "p" before its own,
+// as with the whole disk "/dev/nvme0n1".
+func diskFromPartition(part string) string {
+	disk := strings.TrimRight(part, "0123456789")
+	if disk == part {
+		return ""
+	}
+	if d := strings.TrimSuffix(disk, "p"); d != disk && devEndsInNumber(d) {
+		return d
+	}
+	if hasNumberedDiskPrefix(part) {
+		return ""
+	}
+	return disk
+}
+
+// getRawResizer returns a Resizer for partDev, a partition that holds
+// neither a mounted filesystem nor an LVM PV, for use with --raw.
+func getRawResizer(partDev string) (Resizer, error) {
+	// Everything after this, down to the sfdisk commands, uses the
+	// kernel's name for the partition, not e.g. a /dev/disk/by-id link.
+	partDev = canonicalDev(partDev)
+	if !isPartitionDevName(partDev) {
+		return nil, fmt.Errorf("%q is not a partition device", partDev)
+	}
+	mounted, err := isMountedDev(partDev)
+	if err != nil {
+		return nil, err
+	}
+	if mounted {
+		return nil, fmt.Errorf("%s is mounted; pass its mount point instead of using --raw", partDev)
+	}
+	return partitionResizer(partDev), nil
 }
 
 func (p partitionResizer) String() string { return fmt.Sprintf("partition %s", string(p)) }
 
 func (p partitionResizer) State() (string, error) {
-	n, err := readInt64File(fmt.Sprintf("/sys/class/block/%s/size", filepath.Base(string(p))))
+	n, err := readInt64File(filepath.Join(sysfsDir, "class", "block", blockName(currentDev(string(p))), "size"))
 	if err != nil {
 		return "", err
 	}
@@ -81,11 +240,28 @@ func (p partitionResizer) DepResizer() (Resizer, error) { return nil, nil }
 func (p partitionResizer) Resize() error {
 	vlogf("Resizing partition %q ...", string(p))
 	partDev := string(p)
-	diskDev := diskDev(partDev)
+	diskDev, err := diskDev(partDev)
+	if err != nil {
+		return err
+	}
+	if err := lockDisk(diskDev); err != nil {
+		return err
+	}
+	if err := checkSfdiskVersion(); err != nil {
+		return err
+	}
+	if isDMDev(diskDev) {
+		if err := growDMLinear(diskDev); err != nil {
+			return err
+		}
+	}
 	vlogf("Getting partition table for %q ...", diskDev)
-	pt := getPartitionTable(diskDev)
+	pt, err := readPartitionTable(diskDev)
+	if err != nil {
+		return err
+	}
 	if len(pt.parts) == 0 {
-		log.Fatalf("device %q has no partitions", diskDev)
+		return fmt.Errorf("device %q has no partitions", diskDev)
 	}
 	vlogf("Device %q has %d partitions.", diskDev, len(pt.parts))
 	var isGPT bool
@@ -99,7 +275,7 @@ func (p partitionResizer) Resize() error {
 		// But only trust the value "dos", because if it's gpt and sfdisk
 		// is old and doesn't support gpt, we don't want to use that old sfdisk
 		// to manipulate the gpt tables.
-		out, err := exec.Command("blkid", "-o", "export", diskDev).Output()
+		out, err := cmdOutput(command("blkid", "-o", "export", diskDev))
 		if err != nil {
 			return fmt.Errorf("error running blkid: %v", execErrDetail(err))
 		}
@@ -114,109 +290,411 @@ func (p partitionResizer) Resize() error {
 		// It might work, but fail as a precaution. Untested.
 		return fmt.Errorf("unsupported partition table type %q on %s", t, diskDev)
 	}
+	partTableTypes[diskDev] = "dos"
+	if isGPT {
+		partTableTypes[diskDev] = "gpt"
+		repaired, err := checkGPT(diskDev)
+		if err != nil {
+			return err
+		}
+		if repaired {
+			if pt, err = readPartitionTable(diskDev); err != nil {
+				return err
+			}
+		}
+	}
 
-	part, ok := pt.lastNonZeroPartition()
+	// Before copying out part, as this can fix its sector size.
+	sectorSize, err := pt.checkedSectorSize(diskDev)
+	if err != nil {
+		return err
+	}
+	part, ok := pt.lastPartition()
 	if !ok {
 		return fmt.Errorf("no non-zero partition found on %s", diskDev)
 	}
+	if !strings.HasPrefix(part.dev, "/dev/") || part.Size() <= 0 {
+		return fmt.Errorf("last partition on %s is bogus: %v", diskDev, part)
+	}
+	if want := partitionDevName(diskDev, part.pno); !isDMDev(diskDev) && part.dev != want {
+		return fmt.Errorf("sfdisk calls partition %d of %s %s, not %s", part.pno, diskDev, part.dev, want)
+	}
 	partDev = part.dev
-	lastType := part.Type()
-
-	if isGPT {
-		switch lastType {
-		case lvmGPTTypeID, rootx8664GPTTypeID, linuxGPTTypeID:
-		default:
-			return fmt.Errorf("unknown GPT partition type %q for %s", lastType, part.dev)
-		}
-	} else {
-		switch lastType {
-		case "83":
-		default:
-			return fmt.Errorf("unknown MBR partition type %q for %s", lastType, part.dev)
+	explainf("%s is the last partition on %s, the only one that can grow into space added at the end of the disk", part.dev, diskDev)
+	if err := checkGrowableType(part, isGPT); err != nil {
+		return err
+	}
+	if err := checkBootPartition(part); err != nil {
+		return err
+	}
+	if after, ok := pt.partitionAfter(part); ok {
+		what := "partition"
+		if isESPType(after.Type()) {
+			what = "EFI system partition"
 		}
+		return fmt.Errorf("can't grow %s: %s %s is after it, at the end of the disk", part.dev, what, after.dev)
 	}
 
 	if *verbose {
-		fmt.Printf("Current partition table:\n")
-		pt.Write(os.Stdout)
-		fmt.Println()
+		fmt.Fprintf(progress(), "Current partition table:\n")
+		pt.Write(progress())
+		fmt.Fprintln(progress())
 	}
 
-	size, err := readInt64File("/sys/block/" + filepath.Base(diskDev) + "/size")
+	sysSize, err := diskSize(diskDev)
 	if err != nil {
 		return err
 	}
+	if err := checkMaxDiskSize(diskDev, sysSize); err != nil {
+		return err
+	}
+	if sysSize == 0 && strings.HasPrefix(diskDev, "/dev/nbd") {
+		// A disconnected NBD device has no size. A connected one
+		// only learns its export grew when nbd-client reconnects.
+		return fmt.Errorf("%s has size 0; is nbd-client connected to it?", diskDev)
+	}
+	size := diskSectors(sysSize, sectorSize)
 	end := part.Start() + part.Size()
-	remain := size - end
+	gaps, tail := pt.freeRegions(size)
+	for _, g := range gaps {
+		notef("%s", gapNote(diskDev, part.dev, g, sectorSize))
+	}
+	remain := tail.size
 	if *verbose {
-		fmt.Printf("Cur size: %d\n", size)
-		fmt.Printf("Part start: %d\n", part.Start())
-		fmt.Printf("Part size: %d\n", part.Size())
-		fmt.Printf("Part end: %d\n", end)
-		fmt.Printf("Remaining after final partition: %d\n", remain)
-	}
-	sectorSize := 512 // TODO: get from /sys/block/sda/queue/hw_sector_size
-	endReserve := int64(1<<20) / int64(sectorSize)
-	if remain <= endReserve {
+		fmt.Fprintf(progress(), "Cur size: %d\n", size)
+		fmt.Fprintf(progress(), "Part start: %d\n", part.Start())
+		fmt.Fprintf(progress(), "Part size: %d\n", part.Size())
+		fmt.Fprintf(progress(), "Part end: %d\n", end)
+		fmt.Fprintf(progress(), "Remaining after final partition: %d\n", remain)
+	}
+	explainf("%s is %d sectors of %d bytes; %s ends at sector %d (start %d + size %d), leaving %d sectors after it", diskDev, size, sectorSize, part.dev, end, part.Start(), part.Size(), remain)
+	extend := growSectors(remain, sectorSize, isGPT, *force)
+	if extend <= 0 && remain > 0 {
+		notef("%s", reserveNote(diskDev, part.dev, size, remain, sectorSize, isGPT))
+	}
+	if reserve := remain - extend; extend > 0 {
+		explainf("reserving %d sectors at the end of the disk (%s), so growing by %d - %d = %d sectors", reserve, endReserveReason(isGPT, reserve < endReserve(sectorSize, isGPT, false)), remain, reserve, extend)
+	}
+	if *toPercent > 0 {
+		if pe := percentExtend(size, end, sectorSize, *toPercent); pe < extend {
+			explainf("--to-percent=%d: ending %s %d%% of the way into the disk, rounded down to a MiB, grows it by only %d sectors", *toPercent, part.dev, *toPercent, pe)
+			extend = pe
+		}
+	}
+	if opt := reportedOptimalIOSize(diskDev); *alignOptimal && opt > 0 && extend > 0 {
+		aligned := alignedExtend(end, extend, sectorSize, opt)
+		if aligned < extend {
+			notef("partition %s: left %d sectors unallocated to end on a multiple of the disk's %d byte optimal I/O size", part.dev, extend-aligned, opt)
+		}
+		extend = aligned
+	}
+	if extend <= 0 {
 		// partition at max size; no need to extend
+		explainf("%s already reaches the end of the disk, less the %d sectors reserved there (%s), so it isn't grown", part.dev, endReserve(sectorSize, isGPT, *force), endReserveReason(isGPT, *force))
 		return nil
 	}
+	if note := alignmentNote(part, sectorSize, optimalIOSize(diskDev)); note != "" {
+		warnf("%s", note)
+	}
 
-	extend := remain - endReserve
-	part.SetSize(part.Size() + extend)
-	pt.RemoveMeta("last-lba") // or sfdisk complains
+	if err := pt.growPartition(part, part.Size()+extend, isGPT); err != nil {
+		return err
+	}
 
 	if *verbose {
-		fmt.Printf("Need to extend disk by %d sectors (%d bytes, %0.03f GiB)\n", extend, extend*512, float64(extend)*512/(1<<30))
-		fmt.Printf("New partition table to write:\n")
+		fmt.Fprintf(progress(), "Need to extend disk by %s\n", humanSectors(extend, sectorSize))
+		fmt.Fprintf(progress(), "New partition table to write:\n")
 	}
 
+	return writePartitionTable(diskDev, pt, part)
+}
+
+// writePartitionTable writes pt to diskDev with sfdisk and then tells
+// the kernel about the new size of part, which is in pt.
+func writePartitionTable(diskDev string, pt *partitionTable, part sfdiskLine) error {
 	var newPart bytes.Buffer
 	pt.Write(&newPart)
 	if *verbose {
-		fmt.Printf("%s\n", newPart.Bytes())
+		fmt.Fprintf(progress(), "%s\n", newPart.Bytes())
 	}
 
+	if err := pt.checkIDsKept(newPart.Bytes()); err != nil {
+		return err
+	}
+	if err := validateTable(diskDev, newPart.Bytes()); err != nil {
+		return err
+	}
+	// If nothing on the disk is in use, sfdisk can have the kernel
+	// reread the whole table, and there's no need for BLKPG or partx.
+	idle := diskIdle(diskDev)
+	cmd := command("/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", diskDev)
+	if idle {
+		cmd = command("/sbin/sfdisk", "-f", diskDev)
+	}
+	cmd.Stdin = bytes.NewReader(newPart.Bytes())
 	if *dry {
-		fmt.Printf("[dry-run] would've run sfdisk -f to set new partition table\n")
+		if *simulate {
+			if err := simulateTable(diskDev, newPart.Bytes()); err != nil {
+				return err
+			}
+		}
+		skipDryRun(cmd)
 		return nil
 	}
+	if err := checkAllowedHours(); err != nil {
+		return err
+	}
+
+	// Note the partition's stable ID, in case its device node
+	// changes when the kernel rereads the table.
+	partUUID, err := blkidValue(part.dev, "PARTUUID")
+	if err != nil {
+		vlogf("can't get PARTUUID of %s: %v", part.dev, err)
+	}
 
 	if *verbose {
-		fmt.Println("Setting new partition table...")
+		fmt.Fprintln(progress(), "Setting new partition table...")
 	}
-	cmd := exec.Command("/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", diskDev)
-	cmd.Stdin = bytes.NewReader(newPart.Bytes())
 	var outBuf bytes.Buffer
 	if *verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd.Stdout = io.MultiWriter(progress(), &outBuf)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &outBuf)
 	} else {
 		cmd.Stdout = &outBuf
 		cmd.Stderr = &outBuf
 	}
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("sfdisk: %v: %s", err, outBuf.Bytes())
+	if err := cmdRun(cmd); err != nil {
+		return fmt.Errorf("sfdisk: %v: %s", err, capOutput(cmd.Args, outBuf.Bytes()))
 	}
+	// With --strict, fail on unexpected output, but only once the
+	// kernel knows about the table that was written anyway.
+	strictErr := checkOutput(cmd.Args, outBuf.Bytes())
 
 	// Tell the kernel.
-	if err := updateKernelPartition(diskDev, part); err != nil {
-		return fmt.Errorf("updating kernel of %s partition change: %v", partDev, err)
+	if idle {
+		vlogf("%s is idle; sfdisk had the kernel reread its partition table", diskDev)
+	} else if err := timed("kernel update of "+part.dev, func() error { return tellKernel(diskDev, part) }); err != nil {
+		return fmt.Errorf("updating kernel of %s partition change: %v", part.dev, err)
+	}
+	if err := reresolvePartition(part.dev, partUUID); err != nil {
+		return err
+	}
+	if err := checkLabelIDWritten(diskDev, pt.label); err != nil {
+		return err
+	}
+	return strictErr
+}
+
+// checkLabelIDWritten returns an error if diskDev's partition table,
+// as sfdisk reads it back after writing, doesn't have the label-id
+// (disk GUID or MBR disk ID) want, which it had before.
+func checkLabelIDWritten(diskDev, want string) error {
+	if want == "" {
+		return nil
+	}
+	pt, err := readPartitionTable(diskDev)
+	if err != nil {
+		return fmt.Errorf("rereading partition table to check its label-id: %v", err)
+	}
+	if pt.label != want {
+		return fmt.Errorf("writing the partition table of %s changed its label-id from %s to %q, which PARTUUIDs may depend on; set it back with: sfdisk --disk-id %s %s", diskDev, want, pt.label, diskDev, want)
 	}
 	return nil
 }
 
+// optimalIOSize returns the alignment in bytes partitions on diskDev
+// should have: the disk's optimal I/O size if it reports one, or else
+// the usual 1 MiB.
+func optimalIOSize(diskDev string) int64 {
+	if n := reportedOptimalIOSize(diskDev); n > 0 {
+		return n
+	}
+	return 1 << 20
+}
+
+// reportedOptimalIOSize returns the optimal I/O size in bytes diskDev
+// reports, or 0 if it doesn't report one.
+func reportedOptimalIOSize(diskDev string) int64 {
+	n, err := readInt64File(filepath.Join(sysfsDir, "block", blockName(diskDev), "queue", "optimal_io_size"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// alignedExtend returns how many of the extend sectors a partition
+// ending at sector end can grow by so that it ends on a multiple of
+// align bytes. It's 0 if growing would leave the end short of the
+// next multiple.
+func alignedExtend(end, extend, sectorSize, align int64) int64 {
+	if align%sectorSize != 0 || align <= sectorSize {
+		return extend
+	}
+	n := align / sectorSize
+	newEnd := (end + extend) / n * n
+	if newEnd <= end {
+		return 0
+	}
+	return newEnd - end
+}
+
+// alignmentNote returns a note about part's start not being aligned
+// to align bytes, or the empty string if it is. Partitions made by
+// old tools often start at sector 63. Growing one keeps its start
+// where it is; moving it would mean moving all its data.
+func alignmentNote(part sfdiskLine, sectorSize, align int64) string {
+	off := part.Start() * sectorSize % align
+	if off == 0 {
+		return ""
+	}
+	return fmt.Sprintf("partition %s starts at sector %d, %d bytes past a %d byte boundary, so I/O to it may be slower; growing it without moving its start", part.dev, part.Start(), off, align)
+}
+
+// endReserve returns the number of sectors to leave unallocated at
+// the end of a disk. Normally that's 1 MiB (or on a GPT disk,
+// --gpt-reserve), so the partition ends aligned, but with force it's
+// only what a GPT's backup header and partition entries need.
+func endReserve(sectorSize int64, isGPT, force bool) int64 {
+	if isGPT {
+		if force {
+			return gptMinReserve(sectorSize)
+		}
+		n, err := gptReserveSectors(*gptReserve, sectorSize)
+		if err != nil {
+			// run rejects bad values up front.
+			return (1 << 20) / sectorSize
+		}
+		return n
+	}
+	if !force {
+		return (1 << 20) / sectorSize
+	}
+	return 0
+}
+
+// percentExtend returns how many sectors to extend a partition ending
+// at sector end by so it ends pct percent of the way into a disk of
+// size sectors, rounded down to a MiB boundary.
+func percentExtend(size, end, sectorSize int64, pct int) int64 {
+	target := size/100*int64(pct) + size%100*int64(pct)/100
+	align := (1 << 20) / sectorSize
+	return target/align*align - end
+}
+
+// growSectors returns how many sectors to extend the last partition
+// by, given the number of unallocated sectors after it. It returns
+// zero or less if the partition shouldn't be extended. With force,
+// the smaller reserve is used only when the normal one would leave
+// nothing to grow into.
+func growSectors(remain, sectorSize int64, isGPT, force bool) int64 {
+	extend := remain - endReserve(sectorSize, isGPT, false)
+	if extend > 0 || !force {
+		return extend
+	}
+	extend = remain - endReserve(sectorSize, isGPT, true)
+	if extend > 0 {
+		warnf("--force: growing into the last MiB of the disk; the end of the partition may be poorly aligned")
+	}
+	return extend
+}
+
+// diskIdle reports whether none of diskDev and its partitions are
+// mounted, used for swap, or held by another block device such as an
+// LVM PV or dm-crypt mapping. It's false if that can't be told.
+func diskIdle(diskDev string) bool {
+	if isDMDev(diskDev) {
+		return false // its partitions are kpartx's, which sfdisk can't reread
+	}
+	name := filepath.Base(diskDev)
+	dir := filepath.Join(sysfsDir, "block", name)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	inUse := map[string]bool{}
+	mounts, err := readMounts()
+	if err != nil {
+		return false
+	}
+	for _, m := range mounts {
+		if m.dev == "/dev/root" {
+			return false // can't tell which device it is
+		}
+		inUse[m.dev] = true
+	}
+	if swaps, err := ioutil.ReadFile(filepath.Join(procDir, "swaps")); err == nil {
+		for _, line := range strings.Split(string(swaps), "\n") {
+			if f := strings.Fields(line); len(f) > 0 {
+				inUse[f[0]] = true
+			}
+		}
+	}
+	devDirs := map[string]string{name: dir}
+	for _, fi := range fis {
+		if _, err := os.Stat(filepath.Join(dir, fi.Name(), "partition")); err == nil {
+			devDirs[fi.Name()] = filepath.Join(dir, fi.Name())
+		}
+	}
+	for n, d := range devDirs {
+		if inUse["/dev/"+n] {
+			return false
+		}
+		if holders, _ := ioutil.ReadDir(filepath.Join(d, "holders")); len(holders) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// tellKernel tells the kernel about part's new size on diskDev. It
+// uses the BLKPG ioctl and, should the kernel refuse that (it can
+// return EBUSY for partitions in use), falls back to partx. A
+// device-mapper disk's partitions are updated with kpartx.
+func tellKernel(diskDev string, part sfdiskLine) error {
+	if isDMDev(diskDev) {
+		// The kernel doesn't partition device-mapper devices;
+		// kpartx maps their partitions.
+		return kpartxUpdate(diskDev)
+	}
+	err := blkpgResizePartition(diskDev, part)
+	if err == nil {
+		return nil
+	}
+	vlogf("BLKPG resize of %s failed: %v; trying partx", part.dev, err)
+	cmd := command("partx", "-u", "--nr", strconv.Itoa(part.pno), diskDev)
+	out, perr := cmdCombinedOutput(cmd)
+	if perr != nil {
+		return fmt.Errorf("BLKPG ioctl: %v; partx -u: %v, %s", err, perr, capOutput(cmd.Args, out))
+	}
+	if err := checkOutput(cmd.Args, out); err != nil {
+		return err
+	}
+	warnf("kernel refused BLKPG resize of %s (%v); updated it with partx instead", part.dev, err)
+	return nil
+}
+
+// blkpgResizePartition is updateKernelPartition, as a variable for tests.
+var blkpgResizePartition = updateKernelPartition
+
 func updateKernelPartition(diskDev string, part sfdiskLine) error {
 	devf, err := os.Open(diskDev)
 	if err != nil {
 		return err
 	}
 	defer devf.Close()
+	start, err := sectorBytes(part.Start(), part.sectorSize)
+	if err != nil {
+		return err
+	}
+	length, err := sectorBytes(part.Size(), part.sectorSize)
+	if err != nil {
+		return err
+	}
 	arg := &unix.BlkpgIoctlArg{
 		Op: unix.BLKPG_RESIZE_PARTITION,
 		Data: (*byte)(unsafe.Pointer(&unix.BlkpgPartition{
-			Start:  part.Start() * 512,
-			Length: part.Size() * 512,
+			Start:  start,
+			Length: length,
 			Pno:    int32(part.pno),
 		})),
 	}
@@ -227,26 +705,131 @@ func updateKernelPartition(diskDev string, part sfdiskLine) error {
 	return nil
 }
 
+// diskSectors converts a disk size read from /sys/block/*/size, which
+// is always in 512-byte units, to sectors of sectorSize bytes, a
+// multiple of 512.
+func diskSectors(sysfsSize, sectorSize int64) int64 {
+	return sysfsSize / (sectorSize / 512) // not sysfsSize*512, which could overflow
+}
+
 type partitionTable struct {
 	meta  []string // without newlines
 	parts []sfdiskLine
+	uuids map[string]string // partition device to uuid=, as parsed
+	label string            // label-id: the disk GUID or MBR disk ID, as parsed
+}
+
+// metaAliases are other names for header keys that some versions of
+// sfdisk print.
+var metaAliases = map[string][]string{
+	"label": {"disklabel type"},
 }
 
+// Meta returns the value of the header line with key k, matched
+// without regard to case, or the empty string if there's none.
 func (pt *partitionTable) Meta(k string) string {
 	for _, row := range pt.meta {
-		if strings.HasPrefix(row, k) &&
-			strings.HasPrefix(row, k+":") {
-			return strings.TrimSpace(row[len(k)+1:])
+		if metaKeyIs(row, k) {
+			return strings.TrimSpace(row[strings.Index(row, ":")+1:])
 		}
 	}
 	return ""
 }
 
+// metaKeyIs reports whether the header line row has the key k or one
+// of its aliases, without regard to case or spacing.
+func metaKeyIs(row, k string) bool {
+	i := strings.Index(row, ":")
+	if i == -1 {
+		return false
+	}
+	for _, key := range append([]string{k}, metaAliases[k]...) {
+		if strings.EqualFold(strings.TrimSpace(row[:i]), key) {
+			return true
+		}
+	}
+	return false
+}
+
+// SectorSize returns the size in bytes of the sectors the table's
+// partitions are measured in, from the "sector-size" line newer
+// versions of sfdisk write. It returns 512 if there's no such line;
+// checkedSectorSize asks the kernel instead.
+func (pt *partitionTable) SectorSize() (int64, error) {
+	v := pt.Meta("sector-size")
+	if v == "" {
+		return 512, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 512 || n&(n-1) != 0 {
+		return 0, fmt.Errorf("bogus sfdisk sector-size %q", v)
+	}
+	return n, nil
+}
+
+// checkedSectorSize returns pt.SectorSize, after checking it against
+// the logical block size the kernel reports for diskDev, if any.
+// Older sfdisk doesn't print a sector-size line, so without one the
+// kernel's size is used, rather than assuming 512.
+func (pt *partitionTable) checkedSectorSize(diskDev string) (int64, error) {
+	n, err := pt.SectorSize()
+	if err != nil {
+		return 0, err
+	}
+	kn, err := logicalSectorSize(diskDev)
+	if err != nil {
+		return 0, err
+	}
+	if kn == 0 || kn == n {
+		return n, nil
+	}
+	if pt.Meta("sector-size") != "" {
+		return 0, fmt.Errorf("sfdisk says %s has %d byte sectors, but the kernel says %d", diskDev, n, kn)
+	}
+	for i := range pt.parts {
+		pt.parts[i].sectorSize = kn
+	}
+	return kn, nil
+}
+
+// logicalSectorSize returns the logical sector size of diskDev in
+// bytes, as the kernel reports it: 4096 on 4Kn disks and some NVMe
+// namespaces, else usually 512. That's the unit its partition table is
+// in. It's 0 if the kernel doesn't say.
+func logicalSectorSize(diskDev string) (int64, error) {
+	n, err := readInt64File(filepath.Join(sysfsDir, "block", blockName(diskDev), "queue", "logical_block_size"))
+	if err != nil {
+		return 0, nil
+	}
+	if n < 512 || n&(n-1) != 0 {
+		return 0, fmt.Errorf("bogus logical block size %d for %s", n, diskDev)
+	}
+	return n, nil
+}
+
+// growPartition sets the size of part, a partition in pt, to size,
+// past the end of the disk's old size. A GPT dump records the old last
+// usable LBA, which sfdisk would refuse to put a partition past, so
+// it's dropped for sfdisk to work out again from the disk's new size.
+// MBR has no such field, so an MBR table is otherwise left alone.
+func (pt *partitionTable) growPartition(part sfdiskLine, size int64, isGPT bool) error {
+	if err := part.SetSize(size); err != nil {
+		return err
+	}
+	if isGPT {
+		pt.RemoveMeta("last-lba")
+	}
+	return nil
+}
+
+// RemoveMeta removes the header lines with key, matched as by Meta.
+// Header lines are otherwise written back as they were read, including
+// ones we don't know, such as "grain:", so nothing sfdisk printed is
+// lost.
 func (pt *partitionTable) RemoveMeta(key string) {
 	var newMeta []string
 	for _, meta := range pt.meta {
-		if strings.HasPrefix(meta, key) &&
-			strings.HasPrefix(meta, key+": ") {
+		if metaKeyIs(meta, key) {
 			continue
 		}
 		newMeta = append(newMeta, meta)
@@ -269,23 +852,149 @@ func (pt *partitionTable) Write(w io.Writer) error {
 	return err
 }
 
-func (pt *partitionTable) lastNonZeroPartition() (part sfdiskLine, ok bool) {
-	for i := len(pt.parts) - 1; i >= 0; i-- {
-		part = pt.parts[i]
-		if part.Type() == "0" && part.Start() == 0 && part.Size() == 0 {
-			// Skip useless partitions.
-			// See https://github.com/google/embiggen-disk/issues/6#issuecomment-429055087
+// lastPartition returns the partition that ends last on the disk,
+// which is the only one that can grow into the free space at the end.
+//
+// That's usually, but not necessarily, the last one listed, as
+// partitions may be numbered out of on-disk order. MBR extended
+// partitions are skipped in favor of the logical partitions within,
+// and EFI system partitions are never chosen unless there's nothing
+// else, as they're never the one to grow.
+func (pt *partitionTable) lastPartition() (part sfdiskLine, ok bool) {
+	var end int64
+	var esp sfdiskLine
+	var haveESP bool
+	for _, p := range pt.parts {
+		if !p.isReal() {
 			continue
 		}
-		return part, true
+		if isESPType(p.Type()) {
+			esp, haveESP = p, true
+			continue
+		}
+		// On ties, prefer the later entry.
+		if e := p.Start() + p.Size(); !ok || e >= end {
+			part, end, ok = p, e, true
+		}
+	}
+	if !ok && haveESP {
+		return esp, true // for checkGrowableType to refuse
 	}
 	return
 }
 
+// A freeRegion is a span of unpartitioned sectors on a disk.
+type freeRegion struct {
+	start, size int64
+}
+
+// freeRegions returns the unpartitioned spans of a disk of size
+// sectors partitioned as pt: the gaps between partitions of more than
+// the usual 1 MiB of alignment slack, and the tail after the last
+// partition, which is the only one a partition can grow into. The
+// space before the first partition isn't counted.
+func (pt *partitionTable) freeRegions(size int64) (gaps []freeRegion, tail freeRegion) {
+	var parts []sfdiskLine
+	for _, p := range pt.parts {
+		if p.isReal() {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, freeRegion{}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Start() < parts[j].Start() })
+	slack := int64(2048) // 1 MiB of 512-byte sectors
+	if ss := parts[0].sectorSize; ss > 0 {
+		slack = (1 << 20) / ss
+	}
+	cursor := parts[0].Start()
+	for _, p := range parts {
+		if n := p.Start() - cursor; n > slack {
+			gaps = append(gaps, freeRegion{start: cursor, size: n})
+		}
+		if end := p.Start() + p.Size(); end > cursor {
+			cursor = end
+		}
+	}
+	tail = freeRegion{start: cursor}
+	if size > cursor {
+		tail.size = size - cursor
+	}
+	return gaps, tail
+}
+
+// gapNote says that the free region g between partitions on diskDev
+// can't be used by growing partDev.
+func gapNote(diskDev, partDev string, g freeRegion, sectorSize int64) string {
+	return fmt.Sprintf("%s has %s free between partitions at sector %d, which growing %s can't use; only the free space at the end of the disk can be grown into", diskDev, humanSectors(g.size, sectorSize), g.start, partDev)
+}
+
+// reserveNote explains why partDev, on a disk diskDev of size sectors,
+// isn't grown into the remain free sectors after it: they're no more
+// than the end reserve. That's not the same as there being nothing
+// free, and on tiny disks, such as test images, the reserve can be
+// most of the disk.
+func reserveNote(diskDev, partDev string, size, remain, sectorSize int64, isGPT bool) string {
+	reserve := endReserve(sectorSize, isGPT, false)
+	msg := fmt.Sprintf("%s ends %s before the end of %s, within the %s kept free there (%s); not growing it", partDev, humanSectors(remain, sectorSize), diskDev, humanSectors(reserve, sectorSize), endReserveReason(isGPT, false))
+	if size <= reserve {
+		msg += fmt.Sprintf(". The whole disk is only %s", humanSectors(size, sectorSize))
+	}
+	if remain > endReserve(sectorSize, isGPT, true) {
+		msg += "; --force grows into it anyway"
+	}
+	return msg
+}
+
+// partitionAfter returns a partition that's after part on the disk,
+// which would stop part growing, such as an EFI system partition at
+// the end of the disk.
+func (pt *partitionTable) partitionAfter(part sfdiskLine) (after sfdiskLine, ok bool) {
+	end := part.Start() + part.Size()
+	for _, p := range pt.parts {
+		if p.isReal() && p.Start() >= end {
+			return p, true
+		}
+	}
+	return
+}
+
+// isReal reports whether sl is a partition that holds something: not
+// an empty or zero-size slot or an MBR extended partition, which only
+// holds other partitions.
+func (sl sfdiskLine) isReal() bool {
+	if sl.dev == "" || sl.Size() <= 0 {
+		// See https://github.com/google/embiggen-disk/issues/6#issuecomment-429055087
+		return false
+	}
+	return !isExtendedMBRType(sl.Type())
+}
+
+// espGPTTypeID is the GPT type of an EFI system partition.
+const espGPTTypeID = "C12A7328-F81F-11D2-BA4B-00A0C93EC93B"
+
+// isESPType reports whether t is the GPT or MBR type of an EFI system
+// partition.
+func isESPType(t string) bool {
+	return strings.EqualFold(t, espGPTTypeID) || t == "ef"
+}
+
+// isExtendedMBRType reports whether t is the type of an MBR extended
+// partition, which contains logical partitions.
+func isExtendedMBRType(t string) bool {
+	switch t {
+	case "5", "f", "85":
+		return true
+	}
+	return false
+}
+
 type sfdiskLine struct {
-	dev  string   // "/dev/sda1"
-	attr []string // key=value or key ("type=83", "bootable", "size=497664")
-	pno  int      //partition number
+	dev        string   // "/dev/sda1"
+	attr       []string // key=value or key ("type=83", "bootable", "size=497664")
+	pno        int      //partition number
+	sectorSize int64    // bytes per sector of start and size
 }
 
 func (sl sfdiskLine) String() string {
@@ -305,26 +1014,26 @@ func (sl sfdiskLine) Attr(key string) string {
 	return ""
 }
 
-func (sl sfdiskLine) SetSize(size int64) {
+func (sl sfdiskLine) SetSize(size int64) error {
 	for i, attr := range sl.attr {
 		if strings.HasPrefix(attr, "size=") {
 			sl.attr[i] = fmt.Sprintf("size=%d", size)
-			return
+			return nil
 		}
 	}
-	panic("didn't find size attribute")
+	return fmt.Errorf("partition %s has no size attribute", sl.dev)
 }
 
-func (sl sfdiskLine) AttrInt64(key string) int64 {
+func (sl sfdiskLine) AttrInt64(key string) (int64, error) {
 	v := sl.Attr(key)
 	if v == "" {
-		log.Fatalf("device %q has no attribute %q", sl.dev, key)
+		return 0, fmt.Errorf("device %q has no attribute %q", sl.dev, key)
 	}
 	n, err := strconv.ParseInt(v, 10, 64)
 	if err != nil {
-		log.Fatalf("device %q attribute %q is non-integer: %q", sl.dev, key, v)
+		return 0, fmt.Errorf("device %q attribute %q is non-integer: %q", sl.dev, key, v)
 	}
-	return n
+	return n, nil
 }
 
 func (sl sfdiskLine) Type() string {
@@ -337,17 +1046,35 @@ func (sl sfdiskLine) Type() string {
 	return sl.Attr("Id")
 }
 
-func (sl sfdiskLine) Start() int64 { return sl.AttrInt64("start") }
-func (sl sfdiskLine) Size() int64  { return sl.AttrInt64("size") }
+// Start and Size return the partition's start and size attributes,
+// which parsePartitionTable checks are there.
+func (sl sfdiskLine) Start() int64 { n, _ := sl.AttrInt64("start"); return n }
+func (sl sfdiskLine) Size() int64  { n, _ := sl.AttrInt64("size"); return n }
 
-func getPartitionTable(dev string) *partitionTable {
-	pt := new(partitionTable)
-	out, err := exec.Command("/sbin/sfdisk", "-d", dev).Output()
+// readPartitionTable returns the partition table of the disk dev.
+func readPartitionTable(dev string) (*partitionTable, error) {
+	cmd := command("/sbin/sfdisk", "-d", dev)
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("running sfdisk -d %s: %v, %s", dev, err, capOutput(cmd.Args, out))
+	}
+	pt, err := parsePartitionTable(out)
 	if err != nil {
-		log.Fatalf("running sfdisk -f %s: %v, %s", dev, err, out)
+		return nil, err
 	}
+	// The table is written back to dev, so it had better describe it.
+	if d := pt.Meta("device"); d != "" && d != dev {
+		return nil, fmt.Errorf("sfdisk -d %s describes device %s", dev, d)
+	}
+	return pt, nil
+}
+
+// parsePartitionTable parses the output of sfdisk -d.
+func parsePartitionTable(out []byte) (*partitionTable, error) {
+	pt := new(partitionTable)
 	lines := strings.Split(string(out), "\n")
 	var pno int
+	var sectorSize int64
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if len(line) == 0 {
@@ -358,28 +1085,106 @@ func getPartitionTable(dev string) *partitionTable {
 		}
 		if pt.parts == nil {
 			pt.meta = append(pt.meta, line)
-		} else {
-			f := strings.SplitN(string(line), ":", 2)
-			if len(f) < 2 {
-				log.Fatalf("unsupported sfdisk line %q", line)
+			continue
+		}
+		if sectorSize == 0 {
+			var err error
+			if sectorSize, err = pt.SectorSize(); err != nil {
+				return nil, err
 			}
-			dev := strings.TrimSpace(f[0])
-			rest := strings.TrimSpace(f[1])
-			pno++
-			part := sfdiskLine{dev: dev, pno: pno}
-			for _, attr := range strings.Split(rest, ",") {
-				attr = strings.TrimSpace(attr)
-				attr = eqRx.ReplaceAllString(attr, "=")
-				part.attr = append(part.attr, attr)
+		}
+		f := strings.SplitN(string(line), ":", 2)
+		if len(f) < 2 {
+			return nil, fmt.Errorf("unsupported sfdisk line %q", line)
+		}
+		dev := strings.TrimSpace(f[0])
+		rest := strings.TrimSpace(f[1])
+		pno++
+		part := sfdiskLine{dev: dev, pno: partNum(dev, pno), sectorSize: sectorSize}
+		for _, attr := range splitAttrs(rest) {
+			attr = strings.TrimSpace(attr)
+			if loc := eqRx.FindStringIndex(attr); loc != nil {
+				attr = attr[:loc[0]] + "=" + attr[loc[1]:] // not within a name's value
+			}
+			part.attr = append(part.attr, attr)
+		}
+		for _, key := range []string{"start", "size"} {
+			if _, err := part.AttrInt64(key); err != nil {
+				return nil, err
 			}
-			pt.parts = append(pt.parts, part)
+		}
+		pt.parts = append(pt.parts, part)
+	}
+	pt.uuids = pt.partUUIDs()
+	pt.label = pt.Meta("label-id")
+	return pt, nil
+}
+
+// partUUIDs returns the uuid= attribute of each of pt's partitions
+// that has one, by device.
+func (pt *partitionTable) partUUIDs() map[string]string {
+	m := map[string]string{}
+	for _, p := range pt.parts {
+		if u := p.Attr("uuid"); u != "" {
+			m[p.dev] = u
 		}
 	}
-	return pt
+	return m
+}
+
+// checkIDsKept returns an error if writing table, a new version of pt,
+// would change the disk's label-id or the UUID of any partition from
+// when pt was read. Those are what PARTUUIDs, which fstab and
+// bootloaders refer to, come from.
+func (pt *partitionTable) checkIDsKept(table []byte) error {
+	npt, err := parsePartitionTable(table)
+	if err != nil {
+		return err
+	}
+	if pt.label != "" && npt.label != pt.label {
+		return fmt.Errorf("refusing to write new partition table: it would change the disk's label-id from %s to %q", pt.label, npt.label)
+	}
+	for dev, was := range pt.uuids {
+		if is := npt.uuids[dev]; is != was {
+			return fmt.Errorf("refusing to write new partition table: it would change the UUID of %s from %s to %q, breaking references to its PARTUUID", dev, was, is)
+		}
+	}
+	return nil
+}
+
+// splitAttrs splits the attributes of an "sfdisk -d" partition line
+// at commas, except within quotes, as in name="a, b".
+func splitAttrs(s string) []string {
+	var attrs []string
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			attrs = append(attrs, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(attrs, s[start:])
 }
 
 var eqRx = regexp.MustCompile(`\s*=\s*`)
 
+var partNumRx = regexp.MustCompile(`\d+$`)
+
+// partNum returns the partition number of the partition device dev:
+// 5 for "/dev/sda5" or 3 for "/dev/nvme0n1p3". That's not its row in
+// sfdisk's output when there are gaps or logical partitions, so row
+// is only used for a device name without a trailing number.
+func partNum(dev string, row int) int {
+	if n, err := strconv.Atoi(partNumRx.FindString(dev)); err == nil {
+		return n
+	}
+	return row
+}
+
 func readInt64File(f string) (int64, error) {
 	x, err := ioutil.ReadFile(f)
 	if err != nil {
@@ -425,4 +1230,7 @@ unit: sectors
 /dev/sda2 : start=      501758, size=   209211394, type=5
 /dev/sda5 : start=      501760, size=   209211392, type=83
 
+where an LVM install has type=8e (Linux LVM) for its PV's partition
+instead of 83.
+
 */
@@ -0,0 +1,1376 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestGetRawResizer(t *testing.T) {
+	for _, dev := range []string{"/dev/vdz9", "/dev/nvme9n1p3", "/dev/mmcblk9p2"} {
+		e, err := getRawResizer(dev)
+		if err != nil {
+			t.Errorf("getRawResizer(%q): %v", dev, err)
+			continue
+		}
+		if e != partitionResizer(dev) {
+			t.Errorf("getRawResizer(%q) = %#v; want partitionResizer", dev, e)
+		}
+	}
+	for _, dev := range []string{"/dev/vdz", "/dev/nvme9n1", "/dev/mapper/vg-lv", "/data"} {
+		if e, err := getRawResizer(dev); err == nil {
+			t.Errorf("getRawResizer(%q) = %#v; want error", dev, e)
+		}
+	}
+}
+
+// TestByIDRawDevice checks that a /dev/disk/by-id path given to --raw
+// is resolved to the kernel's name before anything uses it.
+func TestByIDRawDevice(t *testing.T) {
+	td, err := ioutil.TempDir("", "embiggen-by-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	// udev's links are relative, and may point at a node that's
+	// briefly missing.
+	if err := os.Mkdir(filepath.Join(td, "by-id"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	rel := filepath.Join(td, "by-id", "wwn-0x5000c500a1b2c3d4-part3")
+	if err := os.Symlink("../vdz3", rel); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := canonicalDev(rel), filepath.Join(td, "vdz3"); got != want {
+		t.Errorf("canonicalDev(%s) = %q; want %q", rel, got, want)
+	}
+
+	link := filepath.Join(td, "by-id", "wwn-0x5000c500a1b2c3d4-part3-abs")
+	if err := os.Symlink("/dev/vdz3", link); err != nil {
+		t.Fatal(err)
+	}
+	e, err := getRawResizer(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e != partitionResizer("/dev/vdz3") {
+		t.Fatalf("getRawResizer(%s) = %#v; want partition /dev/vdz3", link, e)
+	}
+
+	defer func(v bool) { *dry = v }(*dry)
+	*dry = true
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdz", "41943040")
+	sys.file("block/vdz/queue/logical_block_size", "512\n")
+	sys.part("vdz", "vdz3", "3", "20969472")
+	device := "/dev/vdz"
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[1] == "-d" {
+			return []byte("label: dos\ndevice: " + device + "\nunit: sectors\n\n" + device + "3 : start=2048, size=20969472, type=83\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	if err := e.Resize(); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range *ran {
+		if args[0] == "/sbin/sfdisk" && args[1] != "--version" && args[len(args)-1] != "/dev/vdz" {
+			t.Errorf("ran %q; want it to use /dev/vdz", args)
+		}
+	}
+
+	// A table dumped for some other name for the disk isn't used.
+	device = "/dev/disk/by-id/wwn-0x5000c500a1b2c3d4"
+	if _, err := readPartitionTable("/dev/vdz"); err == nil || !strings.Contains(err.Error(), "describes device "+device) {
+		t.Errorf("readPartitionTable with mismatched device line = %v; want error", err)
+	}
+}
+
+const mbrSample = `label: dos
+label-id: 0xeba7536a
+device: /dev/sda
+unit: sectors
+
+/dev/sda1 : start=        2048, size=      497664, type=83, bootable
+/dev/sda2 : start=      501758, size=   209211394, type=5
+/dev/sda5 : start=      501760, size=   209211392, type=83
+`
+
+func TestPartitionNumbers(t *testing.T) {
+	pt, err := parsePartitionTable([]byte(mbrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, p := range pt.parts {
+		got = append(got, p.pno)
+	}
+	if want := []int{1, 2, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("partition numbers = %v; want %v", got, want)
+	}
+	if n := partNum("/dev/nvme0n1p3", 1); n != 3 {
+		t.Errorf("partNum(nvme0n1p3) = %d; want 3", n)
+	}
+}
+
+func TestGrowPartitionLastLBA(t *testing.T) {
+	const gpt = `label: gpt
+label-id: 5B3A1E62-6C4F-4B8C-9A15-2D7C0A4F1E33
+device: /dev/sda
+unit: sectors
+first-lba: 2048
+last-lba: 20971486
+
+/dev/sda1 : start=2048, size=20969439, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+`
+	pt, err := parsePartitionTable([]byte(gpt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ := pt.lastPartition()
+	if err := pt.growPartition(part, 41940959, true); err != nil {
+		t.Fatal(err)
+	}
+	if v := pt.Meta("last-lba"); v != "" {
+		t.Errorf("GPT last-lba = %q after growing; want it removed", v)
+	}
+	if v := pt.Meta("first-lba"); v != "2048" {
+		t.Errorf("GPT first-lba = %q; want it kept", v)
+	}
+	if got := pt.parts[0].Size(); got != 41940959 {
+		t.Errorf("size = %d; want 41940959", got)
+	}
+
+	pt, err = parsePartitionTable([]byte(mbrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := append([]string(nil), pt.meta...)
+	part, _ = pt.lastPartition()
+	if err := pt.growPartition(part, part.Size()+2048, false); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pt.meta, before) {
+		t.Errorf("MBR header = %q after growing; want unchanged %q", pt.meta, before)
+	}
+}
+
+func TestMBRBootableSurvivesResize(t *testing.T) {
+	pt, err := parsePartitionTable([]byte(mbrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, ok := pt.lastPartition()
+	if !ok || part.dev != "/dev/sda5" {
+		t.Fatalf("lastPartition = %v, %v; want /dev/sda5", part.dev, ok)
+	}
+	if err := part.SetSize(part.Size() + 2048); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := pt.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	const want = `label: dos
+label-id: 0xeba7536a
+device: /dev/sda
+unit: sectors
+
+/dev/sda1 : start=2048, size=497664, type=83, bootable
+/dev/sda2 : start=501758, size=209211394, type=5
+/dev/sda5 : start=501760, size=209213440, type=83
+`
+	if got := buf.String(); got != want {
+		t.Errorf("written table mismatch\n got: %s\nwant: %s", got, want)
+	}
+	if got := pt.parts[0].Attr("bootable"); got != "bootable" {
+		t.Errorf("sda1 bootable attr = %q; want bootable", got)
+	}
+}
+
+func TestGrowSectors(t *testing.T) {
+	tests := []struct {
+		name   string
+		remain int64
+		isGPT  bool
+		force  bool
+		want   int64
+	}{
+		{name: "plenty", remain: 4096 + 2048, want: 4096},
+		{name: "just_reserve", remain: 2048, want: 0},
+		{name: "slightly_more", remain: 2100, want: 52},
+		{name: "under_reserve", remain: 1000, want: -1048},
+		{name: "under_reserve_force_mbr", remain: 1000, force: true, want: 1000},
+		{name: "under_reserve_force_gpt", remain: 1000, isGPT: true, force: true, want: 1000 - 33},
+		{name: "gpt_backup_only_force", remain: 33, isGPT: true, force: true, want: 0},
+		{name: "plenty_force_keeps_reserve", remain: 4096 + 2048, isGPT: true, force: true, want: 4096},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := growSectors(tt.remain, 512, tt.isGPT, tt.force); got != tt.want {
+				t.Errorf("growSectors(%d) = %d; want %d", tt.remain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastPartitionOutOfOrder(t *testing.T) {
+	// Partition 2 was created after partition 3, at the end of the disk.
+	const dump = `label: gpt
+label-id: 841DBE6B-6A8D-43E1-93E1-D765373DDE3B
+device: /dev/sda
+unit: sectors
+first-lba: 34
+last-lba: 10485726
+
+/dev/sda1 : start=        2048, size=      192512, type=21686148-6449-6E6F-744E-656564454649
+/dev/sda2 : start=     4194304, size=     4194304, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+/dev/sda3 : start=      194560, size=     3999744, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+`
+	pt, err := parsePartitionTable([]byte(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, ok := pt.lastPartition()
+	if !ok || part.dev != "/dev/sda2" {
+		t.Errorf("lastPartition = %v, %v; want /dev/sda2", part.dev, ok)
+	}
+	if part.pno != 2 {
+		t.Errorf("pno = %d; want 2", part.pno)
+	}
+}
+
+func TestLastPartitionSkipsEmptySlots(t *testing.T) {
+	const dump = `label: dos
+label-id: 0x8a3e1f2b
+device: /dev/sda
+unit: sectors
+
+/dev/sda1 : start=        2048, size=     8386560, type=83
+/dev/sda2 : start=     8388608, size=           0, type=83
+ : start=     8390656, size=        2048, type=83
+`
+	pt, err := parsePartitionTable([]byte(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, ok := pt.lastPartition()
+	if !ok || part.dev != "/dev/sda1" {
+		t.Errorf("lastPartition = %v, %v; want /dev/sda1", part.dev, ok)
+	}
+	_, tail := pt.freeRegions(10485760)
+	if tail.start != 8388608 {
+		t.Errorf("free tail starts at %d; want 8388608, after sda1", tail.start)
+	}
+}
+
+// fakeCmds replaces the functions that run external commands with
+// ones that record the commands and return canned output, until the
+// returned restore func is called.
+func fakeCmds(out func(args []string) ([]byte, error)) (ran *[][]string, restore func()) {
+	ran = new([][]string)
+	fake := func(c *exec.Cmd) ([]byte, error) {
+		*ran = append(*ran, c.Args)
+		return out(c.Args)
+	}
+	oldOutput, oldCombined, oldRun := cmdOutput, cmdCombinedOutput, cmdRun
+	cmdOutput, cmdCombinedOutput = fake, fake
+	cmdRun = func(c *exec.Cmd) error {
+		_, err := fake(c)
+		return err
+	}
+	return ran, func() {
+		cmdOutput, cmdCombinedOutput, cmdRun = oldOutput, oldCombined, oldRun
+	}
+}
+
+func TestTellKernelFallsBackToPartx(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	defer func() { warnings = nil }()
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return nil, nil })
+	defer restore()
+
+	part := sfdiskLine{dev: "/dev/sda3", pno: 3}
+
+	blkpgResizePartition = func(string, sfdiskLine) error { return nil }
+	if err := tellKernel("/dev/sda", part); err != nil {
+		t.Fatal(err)
+	}
+	if len(*ran) != 0 {
+		t.Fatalf("ran %q after successful BLKPG; want nothing", *ran)
+	}
+
+	blkpgResizePartition = func(string, sfdiskLine) error { return syscall.EBUSY }
+	if err := tellKernel("/dev/sda", part); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"partx", "-u", "--nr", "3", "/dev/sda"}}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %q; want %q", *ran, want)
+	}
+}
+
+// TestValidateBeforeWrite checks that sfdisk --no-act checks the new
+// table before the real write, and that a rejected table isn't written.
+func TestValidateBeforeWrite(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	_, cleanup := newFakeSysfs(t) // no /dev/sda, so not known to be idle
+	defer cleanup()
+	blkpgResizePartition = func(string, sfdiskLine) error { return nil }
+	reject := false
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if reject && args[1] == "--no-act" {
+			return []byte("sfdisk: bad script"), errors.New("exit status 1")
+		}
+		if args[1] == "-d" {
+			return []byte(mbrSample), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	pt, err := parsePartitionTable([]byte(mbrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ := pt.lastPartition()
+	if err := writePartitionTable("/dev/sda", pt, part); err != nil {
+		t.Fatal(err)
+	}
+	var sfdisks [][]string
+	for _, args := range *ran {
+		if args[0] == "/sbin/sfdisk" {
+			sfdisks = append(sfdisks, args)
+		}
+	}
+	want := [][]string{
+		{"/sbin/sfdisk", "--no-act", "-f", "--no-reread", "--no-tell-kernel", "/dev/sda"},
+		{"/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", "/dev/sda"},
+		{"/sbin/sfdisk", "-d", "/dev/sda"}, // checking the label-id was kept
+	}
+	if !reflect.DeepEqual(sfdisks, want) {
+		t.Errorf("ran %q; want %q", sfdisks, want)
+	}
+
+	reject = true
+	*ran = nil
+	if err := writePartitionTable("/dev/sda", pt, part); err == nil || !strings.Contains(err.Error(), "bad script") {
+		t.Fatalf("writePartitionTable with rejected table = %v; want sfdisk's error", err)
+	}
+	if len(*ran) != 1 {
+		t.Errorf("ran %q after validation failed; want only the validation", *ran)
+	}
+}
+
+// TestIdleDiskReread checks that an idle disk's partition table is
+// reread by sfdisk, without BLKPG or partx, and a busy one's isn't.
+func TestIdleDiskReread(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	defer func() { warnings = nil }()
+	blkpgCalled := false
+	blkpgResizePartition = func(string, sfdiskLine) error {
+		blkpgCalled = true
+		return syscall.EBUSY
+	}
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return nil, nil })
+	defer restore()
+
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdb", "41943040")
+	sys.part("vdb", "vdb1", "1", "20969472")
+	sys.file("block/vdb/vdb1/start", "2048\n")
+	proc, err := ioutil.TempDir("", "embiggen-proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(proc)
+	if err := os.MkdirAll(filepath.Join(proc, "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(proc, "self", "mountinfo"), []byte("22 1 8:1 / / rw - ext4 /dev/sda1 rw\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { procDir = p }(procDir)
+	procDir = proc
+
+	table := "label: dos\ndevice: /dev/vdb\nunit: sectors\n\n/dev/vdb1 : start=2048, size=20969472, type=83\n"
+	write := func() {
+		t.Helper()
+		pt, err := parsePartitionTable([]byte(table))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part, _ := pt.lastPartition()
+		if err := writePartitionTable("/dev/vdb", pt, part); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write()
+	if blkpgCalled {
+		t.Error("BLKPG used for an idle disk")
+	}
+	for _, args := range *ran {
+		if args[0] == "partx" {
+			t.Errorf("ran %q for an idle disk", args)
+		}
+	}
+	if last := (*ran)[len(*ran)-1]; !reflect.DeepEqual(last, []string{"/sbin/sfdisk", "-f", "/dev/vdb"}) {
+		t.Errorf("wrote table with %q; want sfdisk to reread it", last)
+	}
+
+	// An LVM PV on the partition makes the disk busy.
+	sys.file("block/vdb/vdb1/holders/dm-0", "")
+	*ran = nil
+	write()
+	if !blkpgCalled {
+		t.Error("BLKPG not used for a busy disk")
+	}
+	if !reflect.DeepEqual((*ran)[len(*ran)-1], []string{"partx", "-u", "--nr", "1", "/dev/vdb"}) {
+		t.Errorf("ran %q; want partx after BLKPG failed", *ran)
+	}
+}
+
+// TestTellKernelLogicalPartition checks that partx is told the real
+// number of a logical partition, not its row in the table.
+func TestTellKernelLogicalPartition(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	defer func() { warnings = nil }()
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return nil, nil })
+	defer restore()
+
+	pt, err := parsePartitionTable([]byte(mbrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, ok := pt.lastPartition()
+	if !ok {
+		t.Fatal("no last partition")
+	}
+	blkpgResizePartition = func(string, sfdiskLine) error { return syscall.EBUSY }
+	if err := tellKernel("/dev/sda", part); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"partx", "-u", "--nr", "5", "/dev/sda"}}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %q; want %q", *ran, want)
+	}
+}
+
+func TestSectorSizeMeta(t *testing.T) {
+	const dump = `label: gpt
+label-id: 5A4F3C2B-1D0E-4F6A-8B7C-9D8E7F6A5B4C
+device: /dev/sdc
+unit: sectors
+first-lba: 6
+last-lba: 1048570
+sector-size: 4096
+
+/dev/sdc1 : start=256, size=523776, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+`
+	pt, err := parsePartitionTable([]byte(dump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := pt.SectorSize()
+	if err != nil || ss != 4096 {
+		t.Fatalf("SectorSize = %d, %v; want 4096", ss, err)
+	}
+	part, _ := pt.lastPartition()
+	if part.sectorSize != 4096 {
+		t.Errorf("partition sectorSize = %d; want 4096", part.sectorSize)
+	}
+
+	// A 4 GiB disk: 8388608 512-byte units in sysfs, 1048576 4K sectors.
+	size := diskSectors(8388608, ss)
+	if size != 1048576 {
+		t.Fatalf("diskSectors = %d; want 1048576", size)
+	}
+	remain := size - (part.Start() + part.Size())
+	// 1 MiB is 256 4K sectors, not 2048.
+	if got, want := growSectors(remain, ss, true, false), remain-256; got != want {
+		t.Errorf("growSectors = %d; want %d", got, want)
+	}
+
+	pt, err = parsePartitionTable([]byte(mbrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ss, err := pt.SectorSize(); err != nil || ss != 512 {
+		t.Errorf("SectorSize without sector-size line = %d, %v; want 512", ss, err)
+	}
+}
+
+func TestCheckGrowableType(t *testing.T) {
+	tests := []struct {
+		typ   string
+		isGPT bool
+		ok    bool
+	}{
+		{typ: "B921B045-1DF0-41C3-AF44-4C6F280D3FAE", isGPT: true, ok: true}, // aarch64 root
+		{typ: "b921b045-1df0-41c3-af44-4c6f280d3fae", isGPT: true, ok: true},
+		{typ: "69DAD710-2CE4-4E3C-B16C-21A1D49ABED3", isGPT: true, ok: true}, // armv7 root
+		{typ: "72EC70A6-CF74-40E6-BD49-4BDA08E8F224", isGPT: true, ok: true}, // riscv64 root
+		{typ: rootx8664GPTTypeID, isGPT: true, ok: true},
+		{typ: "C12A7328-F81F-11D2-BA4B-00A0C93EC93B", isGPT: true, ok: false}, // ESP
+		{typ: "83", ok: true},
+		{typ: "8e", ok: true},
+		{typ: "8E", ok: true},
+		{typ: "fd", ok: true},
+		{typ: "7", ok: false},
+	}
+	for _, tt := range tests {
+		part := sfdiskLine{dev: "/dev/vda2", attr: []string{"start=2048", "size=1000", "type=" + tt.typ}}
+		err := checkGrowableType(part, tt.isGPT)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkGrowableType(%s) = %v; want ok=%v", tt.typ, err, tt.ok)
+		}
+	}
+}
+
+// TestMBRLVM checks that an LVM PV on an MBR logical partition of
+// type 8e is grown: the LV's chain goes through the PV to the
+// partition, whose type is accepted.
+func TestMBRLVM(t *testing.T) {
+	pt, err := parsePartitionTable([]byte(strings.Replace(mbrSample, "size=   209211392, type=83", "size=   209211392, type=8e", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, ok := pt.lastPartition()
+	if !ok || part.dev != "/dev/sda5" || part.Type() != "8e" {
+		t.Fatalf("lastPartition = %+v, %v; want /dev/sda5 of type 8e", part, ok)
+	}
+	if err := checkGrowableType(part, false); err != nil {
+		t.Errorf("checkGrowableType(8e): %v", err)
+	}
+
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		switch args[0] {
+		case "lvs":
+			if containsString(args, "lv_path,vg_name,lv_size") {
+				return []byte("  /dev/vg/root:vg:209190912\n"), nil
+			}
+		case "pvdisplay":
+			return []byte("  /dev/sda5:vg:209207296:-1:8:8:-1:4096:25538:0:25538:AAAA\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	var chain []Resizer
+	for r := Resizer(lvResizer("/dev/mapper/vg-root")); r != nil; {
+		chain = append(chain, r)
+		if r, err = r.DepResizer(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []Resizer{lvResizer("/dev/mapper/vg-root"), pvResizer("/dev/sda5"), partitionResizer("/dev/sda5")}
+	if !reflect.DeepEqual(chain, want) {
+		t.Errorf("chain = %v; want %v", chain, want)
+	}
+}
+
+func TestAlignmentNote(t *testing.T) {
+	aligned := sfdiskLine{dev: "/dev/sda1", attr: []string{"start=2048", "size=1000"}}
+	if note := alignmentNote(aligned, 512, 1<<20); note != "" {
+		t.Errorf("start 2048: got note %q; want none", note)
+	}
+	dos := sfdiskLine{dev: "/dev/sda1", attr: []string{"start=63", "size=1000"}}
+	note := alignmentNote(dos, 512, 1<<20)
+	if !strings.Contains(note, "starts at sector 63, 32256 bytes past a 1048576 byte boundary") {
+		t.Errorf("start 63: got note %q", note)
+	}
+	if note := alignmentNote(dos, 512, 512); note != "" {
+		t.Errorf("start 63 with 512 byte optimal I/O: got note %q; want none", note)
+	}
+}
+
+func TestAlignedExtend(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.file("block/sda/queue/optimal_io_size", "4194304\n")
+	opt := reportedOptimalIOSize("/dev/sda")
+	if opt != 4<<20 {
+		t.Fatalf("reportedOptimalIOSize = %d; want 4 MiB", opt)
+	}
+	if n := reportedOptimalIOSize("/dev/sdb"); n != 0 {
+		t.Errorf("reportedOptimalIOSize of a disk without one = %d; want 0", n)
+	}
+
+	tests := []struct {
+		end, extend, sectorSize, align int64
+		want                           int64
+	}{
+		{end: 20971520, extend: 10000, sectorSize: 512, align: opt, want: 8192},        // one 4 MiB unit; 1808 sectors left over
+		{end: 20971520, extend: 5000, sectorSize: 512, align: opt, want: 0},            // not enough to reach the next boundary
+		{end: 20971000, extend: 10000, sectorSize: 512, align: opt, want: 8712},        // an unaligned end gets aligned
+		{end: 2621440, extend: 3000, sectorSize: 4096, align: 1 << 20, want: 2816},     // 4K sectors: 256 per MiB
+		{end: 20971520, extend: 10000, sectorSize: 512, align: 512, want: 10000},       // nothing to align to
+		{end: 20971520, extend: 10000, sectorSize: 4096, align: 33553920, want: 10000}, // not a whole number of sectors
+	}
+	for _, tt := range tests {
+		if got := alignedExtend(tt.end, tt.extend, tt.sectorSize, tt.align); got != tt.want {
+			t.Errorf("alignedExtend(%d, %d, %d, %d) = %d; want %d", tt.end, tt.extend, tt.sectorSize, tt.align, got, tt.want)
+		}
+	}
+}
+
+func TestLastPartitionWithESP(t *testing.T) {
+	// The ESP is listed last but is at the start of the disk, so the
+	// root partition is the one to grow.
+	pt, err := parsePartitionTable([]byte(`label: gpt
+device: /dev/vda
+unit: sectors
+
+/dev/vda2 : start=1050624, size=20969472, type=4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709
+/dev/vda1 : start=2048, size=1048576, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, ok := pt.lastPartition()
+	if !ok || part.dev != "/dev/vda2" {
+		t.Fatalf("lastPartition = %v, %v; want /dev/vda2", part.dev, ok)
+	}
+	if err := checkGrowableType(part, true); err != nil {
+		t.Errorf("root partition: %v", err)
+	}
+
+	if _, ok := pt.partitionAfter(part); ok {
+		t.Errorf("partitionAfter(vda2) found one; want none")
+	}
+
+	// An ESP at the end of the disk is never the one chosen to grow,
+	// but the data partition can't grow past it.
+	pt, err = parsePartitionTable([]byte(`label: gpt
+device: /dev/vda
+unit: sectors
+
+/dev/vda1 : start=2048, size=20969472, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+/dev/vda2 : start=20971520, size=1048576, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, ok = pt.lastPartition()
+	if !ok || part.dev != "/dev/vda1" {
+		t.Fatalf("lastPartition = %v, %v; want /dev/vda1, not the ESP", part.dev, ok)
+	}
+	if after, ok := pt.partitionAfter(part); !ok || after.dev != "/dev/vda2" {
+		t.Errorf("partitionAfter(vda1) = %v, %v; want the ESP /dev/vda2", after.dev, ok)
+	}
+
+	// A disk with only an ESP is refused by name.
+	pt, err = parsePartitionTable([]byte(`label: gpt
+device: /dev/vda
+unit: sectors
+
+/dev/vda1 : start=2048, size=1048576, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ = pt.lastPartition()
+	if err := checkGrowableType(part, true); err == nil || !strings.Contains(err.Error(), "EFI system partition") {
+		t.Errorf("ESP only: %v; want refusal naming the ESP", err)
+	}
+}
+
+func TestPercentExtend(t *testing.T) {
+	const gib = 1 << 30
+	tests := []struct {
+		name                string
+		diskBytes, endBytes int64
+		sectorSize          int64
+		pct                 int
+		wantExtendBytes     int64
+	}{
+		{name: "half_of_100G", diskBytes: 100 * gib, endBytes: 10 * gib, sectorSize: 512, pct: 50, wantExtendBytes: 40 * gib},
+		{name: "90_of_100G", diskBytes: 100 * gib, endBytes: 10 * gib, sectorSize: 512, pct: 90, wantExtendBytes: 80 * gib},
+		{name: "90_of_10T_4k", diskBytes: 10 << 40, endBytes: gib, sectorSize: 4096, pct: 90, wantExtendBytes: 9216*gib - gib},
+		{name: "rounds_down_to_MiB", diskBytes: 1001 << 20, endBytes: 1 << 20, sectorSize: 512, pct: 33, wantExtendBytes: 329 << 20},
+		{name: "already_past", diskBytes: 100 * gib, endBytes: 60 * gib, sectorSize: 512, pct: 50, wantExtendBytes: -10 * gib},
+		{name: "huge_disk", diskBytes: 1 << 62, endBytes: gib, sectorSize: 512, pct: 100, wantExtendBytes: 1<<62 - gib},
+	}
+	for _, tt := range tests {
+		got := percentExtend(tt.diskBytes/tt.sectorSize, tt.endBytes/tt.sectorSize, tt.sectorSize, tt.pct) * tt.sectorSize
+		if got != tt.wantExtendBytes {
+			t.Errorf("%s: extend by %d bytes; want %d", tt.name, got, tt.wantExtendBytes)
+		}
+	}
+}
+
+func TestMetaLabelVariants(t *testing.T) {
+	for _, header := range []string{"label: gpt", "Label: gpt", "LABEL:gpt", "Disklabel type: gpt"} {
+		pt := &partitionTable{meta: []string{header, "device: /dev/sda"}}
+		if got := pt.Meta("label"); got != "gpt" {
+			t.Errorf("Meta(label) with header %q = %q; want gpt", header, got)
+		}
+	}
+	pt := &partitionTable{meta: []string{"label-id: 0x1234", "device: /dev/sda"}}
+	if got := pt.Meta("label"); got != "" {
+		t.Errorf("Meta(label) with only label-id = %q; want empty", got)
+	}
+	if got := pt.Meta("label-id"); got != "0x1234" {
+		t.Errorf("Meta(label-id) = %q; want 0x1234", got)
+	}
+}
+
+// TestUnknownMetaRoundTrip checks that header lines we don't know,
+// such as grain:, survive growing a partition untouched, while the
+// one growPartition drops goes however it's spelled.
+func TestUnknownMetaRoundTrip(t *testing.T) {
+	const header = `label: gpt
+label-id: 3E8C1F2A-6B1D-4C5E-9F0A-2B7D8E4C1A60
+device: /dev/sda
+unit: sectors
+first-lba: 2048
+Last-LBA:  20971486
+grain: 1M
+sector-size: 512
+x-future-key:   some  value
+`
+	pt, err := parsePartitionTable([]byte(header + "\n/dev/sda1 : start=2048, size=1048576, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pt.Meta("grain"); got != "1M" {
+		t.Errorf("Meta(grain) = %q; want 1M", got)
+	}
+	part, _ := pt.lastPartition()
+	if err := pt.growPartition(part, part.Size()+2048, true); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	pt.Write(&buf)
+	want := strings.Replace(header, "Last-LBA:  20971486\n", "", 1) + "\n/dev/sda1 : start=2048, size=1050624, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4\n"
+	if buf.String() != want {
+		t.Errorf("written table:\n%s\nwant:\n%s", buf.Bytes(), want)
+	}
+
+	pt.RemoveMeta("X-Future-Key")
+	if got := pt.Meta("x-future-key"); got != "" {
+		t.Errorf("after RemoveMeta, Meta(x-future-key) = %q; want empty", got)
+	}
+	if got := pt.Meta("grain"); got != "1M" {
+		t.Errorf("RemoveMeta of another key dropped grain; Meta(grain) = %q", got)
+	}
+}
+
+func TestCheckedSectorSizeWithoutMeta(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sdb", "41943040")
+	sys.file("block/sdb/queue/logical_block_size", "4096\n")
+
+	// Old sfdisk on a 4Kn disk: no sector-size line.
+	pt, err := parsePartitionTable([]byte("label: gpt\ndevice: /dev/sdb\n\n/dev/sdb1 : start=256, size=5242624\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := pt.checkedSectorSize("/dev/sdb")
+	if err != nil || n != 4096 {
+		t.Fatalf("checkedSectorSize = %v, %v; want 4096", n, err)
+	}
+	if ss := pt.parts[0].sectorSize; ss != 4096 {
+		t.Errorf("partition sectorSize = %d; want 4096", ss)
+	}
+
+	pt, err = parsePartitionTable([]byte("label: gpt\nsector-size: 512\n\n/dev/sdb1 : start=2048, size=1000\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pt.checkedSectorSize("/dev/sdb"); err == nil {
+		t.Error("checkedSectorSize succeeded despite sfdisk and the kernel disagreeing")
+	}
+}
+
+func TestLogicalSectorSize(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "1000")
+	sys.file("block/sda/queue/logical_block_size", "512\n")
+	sys.disk("nvme0n1", "1000")
+	sys.file("block/nvme0n1/queue/logical_block_size", "4096\n")
+	sys.disk("sdz", "1000")
+	sys.file("block/sdz/queue/logical_block_size", "1000\n")
+	sys.disk("vda", "1000") // no queue directory
+
+	for dev, want := range map[string]int64{"/dev/sda": 512, "/dev/nvme0n1": 4096, "/dev/vda": 0} {
+		if got, err := logicalSectorSize(dev); err != nil || got != want {
+			t.Errorf("logicalSectorSize(%s) = %d, %v; want %d", dev, got, err, want)
+		}
+	}
+	if _, err := logicalSectorSize("/dev/sdz"); err == nil {
+		t.Error("logicalSectorSize of 1000-byte sectors: want error")
+	}
+}
+
+const gptUUIDSample = `label: gpt
+label-id: 3E8C1F2A-6B1D-4C5E-9F0A-2B7D8E4C1A60
+device: /dev/sda
+unit: sectors
+first-lba: 2048
+last-lba: 20971486
+sector-size: 512
+
+/dev/sda1 : start=2048, size=1048576, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B, uuid=0D3AF4B2-9E71-4C8A-B5D6-1F2E3A4B5C6D, name="EFI System"
+/dev/sda2 : start=1050624, size=19918848, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, uuid=7A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D
+`
+
+// TestPartUUIDsKept checks that growing a partition writes every
+// partition's uuid= back byte for byte, and that a table that would
+// change one is refused before anything is written.
+func TestPartUUIDsKept(t *testing.T) {
+	pt, err := parsePartitionTable([]byte(gptUUIDSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := pt.partUUIDs()
+	if len(before) != 2 {
+		t.Fatalf("parsed UUIDs %v; want 2", before)
+	}
+	part, _ := pt.lastPartition()
+	if err := pt.growPartition(part, part.Size()+20971520, true); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	pt.Write(&buf)
+	for dev, u := range before {
+		if !strings.Contains(buf.String(), dev+" : ") || !strings.Contains(buf.String(), "uuid="+u) {
+			t.Errorf("new table lost uuid=%s of %s:\n%s", u, dev, buf.Bytes())
+		}
+	}
+	if err := pt.checkIDsKept(buf.Bytes()); err != nil {
+		t.Errorf("checkIDsKept after growing: %v", err)
+	}
+
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return nil, nil })
+	defer restore()
+	for i, attr := range part.attr {
+		if strings.HasPrefix(attr, "uuid=") {
+			part.attr[i] = "uuid=00000000-0000-0000-0000-000000000000"
+		}
+	}
+	err = writePartitionTable("/dev/sda", pt, part)
+	if err == nil || !strings.Contains(err.Error(), "change the UUID of /dev/sda2 from 7A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D") {
+		t.Errorf("writePartitionTable with a changed UUID = %v; want refusal", err)
+	}
+	if len(*ran) != 0 {
+		t.Errorf("ran %q; want nothing run", *ran)
+	}
+}
+
+// TestNBD checks that partitions of Network Block Devices, named like
+// nbd0p1, are found and grown through their disk's sysfs directory.
+func TestNBD(t *testing.T) {
+	if got, err := diskDev("/dev/nbd0p1"); got != "/dev/nbd0" || err != nil {
+		t.Errorf("diskDev(/dev/nbd0p1) = %q, %v; want /dev/nbd0", got, err)
+	}
+	if got := partNum("/dev/nbd12p3", 1); got != 3 {
+		t.Errorf("partNum(/dev/nbd12p3) = %d; want 3", got)
+	}
+	if !isPartitionDevName("/dev/nbd0p1") || isPartitionDevName("/dev/nbd0") {
+		t.Error("isPartitionDevName got nbd0p1 or nbd0 wrong")
+	}
+	if r, err := pvResizer("/dev/nbd1").DepResizer(); r != nil || err != nil {
+		t.Errorf("DepResizer of a PV on the whole NBD disk = %v, %v; want nothing", r, err)
+	}
+
+	defer func(v bool) { *dry = v }(*dry)
+	*dry = true
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("nbd0", "0")
+	sys.part("nbd0", "nbd0p1", "1", "20969472")
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte("label: dos\ndevice: /dev/nbd0\nunit: sectors\n\n/dev/nbd0p1 : start=2048, size=20969472, type=83\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	e, err := getRawResizer("/dev/nbd0p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Resize(); err == nil || !strings.Contains(err.Error(), "nbd-client") {
+		t.Errorf("Resize of disconnected NBD = %v; want error about nbd-client", err)
+	}
+
+	sys.file("block/nbd0/size", "41943040\n")
+	*ran = nil
+	if err := e.Resize(); err != nil {
+		t.Fatal(err)
+	}
+	var wrote []string
+	for _, args := range *ran {
+		if args[0] == "/sbin/sfdisk" && args[1] == "--no-act" {
+			wrote = args
+		}
+	}
+	if want := []string{"/sbin/sfdisk", "--no-act", "-f", "--no-reread", "--no-tell-kernel", "/dev/nbd0"}; !reflect.DeepEqual(wrote, want) {
+		t.Errorf("checked new table with %q; want %q", wrote, want)
+	}
+}
+
+// TestLabelIDKept checks that the disk's label-id is written back
+// unchanged, and that it's read back after writing to check sfdisk
+// kept it.
+func TestLabelIDKept(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	blkpgResizePartition = func(string, sfdiskLine) error { return nil }
+	_, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	const labelID = "3E8C1F2A-6B1D-4C5E-9F0A-2B7D8E4C1A60"
+
+	pt, err := parsePartitionTable([]byte(gptUUIDSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ := pt.lastPartition()
+	if err := pt.growPartition(part, part.Size()+20971520, true); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	pt.Write(&buf)
+	if !strings.Contains(buf.String(), "label-id: "+labelID+"\n") {
+		t.Errorf("new table lacks label-id %s:\n%s", labelID, buf.Bytes())
+	}
+	if err := pt.checkIDsKept([]byte(strings.Replace(buf.String(), labelID, "00000000-0000-0000-0000-000000000000", 1))); err == nil {
+		t.Error("checkIDsKept with a changed label-id: want error")
+	}
+
+	readBack := gptUUIDSample
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte(readBack), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	if err := writePartitionTable("/dev/sda", pt, part); err != nil {
+		t.Fatal(err)
+	}
+	if last := (*ran)[len(*ran)-1]; !reflect.DeepEqual(last, []string{"/sbin/sfdisk", "-d", "/dev/sda"}) {
+		t.Errorf("last ran %q; want the table read back", last)
+	}
+
+	readBack = strings.Replace(gptUUIDSample, labelID, "11111111-2222-4333-8444-555555555555", 1)
+	err = writePartitionTable("/dev/sda", pt, part)
+	if err == nil || !strings.Contains(err.Error(), "sfdisk --disk-id /dev/sda "+labelID) {
+		t.Errorf("writePartitionTable when sfdisk changed the label-id = %v; want error", err)
+	}
+}
+
+// TestFreeRegions checks that with free space both between partitions
+// and after the last one, only the space at the end of the disk is
+// grown into, and the gaps are reported.
+func TestFreeRegions(t *testing.T) {
+	const table = `label: gpt
+label-id: 3E8C1F2A-6B1D-4C5E-9F0A-2B7D8E4C1A60
+device: /dev/vdz
+unit: sectors
+first-lba: 2048
+last-lba: 41943006
+sector-size: 512
+
+/dev/vdz1 : start=2048, size=1048576, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B
+/dev/vdz2 : start=5244928, size=10485760, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+/dev/vdz3 : start=17827840, size=10485760, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+`
+	pt, err := parsePartitionTable([]byte(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gaps, tail := pt.freeRegions(41943040)
+	wantGaps := []freeRegion{{start: 1050624, size: 4194304}, {start: 15730688, size: 2097152}}
+	if !reflect.DeepEqual(gaps, wantGaps) {
+		t.Errorf("gaps = %+v; want %+v", gaps, wantGaps)
+	}
+	if want := (freeRegion{start: 28313600, size: 13629440}); tail != want {
+		t.Errorf("tail = %+v; want %+v", tail, want)
+	}
+
+	defer func(v bool) { *dry = v }(*dry)
+	*dry = true
+	defer func() { recording, recorded, notes = false, nil, nil }()
+	recording, recorded, notes = true, nil, nil
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdz", "41943040")
+	sys.part("vdz", "vdz3", "3", "10485760")
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte(table), nil
+		}
+		if args[0] == "sgdisk" {
+			return []byte("No problems found. 0 free sectors\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	if err := partitionResizer("/dev/vdz3").Resize(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 {
+		t.Fatalf("recorded %+v; want the one table write", recorded)
+	}
+	// The 13629440 sectors after vdz3, less the 1 MiB kept at the end.
+	if want := "/dev/vdz3 : start=17827840, size=24113152,"; !strings.Contains(recorded[0].Stdin, want) {
+		t.Errorf("new table lacks %q:\n%s", want, recorded[0].Stdin)
+	}
+	gapNotes := 0
+	for _, n := range notes {
+		if strings.Contains(n, "free between partitions") {
+			gapNotes++
+		}
+	}
+	if gapNotes != 2 {
+		t.Errorf("notes %q; want the 2 gaps reported", notes)
+	}
+}
+
+func TestCheckBootPartition(t *testing.T) {
+	td, err := ioutil.TempDir("", "embiggen-boot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	if err := os.MkdirAll(filepath.Join(td, "proc", "self"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mountinfo := "22 1 8:5 / / rw,relatime shared:1 - ext4 /dev/sda5 rw\n" +
+		"23 22 8:2 / /boot rw,relatime shared:2 - ext4 /dev/sda2 rw\n" +
+		"24 23 8:1 / /boot/efi rw,relatime shared:3 - vfat /dev/sda1 rw\n"
+	if err := ioutil.WriteFile(filepath.Join(td, "proc", "self", "mountinfo"), []byte(mountinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { procDir = p }(procDir)
+	procDir = filepath.Join(td, "proc")
+	defer func(v bool) { *force = v }(*force)
+
+	for _, tt := range []struct {
+		dev, mnt string
+	}{
+		{"/dev/sda5", ""},
+		{"/dev/sda2", "/boot"},
+		{"/dev/sda1", "/boot/efi"},
+	} {
+		*force = false
+		err := checkBootPartition(sfdiskLine{dev: tt.dev})
+		if tt.mnt == "" {
+			if err != nil {
+				t.Errorf("%s: %v; want nil", tt.dev, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "mounted at "+tt.mnt) || !strings.Contains(err.Error(), "--force") {
+			t.Errorf("%s: %v; want refusal naming %s and --force", tt.dev, err, tt.mnt)
+		}
+		*force = true
+		if err := checkBootPartition(sfdiskLine{dev: tt.dev}); err != nil {
+			t.Errorf("%s with --force: %v; want nil", tt.dev, err)
+		}
+	}
+
+	// The refusal stops a resize before anything is written.
+	*force = false
+	mountinfo = "22 1 8:5 / /boot rw,relatime shared:1 - ext4 /dev/sda5 rw\n"
+	if err := ioutil.WriteFile(filepath.Join(td, "proc", "self", "mountinfo"), []byte(mountinfo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte(mbrSample), nil
+		}
+		if args[0] == "/sbin/sfdisk" && args[1] == "--version" {
+			return []byte("sfdisk from util-linux 2.34\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	defer unlockDisks()
+	if err := partitionResizer("/dev/sda5").Resize(); err == nil || !strings.Contains(err.Error(), "mounted at /boot") {
+		t.Fatalf("Resize of /boot partition = %v; want refusal", err)
+	}
+	for _, args := range *ran {
+		if args[0] == "/sbin/sfdisk" && args[1] != "-d" && args[1] != "--version" {
+			t.Errorf("ran %q after refusing", args)
+		}
+	}
+}
+
+// TestTinyDisk checks disks, such as test images, where the free
+// space after the last partition is no more than the end reserve.
+func TestTinyDisk(t *testing.T) {
+	if n := growSectors(1024, 512, false, false); n > 0 {
+		t.Errorf("growSectors(1024 of 2048 reserved) = %d; want <= 0", n)
+	}
+	if n := growSectors(1024, 512, false, true); n != 1024 {
+		t.Errorf("growSectors(1024, force) = %d; want 1024", n)
+	}
+
+	for _, tt := range []struct {
+		name             string
+		size, remain     int64
+		isGPT            bool
+		want, wantAbsent []string
+	}{
+		{
+			name:       "mbr within reserve",
+			size:       4096,
+			remain:     1024,
+			want:       []string{"ends 1024 sectors (524288 bytes, 0.000 GiB) before the end of /dev/vdz", "within the 2048 sectors", "--force grows into it anyway"},
+			wantAbsent: []string{"whole disk"},
+		},
+		{
+			name:   "gpt disk smaller than the reserve",
+			size:   2000,
+			remain: 966,
+			isGPT:  true,
+			want:   []string{"The whole disk is only 2000 sectors", "--gpt-reserve=", "--force grows into it anyway"},
+		},
+		{
+			name:       "gpt within backup header",
+			size:       2000,
+			remain:     33,
+			isGPT:      true,
+			want:       []string{"ends 33 sectors"},
+			wantAbsent: []string{"--force"},
+		},
+	} {
+		got := reserveNote("/dev/vdz", "/dev/vdz1", tt.size, tt.remain, 512, tt.isGPT)
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: note %q lacks %q", tt.name, got, w)
+			}
+		}
+		for _, w := range tt.wantAbsent {
+			if strings.Contains(got, w) {
+				t.Errorf("%s: note %q has %q", tt.name, got, w)
+			}
+		}
+	}
+
+	// A 2 MiB disk whose partition leaves half a MiB free: nothing is
+	// written, and the note says why, as does --report-reclaimable-only.
+	defer func() { notes = nil }()
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdz", "4096")
+	sys.part("vdz", "vdz1", "1", "1024")
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte("label: dos\ndevice: /dev/vdz\nunit: sectors\n\n/dev/vdz1 : start=2048, size=1024, type=83\n"), nil
+		}
+		if args[0] == "/sbin/sfdisk" && args[1] == "--version" {
+			return []byte("sfdisk from util-linux 2.34\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	defer unlockDisks()
+	for _, f := range []func() error{
+		partitionResizer("/dev/vdz1").Resize,
+		func() error {
+			n, err := reclaimableBytes(partitionResizer("/dev/vdz1"))
+			if n != 0 {
+				t.Errorf("reclaimable = %d; want 0", n)
+			}
+			return err
+		},
+	} {
+		notes = nil
+		if err := f(); err != nil {
+			t.Fatal(err)
+		}
+		if len(notes) != 1 || !strings.Contains(notes[0], "/dev/vdz1 ends 1024 sectors") {
+			t.Errorf("notes = %q; want one saying the free space is within the reserve", notes)
+		}
+	}
+	for _, args := range *ran {
+		if args[0] == "/sbin/sfdisk" && args[1] != "-d" && args[1] != "--version" {
+			t.Errorf("ran %q on a disk with only the reserve free", args)
+		}
+	}
+
+	// A full disk gets no such note.
+	sys.file("block/vdz/size", "3072\n")
+	notes = nil
+	if err := partitionResizer("/dev/vdz1").Resize(); err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 0 {
+		t.Errorf("notes for a full disk = %q; want none", notes)
+	}
+}
+
+// TestSfdiskFailure checks that failing to read or write a partition
+// table is an error returned to the caller, rather than exiting.
+func TestSfdiskFailure(t *testing.T) {
+	if _, err := parsePartitionTable([]byte("label: dos\n\n/dev/vdb1 : start=2048, type=83\n")); err == nil || !strings.Contains(err.Error(), `no attribute "size"`) {
+		t.Errorf("table without a size: %v; want error", err)
+	}
+	if _, err := parsePartitionTable([]byte("label: dos\n\n/dev/vdb1 : start=x, size=100, type=83\n")); err == nil || !strings.Contains(err.Error(), "non-integer") {
+		t.Errorf("table with a bogus start: %v; want error", err)
+	}
+
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if reflect.DeepEqual(args, []string{"/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", "/dev/vdb"}) {
+			return nil, errors.New("exit status 1")
+		}
+		return nil, nil
+	})
+	defer restore()
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("vdb", "41943040")
+	sys.part("vdb", "vdb1", "1", "20969472")
+	sys.file("block/vdb/vdb1/holders/dm-0", "") // busy, so sfdisk doesn't reread it
+
+	pt, err := parsePartitionTable([]byte("label: dos\ndevice: /dev/vdb\nunit: sectors\n\n/dev/vdb1 : start=2048, size=20969472, type=83\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ := pt.lastPartition()
+	err = writePartitionTable("/dev/vdb", pt, part)
+	if err == nil || !strings.Contains(err.Error(), "sfdisk: exit status 1") {
+		t.Errorf("writePartitionTable = %v; want sfdisk's failure", err)
+	}
+}
+
+func TestPartitionDevNames(t *testing.T) {
+	for disk, part1 := range map[string]string{
+		"/dev/sda":     "/dev/sda1",
+		"/dev/vdb":     "/dev/vdb1",
+		"/dev/sdp":     "/dev/sdp1",
+		"/dev/nvme0n1": "/dev/nvme0n1p1",
+		"/dev/mmcblk0": "/dev/mmcblk0p1",
+		"/dev/loop12":  "/dev/loop12p1",
+		"/dev/nbd0":    "/dev/nbd0p1",
+	} {
+		if got := partitionDevName(disk, 1); got != part1 {
+			t.Errorf("partitionDevName(%q, 1) = %q; want %q", disk, got, part1)
+		}
+		if got := diskFromPartition(part1); got != disk {
+			t.Errorf("diskFromPartition(%q) = %q; want %q", part1, got, disk)
+		}
+		if got, err := diskDev(part1); got != disk || err != nil {
+			t.Errorf("diskDev(%q) = %q, %v; want %q", part1, got, err, disk)
+		}
+		if !isPartitionDevName(part1) {
+			t.Errorf("isPartitionDevName(%q) = false", part1)
+		}
+		if diskFromPartition(disk) != "" || isPartitionDevName(disk) {
+			t.Errorf("whole disk %q taken for a partition", disk)
+		}
+	}
+	if got := partitionDevName("/dev/nvme0n1", 12); got != "/dev/nvme0n1p12" {
+		t.Errorf("partition 12 = %q", got)
+	}
+}
+
+// TestDiskDevUnsupported checks that partitions diskDev can't map to a
+// disk are errors, not panics.
+func TestDiskDevUnsupported(t *testing.T) {
+	for _, dev := range []string{"sda1", "/dev/md0p1", "/dev/nvme0n1"} {
+		if disk, err := diskDev(dev); err == nil {
+			t.Errorf("diskDev(%q) = %q; want error", dev, disk)
+		}
+	}
+	if err := partitionResizer("/dev/md0p1").Resize(); err == nil || !strings.Contains(err.Error(), "unsupported device") {
+		t.Errorf("Resize of /dev/md0p1 = %v; want unsupported device", err)
+	}
+	if err := (sfdiskLine{dev: "/dev/sda1"}).SetSize(2048); err == nil {
+		t.Errorf("SetSize without a size attribute succeeded")
+	}
+}
+
+// TestResize4KnBLKPG checks that when sfdisk doesn't say a disk has
+// 4096-byte sectors, BLKPG is still told the partition's new extent in
+// the kernel's sector size, as sfdisk is.
+func TestResize4KnBLKPG(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	var told sfdiskLine
+	blkpgResizePartition = func(_ string, part sfdiskLine) error {
+		told = part
+		return nil
+	}
+	defer func() { notes, warnings = nil, nil }()
+
+	// An 8 GiB disk with a 1 GiB partition at 1 MiB, in 4096-byte
+	// sectors. sysfs sizes are in 512-byte units regardless.
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sdq", "16777216")
+	sys.file("block/sdq/queue/logical_block_size", "4096\n")
+	sys.part("sdq", "sdq1", "1", "2097152")
+	sys.file("block/sdq/sdq1/start", "2048\n")
+	sys.file("block/sdq/sdq1/holders/dm-0", "") // busy, so BLKPG is used
+
+	var written string
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte("label: dos\nlabel-id: 0x1234abcd\ndevice: /dev/sdq\nunit: sectors\n\n/dev/sdq1 : start=256, size=262144, type=83\n"), nil
+		}
+		if args[0] == "/sbin/sfdisk" && args[1] == "--version" {
+			return []byte("sfdisk from util-linux 2.34\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	defer func(f func(*exec.Cmd) error) { cmdRun = f }(cmdRun)
+	run := cmdRun
+	cmdRun = func(c *exec.Cmd) error {
+		if c.Stdin != nil {
+			b, _ := ioutil.ReadAll(c.Stdin)
+			written = string(b)
+		}
+		return run(c)
+	}
+
+	if err := partitionResizer("/dev/sdq1").Resize(); err != nil {
+		t.Fatal(err)
+	}
+	// 2097152 sectors of 4096 bytes, less the 256 (1 MiB) reserved at
+	// the end and the 256 before the partition.
+	const wantSize = 2097152 - 256 - 256
+	if !strings.Contains(written, fmt.Sprintf("size=%d", wantSize)) {
+		t.Errorf("wrote table %q; want size=%d", written, wantSize)
+	}
+	start, _ := sectorBytes(told.Start(), told.sectorSize)
+	length, _ := sectorBytes(told.Size(), told.sectorSize)
+	if start != 1<<20 || length != wantSize*4096 {
+		t.Errorf("BLKPG told start %d, length %d; want %d, %d", start, length, 1<<20, wantSize*4096)
+	}
+}
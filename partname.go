@@ -0,0 +1,80 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var partName = flag.String("part-name", "", "grow only the partition with this GPT name (its name= in sfdisk -d), as with --raw; the argument is then its disk, such as /dev/sda")
+
+// Name returns the GPT partition name of sl, without quotes, or the
+// empty string if it has none.
+func (sl sfdiskLine) Name() string {
+	v := sl.Attr("name")
+	if len(v) >= 2 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// partitionNamed returns the partition named name in pt, the
+// partition table of diskDev. It's an error if no partition or more
+// than one has that name.
+func partitionNamed(pt *partitionTable, diskDev, name string) (sfdiskLine, error) {
+	var found []sfdiskLine
+	for _, p := range pt.parts {
+		if p.Name() == name {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return sfdiskLine{}, fmt.Errorf("no partition on %s is named %q", diskDev, name)
+	case 1:
+		return found[0], nil
+	}
+	var devs []string
+	for _, p := range found {
+		devs = append(devs, p.dev)
+	}
+	return sfdiskLine{}, fmt.Errorf("%d partitions on %s are named %q (%s); use --raw with the one to grow", len(found), diskDev, name, strings.Join(devs, ", "))
+}
+
+// getNamedPartitionResizer returns the Resizer for the partition
+// named --part-name on the disk diskArg. It must be the partition
+// there's free space after, as that's the only one that can grow.
+func getNamedPartitionResizer(diskArg string) (Resizer, error) {
+	diskDev := canonicalDev(diskArg)
+	pt, err := readPartitionTable(diskDev)
+	if err != nil {
+		return nil, err
+	}
+	if label := pt.Meta("label"); label != "gpt" {
+		return nil, fmt.Errorf("--part-name needs a GPT disk; %s has a %q partition table", diskDev, label)
+	}
+	part, err := partitionNamed(pt, diskDev, *partName)
+	if err != nil {
+		return nil, err
+	}
+	if last, ok := pt.lastPartition(); !ok || last.dev != part.dev {
+		return nil, fmt.Errorf("partition %s named %q isn't the last on %s, so it has no free space after it to grow into", part.dev, *partName, diskDev)
+	}
+	return getRawResizer(part.dev)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const gptNamedSample = `label: gpt
+label-id: 3E8C1F2A-6B1D-4C5E-9F0A-2B7D8E4C1A60
+device: /dev/sda
+unit: sectors
+first-lba: 2048
+last-lba: 41943006
+sector-size: 512
+
+/dev/sda1 : start=2048, size=1048576, type=C12A7328-F81F-11D2-BA4B-00A0C93EC93B, uuid=0D3AF4B2-9E71-4C8A-B5D6-1F2E3A4B5C6D, name="EFI System"
+/dev/sda2 : start=1050624, size=2097152, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, uuid=1A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D, name="root, old"
+/dev/sda3 : start=3147776, size=2097152, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, uuid=2A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D, name="scratch"
+/dev/sda4 : start=5244928, size=2097152, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, uuid=3A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D, name="scratch"
+/dev/sda5 : start=7342080, size=2097152, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4, uuid=4A1B2C3D-4E5F-4A6B-8C7D-9E0F1A2B3C4D, name = "data"
+`
+
+func TestPartitionNamed(t *testing.T) {
+	pt, err := parsePartitionTable([]byte(gptNamedSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		wantDev string
+		wantErr string
+	}{
+		{"EFI System", "/dev/sda1", ""},
+		{"root, old", "/dev/sda2", ""},
+		{"data", "/dev/sda5", ""},
+		{"root", "", `no partition on /dev/sda is named "root"`},
+		{"scratch", "", `2 partitions on /dev/sda are named "scratch" (/dev/sda3, /dev/sda4)`},
+	}
+	for _, tt := range tests {
+		part, err := partitionNamed(pt, "/dev/sda", tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("partitionNamed(%q) = %v, %v; want error containing %q", tt.name, part.dev, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || part.dev != tt.wantDev {
+			t.Errorf("partitionNamed(%q) = %v, %v; want %v", tt.name, part.dev, err, tt.wantDev)
+		}
+	}
+
+	// A name with a comma or spaces around its = is written back as it was.
+	var sb strings.Builder
+	pt.Write(&sb)
+	for _, want := range []string{`name="root, old"`, `name="data"`} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("rewritten table lacks %s:\n%s", want, sb.String())
+		}
+	}
+}
+
+func TestGetNamedPartitionResizer(t *testing.T) {
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "/sbin/sfdisk" && args[1] == "-d" {
+			return []byte(gptNamedSample), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+	defer func(old string) { *partName = old }(*partName)
+
+	*partName = "data"
+	e, err := getNamedPartitionResizer("/dev/sda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e != partitionResizer("/dev/sda5") {
+		t.Errorf("resizer = %v; want partition /dev/sda5", e)
+	}
+
+	*partName = "root, old"
+	if _, err := getNamedPartitionResizer("/dev/sda"); err == nil || !strings.Contains(err.Error(), "isn't the last") {
+		t.Errorf("partition not last: err = %v; want isn't the last", err)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	savePlan   = flag.String("save-plan", "", "don't make changes; write what would be resized, and the state it's in now, to this file for review")
+	applyPlan  = flag.String("apply-plan", "", "resize as planned in this file from --save-plan, refusing if anything has changed since")
+	expectPlan = flag.String("expect-plan", "", "don't make changes; check that the commands a --dry-run would run are those in this file, one per line as --record writes them in a .sh file (blank lines and # comments are ignored), and fail with a diff if not. For golden tests of disk layouts, with --sysfs-root and --proc-root")
+)
+
+// planVersion is the version of the plan file format.
+const planVersion = 1
+
+// A plan is what --save-plan writes: the layers that would be resized
+// and what they looked like when the plan was made.
+type plan struct {
+	Version int         `json:"version"`
+	Mount   string      `json:"mount"`
+	Layers  []planLayer `json:"layers"` // top (filesystem) first
+}
+
+type planLayer struct {
+	Resizer string `json:"resizer"`
+	State   string `json:"state"`
+
+	// For partitions: the disk's size in 512-byte sectors and its
+	// partition table, as "sfdisk -d" prints it.
+	DiskSectors int64  `json:"diskSectors,omitempty"`
+	Table       string `json:"table,omitempty"`
+}
+
+// makePlan returns the plan for resizing e, the top Resizer for mnt.
+func makePlan(mnt string, e Resizer) (*plan, error) {
+	p := &plan{Version: planVersion, Mount: mnt}
+	for e != nil {
+		st, err := e.State()
+		if err != nil {
+			return nil, err
+		}
+		l := planLayer{Resizer: e.String(), State: st}
+		if pr, ok := e.(partitionResizer); ok {
+			disk, err := diskDev(string(pr))
+			if err != nil {
+				return nil, err
+			}
+			if l.DiskSectors, err = diskSize(disk); err != nil {
+				return nil, err
+			}
+			out, err := cmdOutput(command("/sbin/sfdisk", "-d", disk))
+			if err != nil {
+				return nil, fmt.Errorf("sfdisk -d %s: %v", disk, execErrDetail(err))
+			}
+			l.Table = string(out)
+		}
+		p.Layers = append(p.Layers, l)
+		if e, err = e.DepResizer(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// checkUnchanged returns an error describing the first difference
+// between p, a saved plan, and now, the plan for the current state.
+func (p *plan) checkUnchanged(now *plan) error {
+	if p.Version != planVersion {
+		return fmt.Errorf("plan has version %d; want %d", p.Version, planVersion)
+	}
+	if p.Mount != now.Mount {
+		return fmt.Errorf("plan is for %s, not %s", p.Mount, now.Mount)
+	}
+	if len(p.Layers) != len(now.Layers) {
+		return fmt.Errorf("plan has %d layers to resize; now there are %d", len(p.Layers), len(now.Layers))
+	}
+	for i, was := range p.Layers {
+		is := now.Layers[i]
+		switch {
+		case was.Resizer != is.Resizer:
+			return fmt.Errorf("plan's layer %d was %s; now it's %s", i, was.Resizer, is.Resizer)
+		case was.State != is.State:
+			return fmt.Errorf("%s was %s when planned; now it's %s", was.Resizer, was.State, is.State)
+		case was.DiskSectors != is.DiskSectors:
+			return fmt.Errorf("disk of %s was %d sectors when planned; now it's %d", was.Resizer, was.DiskSectors, is.DiskSectors)
+		case was.Table != is.Table:
+			return fmt.Errorf("partition table of the disk of %s changed since it was planned", was.Resizer)
+		}
+	}
+	return nil
+}
+
+// writePlan writes the plan for resizing e to file.
+func writePlan(file, mnt string, e Resizer) error {
+	p, err := makePlan(mnt, e)
+	if err != nil {
+		return err
+	}
+	js, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, append(js, '\n'), 0644)
+}
+
+// checkPlan returns an error unless the plan in file still describes
+// resizing e, the top Resizer for mnt.
+func checkPlan(file, mnt string, e Resizer) error {
+	js, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	saved := new(plan)
+	if err := json.Unmarshal(js, saved); err != nil {
+		return fmt.Errorf("parsing plan %s: %v", file, err)
+	}
+	now, err := makePlan(mnt, e)
+	if err != nil {
+		return err
+	}
+	if err := saved.checkUnchanged(now); err != nil {
+		return fmt.Errorf("refusing to apply stale plan %s: %v", file, err)
+	}
+	return nil
+}
+
+// exitPlanMismatch is the exit status when --expect-plan's commands
+// aren't the ones planned.
+const exitPlanMismatch = 11
+
+// A planMismatchError is the error from checkExpectedPlan when the
+// planned commands differ from those expected.
+type planMismatchError struct {
+	file string
+	diff string
+}
+
+func (e *planMismatchError) Error() string {
+	return fmt.Sprintf("planned commands differ from --expect-plan %s (-expected +planned):\n%s", e.file, e.diff)
+}
+
+// plannedCommands returns the commands recorded as skipped by the dry
+// run, as shell.
+func plannedCommands() string {
+	var buf bytes.Buffer
+	for _, rc := range recorded {
+		if rc.DryRun {
+			buf.WriteString(rc.shellCommand())
+		}
+	}
+	return buf.String()
+}
+
+// checkExpectedPlan returns a *planMismatchError unless the dry run's
+// planned commands are those in file.
+func checkExpectedPlan(file string) error {
+	want, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	wantLines, gotLines := planLines(string(want)), planLines(plannedCommands())
+	if diff := lineDiff(wantLines, gotLines); diff != "" {
+		return &planMismatchError{file: file, diff: diff}
+	}
+	return nil
+}
+
+// planLines returns the lines of an --expect-plan file, without blank
+// lines and comments.
+func planLines(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimRight(l, " \t\r"); l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// lineDiff returns a line diff from a to b, with each line prefixed by
+// " ", "-" or "+", or "" if they're equal.
+func lineDiff(a, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var buf bytes.Buffer
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&buf, " %s\n", a[i])
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			fmt.Fprintf(&buf, "-%s\n", a[i])
+			i++
+			changed = true
+		default:
+			fmt.Fprintf(&buf, "+%s\n", b[j])
+			j++
+			changed = true
+		}
+	}
+	if !changed {
+		return ""
+	}
+	return buf.String()
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubResizer is a Resizer with a fixed state and dependency.
+type stubResizer struct {
+	name, state string
+	dep         Resizer
+}
+
+func (s *stubResizer) String() string               { return s.name }
+func (s *stubResizer) State() (string, error)       { return s.state, nil }
+func (s *stubResizer) Resize() error                { return nil }
+func (s *stubResizer) DepResizer() (Resizer, error) { return s.dep, nil }
+
+func TestPlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "embiggen-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "plan.json")
+
+	pv := &stubResizer{name: "LVM PV /dev/sdb1", state: "sectors=100"}
+	lv := &stubResizer{name: "LVM LV /dev/mapper/vg-data", state: "sectors=90", dep: pv}
+	fs := &stubResizer{name: "ext4 filesystem at /data", state: "1000 blocks", dep: lv}
+
+	if err := writePlan(file, "/data", fs); err != nil {
+		t.Fatal(err)
+	}
+	js, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"version": 1`, `"mount": "/data"`, `"resizer": "LVM LV /dev/mapper/vg-data"`, `"state": "sectors=100"`} {
+		if !strings.Contains(string(js), want) {
+			t.Errorf("plan lacks %s:\n%s", want, js)
+		}
+	}
+
+	if err := checkPlan(file, "/data", fs); err != nil {
+		t.Errorf("unchanged: %v", err)
+	}
+	if err := checkPlan(file, "/srv", fs); err == nil {
+		t.Error("different mount: want error")
+	}
+	pv.state = "sectors=200"
+	err = checkPlan(file, "/data", fs)
+	if err == nil || !strings.Contains(err.Error(), "LVM PV /dev/sdb1 was sectors=100 when planned; now it's sectors=200") {
+		t.Errorf("PV changed: %v; want stale plan error", err)
+	}
+	pv.state = "sectors=100"
+	lv.dep = nil
+	if err := checkPlan(file, "/data", fs); err == nil || !strings.Contains(err.Error(), "layers") {
+		t.Errorf("layer gone: %v; want stale plan error", err)
+	}
+}
+
+func TestExpectPlan(t *testing.T) {
+	defer func() { recording, recorded = false, nil }()
+	recording, recorded = true, nil
+	sfdisk := command("/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", "/dev/sda")
+	sfdisk.Stdin = strings.NewReader("label: dos\n\n/dev/sda1 : start=2048, size=41940992, type=83\n")
+	recordSkipped(sfdisk)
+	recordSkipped(command("resize2fs", "/dev/sda1"))
+
+	td, err := ioutil.TempDir("", "embiggen-expect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	file := filepath.Join(td, "plan.sh")
+
+	const want = `# Golden plan for a 20 GiB disk.
+/sbin/sfdisk -f --no-reread --no-tell-kernel /dev/sda <<'EOF'
+label: dos
+
+/dev/sda1 : start=2048, size=41940992, type=83
+EOF
+
+resize2fs /dev/sda1
+`
+	if err := ioutil.WriteFile(file, []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkExpectedPlan(file); err != nil {
+		t.Errorf("matching plan: %v", err)
+	}
+
+	stale := strings.Replace(want, "41940992", "20969472", 1)
+	if err := ioutil.WriteFile(file, []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = checkExpectedPlan(file)
+	if _, ok := err.(*planMismatchError); !ok {
+		t.Fatalf("mismatched plan: got %v; want a *planMismatchError", err)
+	}
+	for _, line := range []string{
+		"-/dev/sda1 : start=2048, size=20969472, type=83\n",
+		"+/dev/sda1 : start=2048, size=41940992, type=83\n",
+		" resize2fs /dev/sda1\n",
+	} {
+		if !strings.Contains(err.Error(), line) {
+			t.Errorf("diff lacks %q:\n%v", line, err)
+		}
+	}
+	if code := errExitCode(err); code != exitPlanMismatch {
+		t.Errorf("exit status = %d; want %d", code, exitPlanMismatch)
+	}
+}
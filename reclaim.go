@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+var reportReclaimable = flag.Bool("report-reclaimable-only", false, fmt.Sprintf("don't make changes; just print how much unallocated space the argument's partition could grow into, beyond what's kept free at the end of the disk, and exit %d if there is any, else 0", exitReclaimable))
+
+// exitReclaimable is the exit status with --report-reclaimable-only
+// when there's space to grow into.
+const exitReclaimable = 10
+
+// reclaimableSectors returns how many sectors the partition described
+// by f could grow by, or 0 if it can't grow.
+func reclaimableSectors(f diskFacts) int64 {
+	if !f.canGrow() {
+		return 0
+	}
+	n := f.diskSize - f.partEnd - endReserve(f.sectorSize, f.label == "gpt", false)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// canGrow reports whether the partition described by f could grow
+// into free space after it, were there any.
+func (f diskFacts) canGrow() bool {
+	return (f.label == "dos" || f.label == "gpt") && f.typeErr == nil && f.after == ""
+}
+
+// reclaimableBytes returns how many bytes the partition below e could
+// grow by. It's 0 if e isn't on a partition.
+func reclaimableBytes(e Resizer) (int64, error) {
+	bottom, err := bottomResizer(e)
+	if err != nil {
+		return 0, err
+	}
+	pr, ok := bottom.(partitionResizer)
+	if !ok {
+		return 0, nil
+	}
+	f, err := partitionFacts(pr)
+	if err != nil {
+		return 0, err
+	}
+	for _, g := range f.gaps {
+		notef("%s", gapNote(f.disk, f.part, g, f.sectorSize))
+	}
+	n := reclaimableSectors(f)
+	if remain := f.diskSize - f.partEnd; n == 0 && remain > 0 && f.canGrow() {
+		notef("%s", reserveNote(f.disk, f.part, f.diskSize, remain, f.sectorSize, f.label == "gpt"))
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return sectorBytes(n, f.sectorSize)
+}
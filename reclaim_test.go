@@ -0,0 +1,80 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestReclaimable(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "41943040")
+	sys.file("block/sda/queue/logical_block_size", "512\n")
+	sys.part("sda", "sda1", "1", "20969472")
+	size := "20969472"
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		return []byte("label: gpt\ndevice: /dev/sda\nunit: sectors\n\n/dev/sda1 : start=2048, size=" + size + ", type=0FC63DAF-8483-4772-8E79-3D69D8477DE4\n"), nil
+	})
+	defer restore()
+	defer func(v bool) { *reportReclaimable = v }(*reportReclaimable)
+	*reportReclaimable = true
+
+	// 20 GiB disk, with the partition ending at 10 GiB.
+	n, err := reclaimableBytes(partitionResizer("/dev/sda1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(10<<30 - 1<<20); n != want {
+		t.Errorf("reclaimable = %d; want %d", n, want)
+	}
+	if code := successExitCode(embiggen.Result{ReclaimableBytes: n}); code != exitReclaimable {
+		t.Errorf("exit code with space to reclaim = %d; want %d", code, exitReclaimable)
+	}
+
+	// Only the reserved last MiB is free.
+	size = "41938944"
+	n, err = reclaimableBytes(partitionResizer("/dev/sda1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("reclaimable of a full disk = %d; want 0", n)
+	}
+	if code := successExitCode(embiggen.Result{ReclaimableBytes: n}); code != 0 {
+		t.Errorf("exit code with nothing to reclaim = %d; want 0", code)
+	}
+}
+
+func TestReclaimableSectors(t *testing.T) {
+	base := diskFacts{label: "dos", sectorSize: 512, diskSize: 10000, partEnd: 5000}
+	if n := reclaimableSectors(base); n != 10000-5000-2048 {
+		t.Errorf("reclaimableSectors = %d", n)
+	}
+	blocked := base
+	blocked.after = "/dev/sda2"
+	if n := reclaimableSectors(blocked); n != 0 {
+		t.Errorf("reclaimableSectors with a partition in the way = %d; want 0", n)
+	}
+	odd := base
+	odd.label = "sun"
+	if n := reclaimableSectors(odd); n != 0 {
+		t.Errorf("reclaimableSectors of an unsupported table = %d; want 0", n)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// A recordedCmd is an external command run (or, in dry-run mode,
+// skipped), as written by --record.
+type recordedCmd struct {
+	Args   []string `json:"args"`
+	Stdin  string   `json:"stdin,omitempty"`
+	DryRun bool     `json:"dryRun,omitempty"` // not run because of --dry-run
+	Output string   `json:"output,omitempty"` // only with --record-output
+	Error  string   `json:"error,omitempty"`
+}
+
+// recorded are the commands run so far, once startRecording is called.
+var (
+	recording bool
+	recorded  []recordedCmd
+)
+
+// startRecording makes the cmd hooks record every command they run.
+func startRecording() {
+	recording = true
+	output, combined, run := cmdOutput, cmdCombinedOutput, cmdRun
+	cmdOutput = func(c *exec.Cmd) ([]byte, error) {
+		rc := newRecordedCmd(c)
+		out, err := output(c)
+		recordCmd(rc, out, err)
+		return out, err
+	}
+	cmdCombinedOutput = func(c *exec.Cmd) ([]byte, error) {
+		rc := newRecordedCmd(c)
+		out, err := combined(c)
+		recordCmd(rc, out, err)
+		return out, err
+	}
+	cmdRun = func(c *exec.Cmd) error {
+		rc := newRecordedCmd(c)
+		out := teeOutput(c)
+		err := run(c)
+		recordCmd(rc, out.Bytes(), err)
+		return err
+	}
+}
+
+// teeOutput makes c's stdout and stderr also go to the returned
+// buffer, for recording the output of a command run with cmdRun.
+func teeOutput(c *exec.Cmd) *syncBuffer {
+	buf := new(syncBuffer)
+	tee := func(w io.Writer) io.Writer {
+		if w == nil {
+			return buf
+		}
+		return io.MultiWriter(w, buf)
+	}
+	if c.Stdout == c.Stderr {
+		c.Stdout = tee(c.Stdout)
+		c.Stderr = c.Stdout // still one writer, so exec doesn't write to it concurrently
+		return buf
+	}
+	c.Stdout, c.Stderr = tee(c.Stdout), tee(c.Stderr)
+	return buf
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes, as from a
+// command's stdout and stderr.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// newRecordedCmd returns the record of c, reading (and replacing) its
+// stdin to include it.
+func newRecordedCmd(c *exec.Cmd) recordedCmd {
+	rc := recordedCmd{Args: append([]string(nil), c.Args...)}
+	if c.Stdin != nil {
+		in, _ := ioutil.ReadAll(c.Stdin)
+		rc.Stdin = string(in)
+		c.Stdin = bytes.NewReader(in)
+	}
+	return rc
+}
+
+func recordCmd(rc recordedCmd, out []byte, err error) {
+	if *recordOutput {
+		rc.Output = string(out)
+	}
+	if err != nil {
+		rc.Error = err.Error()
+	}
+	recorded = append(recorded, rc)
+}
+
+// skipDryRun prints that --dry-run didn't run c, and records it.
+func skipDryRun(c *exec.Cmd) {
+	fmt.Fprintf(progress(), "[dry-run] would've run %s\n", strings.Join(c.Args, " "))
+	recordSkipped(c)
+}
+
+// recordSkipped records c as a command that --dry-run didn't run.
+func recordSkipped(c *exec.Cmd) {
+	if !recording {
+		return
+	}
+	rc := newRecordedCmd(c)
+	rc.DryRun = true
+	recorded = append(recorded, rc)
+}
+
+// writeRecord writes the recorded commands to w: as a shell script if
+// asScript, else as JSON. The script is for replaying what changed
+// things, so commands that failed and ones that only read the system's
+// state are commented out in it.
+func writeRecord(w io.Writer, asScript bool) error {
+	if !asScript {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		cmds := recorded
+		if cmds == nil {
+			cmds = []recordedCmd{}
+		}
+		return enc.Encode(cmds)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "#!/bin/sh\n# Commands run by %s.\nset -e\n", versionString())
+	for _, rc := range recorded {
+		buf.WriteString("\n")
+		if rc.DryRun {
+			buf.WriteString("# not run (--dry-run):\n")
+		}
+		switch {
+		case rc.Error != "":
+			fmt.Fprintf(&buf, "# failed: %s\n", strings.Replace(rc.Error, "\n", " ", -1))
+			buf.WriteString(commentOut(rc.shellCommand()))
+		case isProbe(rc.Args):
+			buf.WriteString("# read-only:\n")
+			buf.WriteString(commentOut(rc.shellCommand()))
+		default:
+			buf.WriteString(rc.shellCommand())
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// commentOut returns the lines of shell s as comments.
+func commentOut(s string) string {
+	return "# " + strings.Replace(strings.TrimSuffix(s, "\n"), "\n", "\n# ", -1) + "\n"
+}
+
+// isProbe reports whether the command args only reads the system's
+// state, such as "sfdisk -d" or lvs, rather than changing it. Commands
+// it doesn't know are assumed to change things.
+func isProbe(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch filepath.Base(args[0]) {
+	case "blkid", "dumpe2fs", "findmnt", "lsblk", "lvs", "pvdisplay", "vdostats", "vgs", "xfs_info":
+		return true
+	case "sfdisk":
+		// Besides dumps and --no-act runs, it changes nothing when
+		// not given a device: for --version, or simulate.go's
+		// scratch copy of a table.
+		return containsString(args, "-d") || containsString(args, "--no-act") ||
+			!strings.HasPrefix(args[len(args)-1], "/dev/")
+	case "resize2fs":
+		return containsString(args, "-P") // estimates the minimum size
+	case "sgdisk":
+		return containsString(args, "--verify")
+	case "dmsetup":
+		return len(args) > 1 && args[1] == "table"
+	case "btrfs":
+		return len(args) > 2 && args[1] == "filesystem" && args[2] == "show"
+	}
+	return false
+}
+
+// shellCommand returns rc as a line of shell, with any stdin as a
+// here-document after it.
+func (rc recordedCmd) shellCommand() string {
+	var buf bytes.Buffer
+	for i, a := range rc.Args {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString(shellQuote(a))
+	}
+	if rc.Stdin != "" {
+		buf.WriteString(" <<'EOF'\n" + rc.Stdin)
+		if !strings.HasSuffix(rc.Stdin, "\n") {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("EOF")
+	}
+	buf.WriteString("\n")
+	return buf.String()
+}
+
+var shellSafeRx = regexp.MustCompile(`^[-\w./:=+%@,]+$`)
+
+func shellQuote(s string) string {
+	if shellSafeRx.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	defer func() { recording, recorded = false, nil }()
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		return []byte("ok\n"), nil
+	})
+	defer restore()
+	startRecording()
+
+	if _, err := cmdOutput(exec.Command("blkid", "-o", "export", "/dev/sda")); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("/sbin/sfdisk", "-f", "/dev/sda")
+	cmd.Stdin = strings.NewReader("label: gpt\n")
+	if err := cmdRun(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmdCombinedOutput(exec.Command("resize2fs", "/dev/sda1")); err != nil {
+		t.Fatal(err)
+	}
+	fail := exec.Command("/sbin/sfdisk", "-f", "/dev/sdb")
+	fail.Stdin = strings.NewReader("label: dos\n")
+	recordCmd(newRecordedCmd(fail), nil, errors.New("exit status 1"))
+	recordSkipped(exec.Command("lvextend", "-l", "+100%FREE", "/dev/vg/it's"))
+
+	var got [][]string
+	for _, rc := range recorded {
+		if !rc.DryRun && rc.Error == "" {
+			got = append(got, rc.Args)
+		}
+	}
+	if !reflect.DeepEqual(got, *ran) {
+		t.Errorf("recorded %q; ran %q", got, *ran)
+	}
+	if in := recorded[1].Stdin; in != "label: gpt\n" {
+		t.Errorf("recorded stdin %q", in)
+	}
+
+	var js bytes.Buffer
+	if err := writeRecord(&js, false); err != nil {
+		t.Fatal(err)
+	}
+	var back []recordedCmd
+	if err := json.Unmarshal(js.Bytes(), &back); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back, recorded) {
+		t.Errorf("JSON round trip = %+v; want %+v", back, recorded)
+	}
+
+	var sh bytes.Buffer
+	if err := writeRecord(&sh, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"\n# read-only:\n# blkid -o export /dev/sda\n",
+		"\n/sbin/sfdisk -f /dev/sda <<'EOF'\nlabel: gpt\nEOF\n",
+		"\nresize2fs /dev/sda1\n",
+		"\n# failed: exit status 1\n# /sbin/sfdisk -f /dev/sdb <<'EOF'\n# label: dos\n# EOF\n",
+		"\n# not run (--dry-run):\nlvextend -l +100%FREE '/dev/vg/it'\\''s'\n",
+	} {
+		if !strings.Contains(sh.String(), want) {
+			t.Errorf("script missing %q; got:\n%s", want, sh.String())
+		}
+	}
+}
+
+func TestIsProbe(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"/sbin/sfdisk", "-d", "/dev/sda"}, true},
+		{[]string{"/sbin/sfdisk", "--version"}, true},
+		{[]string{"/sbin/sfdisk", "--no-act", "-f", "--no-reread", "--no-tell-kernel", "/dev/sda"}, true},
+		{[]string{"/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", "/tmp/embiggen-sim123"}, true},
+		{[]string{"/sbin/sfdisk", "-f", "/dev/sda"}, false},
+		{[]string{"lvs", "--noheadings", "vg/root"}, true},
+		{[]string{"resize2fs", "-P", "/dev/sda1"}, true},
+		{[]string{"resize2fs", "/dev/sda1"}, false},
+		{[]string{"dmsetup", "table", "rig"}, true},
+		{[]string{"dmsetup", "reload", "rig", "--table", "0 8 linear 7:0 0"}, false},
+		{[]string{"btrfs", "filesystem", "show", "/"}, true},
+		{[]string{"btrfs", "filesystem", "resize", "1:max", "/"}, false},
+		{[]string{"pvresize", "/dev/sda3"}, false},
+	} {
+		if got := isProbe(tt.args); got != tt.want {
+			t.Errorf("isProbe(%q) = %v; want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRecordRunOutput(t *testing.T) {
+	defer func(v bool) { *recordOutput = v }(*recordOutput)
+	defer func(f func(*exec.Cmd) error) { cmdRun = f }(cmdRun)
+	defer func() { recording, recorded = false, nil }()
+	*recordOutput = true
+	cmdRun = func(c *exec.Cmd) error {
+		fmt.Fprintf(c.Stdout, "The partition table has been altered.\n")
+		return nil
+	}
+	startRecording()
+
+	var out bytes.Buffer
+	cmd := exec.Command("/sbin/sfdisk", "-f", "/dev/sda")
+	cmd.Stdout, cmd.Stderr = &out, &out
+	if err := cmdRun(cmd); err != nil {
+		t.Fatal(err)
+	}
+	const want = "The partition table has been altered.\n"
+	if out.String() != want {
+		t.Errorf("command's own output = %q; want %q", out.String(), want)
+	}
+	if len(recorded) != 1 || recorded[0].Output != want {
+		t.Errorf("recorded %+v; want output %q", recorded, want)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+var reportURL = flag.String("report-url", "", "if non-empty, an http:// or https:// URL to POST the JSON result to after the run, or unix:/path/to/socket to write it to a Unix socket. Failing to report doesn't fail the run.")
+
+const (
+	reportTimeout  = 10 * time.Second
+	reportAttempts = 3
+)
+
+// reportRetryDelay is how long to wait between attempts to report.
+// It's a variable for tests.
+var reportRetryDelay = 2 * time.Second
+
+// sendReport sends res as JSON to dest, a --report-url value,
+// retrying a few times before giving up.
+func sendReport(dest string, res embiggen.Result) error {
+	if !strings.HasPrefix(dest, "unix:") && !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+		return fmt.Errorf("unsupported --report-url %q; want http://, https:// or unix:", dest)
+	}
+	body, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+	return deliver(dest, body)
+}
+
+// deliver sends body to dest, an http://, https:// or unix: URL,
+// retrying a few times before giving up.
+func deliver(dest string, body []byte) (err error) {
+	for i := 1; ; i++ {
+		err = sendReportOnce(dest, body)
+		if err == nil || i == reportAttempts {
+			return err
+		}
+		vlogf("reporting to %s failed (attempt %d of %d): %v", dest, i, reportAttempts, err)
+		time.Sleep(reportRetryDelay)
+	}
+}
+
+func sendReportOnce(dest string, body []byte) error {
+	if path := strings.TrimPrefix(dest, "unix:"); path != dest {
+		c, err := net.DialTimeout("unix", path, reportTimeout)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(reportTimeout))
+		_, err = c.Write(append(body, '\n'))
+		return err
+	}
+	hc := &http.Client{Timeout: reportTimeout}
+	resp, err := hc.Post(dest, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", dest, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestSendReport(t *testing.T) {
+	defer func(d time.Duration) { reportRetryDelay = d }(reportRetryDelay)
+	reportRetryDelay = 0
+
+	var (
+		mu       sync.Mutex
+		attempts int
+		got      embiggen.Result
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "not yet", http.StatusServiceUnavailable)
+			return
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q; want application/json", ct)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("bad JSON posted: %v: %s", err, body)
+		}
+	}))
+	defer ts.Close()
+
+	res := embiggen.Result{
+		Version: embiggen.Version,
+		Mount:   "/",
+		Changes: []embiggen.Change{{Resizer: "partition /dev/sda3", Before: "100 sectors", After: "200 sectors"}},
+	}
+	if err := sendReport(ts.URL, res); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("attempts = %d; want a retry after the 503", attempts)
+	}
+	if !reflect.DeepEqual(got, res) {
+		t.Errorf("posted %+v; want %+v", got, res)
+	}
+}
+
+func TestSendReportGivesUp(t *testing.T) {
+	defer func(d time.Duration) { reportRetryDelay = d }(reportRetryDelay)
+	reportRetryDelay = 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	if err := sendReport(ts.URL, embiggen.Result{}); err == nil {
+		t.Error("sendReport to a failing server succeeded")
+	}
+	if err := sendReport("ftp://example.com/", embiggen.Result{}); err == nil {
+		t.Error("sendReport to ftp:// succeeded")
+	}
+}
+
+func TestSendReportUnixSocket(t *testing.T) {
+	td, err := ioutil.TempDir("", "embiggen-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	sock := filepath.Join(td, "report.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		defer c.Close()
+		b, _ := ioutil.ReadAll(c)
+		got <- string(b)
+	}()
+
+	if err := sendReport("unix:"+sock, embiggen.Result{Version: 1, Mount: "/data"}); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":1,"mount":"/data","changes":null}` + "\n"
+	if s := <-got; s != want {
+		t.Errorf("socket got %q; want %q", s, want)
+	}
+}
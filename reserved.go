@@ -0,0 +1,118 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var extReservedPercent = flag.Float64("ext-reserved-percent", -1, "after growing an ext filesystem, set the share of it reserved for root to this percentage with tune2fs -m, such as 1 for a big data filesystem where the default 5% is wasteful; -1 leaves it as it is")
+
+var extReservedCountRx = regexp.MustCompile(`(?m)^Reserved block count:\s*(\d+)$`)
+
+// extCounts are the sizes from an ext filesystem's superblock that
+// determine how much of it is usable by anyone but root.
+type extCounts struct {
+	blockSize int64 // bytes
+	blocks    int64
+	reserved  int64 // blocks reserved for root
+}
+
+// usableBytes returns the size of the part of the filesystem that
+// isn't reserved for root.
+func (c extCounts) usableBytes() int64 { return (c.blocks - c.reserved) * c.blockSize }
+
+// parseExtCounts parses the output of "dumpe2fs -h".
+func parseExtCounts(out []byte) (extCounts, error) {
+	var c extCounts
+	for _, f := range []struct {
+		name string
+		rx   *regexp.Regexp
+		dst  *int64
+	}{
+		{"block size", extBlockSizeRx, &c.blockSize},
+		{"block count", extBlockCountRx, &c.blocks},
+		{"reserved block count", extReservedCountRx, &c.reserved},
+	} {
+		m := f.rx.FindSubmatch(out)
+		if m == nil {
+			return extCounts{}, fmt.Errorf("no %s in dumpe2fs -h output", f.name)
+		}
+		*f.dst, _ = strconv.ParseInt(string(m[1]), 10, 64)
+	}
+	return c, nil
+}
+
+// isExt reports whether fstype is ext2, ext3 or ext4.
+func isExt(fstype string) bool {
+	return fstype == "ext2" || fstype == "ext3" || fstype == "ext4"
+}
+
+// readExtCounts returns the extCounts of e, if it's an ext filesystem.
+func readExtCounts(e Resizer) (c extCounts, ok bool) {
+	fr, isFS := e.(fsResizer)
+	if !isFS || !isExt(fr.fs.fstype) {
+		return extCounts{}, false
+	}
+	out, err := dumpe2fs(fr.fs.dev)
+	if err == nil {
+		c, err = parseExtCounts(out)
+	}
+	if err != nil {
+		vlogf("not reporting usable space of %v: %v", e, err)
+		return extCounts{}, false
+	}
+	return c, true
+}
+
+// setExtReserved sets the share of the ext filesystem e reserved for
+// root to --ext-reserved-percent, after it grew. Failing to is only
+// warned about, as the growing is done by then.
+func setExtReserved(e Resizer) {
+	fr, ok := e.(fsResizer)
+	if !ok || !isExt(fr.fs.fstype) {
+		notef("--ext-reserved-percent: skipping %v, which isn't an ext filesystem", e)
+		return
+	}
+	cmd := command("tune2fs", "-m", strconv.FormatFloat(*extReservedPercent, 'f', -1, 64), fr.fs.dev)
+	if *dry {
+		skipDryRun(cmd)
+		return
+	}
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		warnf("--ext-reserved-percent: running %s: %v, %s", strings.Join(cmd.Args, " "), err, capOutput(cmd.Args, out))
+		return
+	}
+	notef("--ext-reserved-percent: reserved %v%% of %v for root", *extReservedPercent, e)
+}
+
+// usableGainNote describes how much of an ext filesystem's growth from
+// before to after is usable by other than root.
+func usableGainNote(e Resizer, before, after extCounts) string {
+	raw := (after.blocks - before.blocks) * after.blockSize
+	usable := after.usableBytes() - before.usableBytes()
+	pct := 0.0
+	if after.blocks > 0 {
+		pct = float64(after.reserved) * 100 / float64(after.blocks)
+	}
+	return fmt.Sprintf("%v grew by %d bytes (%.1f GiB), of which %d bytes (%.1f GiB) are usable by other than root; %.1f%% of it is reserved for root (see --ext-reserved-percent)", e, raw, float64(raw)/(1<<30), usable, float64(usable)/(1<<30), pct)
+}
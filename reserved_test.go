@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUsableGain(t *testing.T) {
+	before, err := parseExtCounts([]byte("Block count:              2621440\nReserved block count:     131072\nBlock size:               4096\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (extCounts{blockSize: 4096, blocks: 2621440, reserved: 131072}); before != want {
+		t.Fatalf("parseExtCounts = %+v; want %+v", before, want)
+	}
+	if _, err := parseExtCounts([]byte("Block count: 100\nBlock size: 4096\n")); err == nil || !strings.Contains(err.Error(), "reserved block count") {
+		t.Errorf("parseExtCounts without reserved count: %v; want error", err)
+	}
+
+	// Doubling a 10 GiB filesystem, resize2fs keeps 5% reserved:
+	// 10 GiB more, but only 9.5 GiB of it usable.
+	after := extCounts{blockSize: 4096, blocks: 5242880, reserved: 262144}
+	e := fsResizer{fs: fsStat{dev: "/dev/sda1", mnt: "/data", fstype: "ext4"}}
+	if got, want := after.usableBytes()-before.usableBytes(), int64(10<<30)*95/100; got != want {
+		t.Errorf("usable gain = %d; want %d", got, want)
+	}
+	note := usableGainNote(e, before, after)
+	for _, want := range []string{"grew by 10737418240 bytes (10.0 GiB)", "10200547328 bytes (9.5 GiB) are usable", "5.0% of it is reserved"} {
+		if !strings.Contains(note, want) {
+			t.Errorf("note %q lacks %q", note, want)
+		}
+	}
+
+	// With 1% reserved after growing, more is usable than it grew by.
+	after.reserved = 52428
+	if got := after.usableBytes() - before.usableBytes(); got <= 10<<30 {
+		t.Errorf("usable gain with 1%% reserved = %d; want more than 10 GiB", got)
+	}
+}
+
+func TestSetExtReserved(t *testing.T) {
+	defer func(p float64, d bool) { *extReservedPercent, *dry = p, d }(*extReservedPercent, *dry)
+	defer func() { notes = nil }()
+	*extReservedPercent, *dry = 0.5, false
+	ran, restore := fakeCmds(func([]string) ([]byte, error) { return nil, nil })
+	defer restore()
+
+	setExtReserved(fsResizer{fs: fsStat{dev: "/dev/sda1", mnt: "/data", fstype: "ext4"}})
+	if want := [][]string{{"tune2fs", "-m", "0.5", "/dev/sda1"}}; !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %q; want %q", *ran, want)
+	}
+
+	*ran = nil
+	setExtReserved(fsResizer{fs: fsStat{dev: "/dev/sda2", mnt: "/xfs", fstype: "xfs"}})
+	if len(*ran) != 0 {
+		t.Errorf("ran %q for xfs; want nothing", *ran)
+	}
+
+	*dry = true
+	out := captureStdout(t, func() {
+		setExtReserved(fsResizer{fs: fsStat{dev: "/dev/sda1", mnt: "/data", fstype: "ext4"}})
+	})
+	if len(*ran) != 0 || !strings.Contains(out, "[dry-run] would've run tune2fs -m 0.5 /dev/sda1") {
+		t.Errorf("dry run ran %q, printed %q", *ran, out)
+	}
+}
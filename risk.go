@@ -0,0 +1,148 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// riskLevel is how dangerous an operation is, as reported by --preflight.
+type riskLevel int
+
+const (
+	riskLow    riskLevel = iota // e.g. an unmounted data disk
+	riskMedium                  // a mounted filesystem other than the root
+	riskHigh                    // the root filesystem, dm-crypt, or a logical partition in an MBR extended partition
+)
+
+func (r riskLevel) String() string {
+	switch r {
+	case riskLow:
+		return "low"
+	case riskMedium:
+		return "medium"
+	case riskHigh:
+		return "high"
+	}
+	return fmt.Sprintf("riskLevel(%d)", int(r))
+}
+
+// stackFacts are the properties of a storage stack that determine
+// the risk of resizing it.
+type stackFacts struct {
+	mnt          string // mount point of the filesystem, or empty if unmounted
+	cryptDev     string // dm-crypt device in the stack, if any
+	logicalPart  string // MBR logical partition to grow, if any
+	growableDisk string // disk whose partition table is rewritten, if any
+}
+
+// classifyRisk returns the risk level of resizing a stack described
+// by f, along with the reasons for it.
+func classifyRisk(f stackFacts) (level riskLevel, reasons []string) {
+	raise := func(l riskLevel, format string, args ...interface{}) {
+		if l > level {
+			level = l
+		}
+		reasons = append(reasons, fmt.Sprintf(format, args...))
+	}
+	switch f.mnt {
+	case "":
+		raise(riskLow, "filesystem is not mounted")
+	case "/":
+		raise(riskHigh, "filesystem is the mounted root filesystem")
+	default:
+		raise(riskMedium, "filesystem is mounted at %s", f.mnt)
+	}
+	if f.cryptDev != "" {
+		raise(riskHigh, "stack includes dm-crypt device %s", f.cryptDev)
+	}
+	if f.logicalPart != "" {
+		raise(riskHigh, "grows logical partition %s inside an MBR extended partition", f.logicalPart)
+	} else if f.growableDisk != "" {
+		raise(riskLow, "partition table of %s will be rewritten", f.growableDisk)
+	}
+	return level, reasons
+}
+
+// assessRisk returns the risk level of resizing e and the reasons for it.
+func assessRisk(e Resizer) (riskLevel, []string, error) {
+	f, err := gatherStackFacts(e)
+	if err != nil {
+		return 0, nil, err
+	}
+	level, reasons := classifyRisk(f)
+	return level, reasons, nil
+}
+
+// gatherStackFacts walks the chain of Resizers starting at e and
+// reports the facts classifyRisk needs.
+func gatherStackFacts(e Resizer) (f stackFacts, err error) {
+	for r := e; r != nil; {
+		switch r := r.(type) {
+		case fsResizer:
+			f.mnt = r.fs.mnt
+		case lvResizer:
+			if isCryptDev(string(r)) {
+				f.cryptDev = string(r)
+			}
+		case cryptResizer:
+			f.cryptDev = string(r)
+		case partitionResizer:
+			if f.growableDisk, err = diskDev(string(r)); err != nil {
+				return f, err
+			}
+			if logical, err := isLogicalPartition(string(r)); err != nil {
+				return f, err
+			} else if logical {
+				f.logicalPart = string(r)
+			}
+		}
+		if r, err = r.DepResizer(); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// isCryptDev reports whether dev is a device-mapper device
+// managed by dm-crypt.
+func isCryptDev(dev string) bool {
+	return strings.HasPrefix(dmUUID(dev), "CRYPT-")
+}
+
+// isLogicalPartition reports whether partDev is a logical partition
+// within an MBR extended partition. Those are numbered from 5.
+func isLogicalPartition(partDev string) (bool, error) {
+	n, err := strconv.Atoi(partNumRx.FindString(partDev))
+	if err != nil {
+		return false, fmt.Errorf("no partition number in %q", partDev)
+	}
+	if n < 5 || !isPartitionDevName(partDev) {
+		return false, nil
+	}
+	disk, err := diskDev(partDev)
+	if err != nil {
+		return false, err
+	}
+	pt, err := readPartitionTable(disk)
+	if err != nil {
+		return false, err
+	}
+	return pt.Meta("label") == "dos", nil
+}
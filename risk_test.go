@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestClassifyRisk(t *testing.T) {
+	tests := []struct {
+		name        string
+		facts       stackFacts
+		want        riskLevel
+		wantReasons []string
+	}{
+		{
+			name:        "unmounted_data_disk",
+			facts:       stackFacts{growableDisk: "/dev/sdb"},
+			want:        riskLow,
+			wantReasons: []string{"filesystem is not mounted", "partition table of /dev/sdb will be rewritten"},
+		},
+		{
+			name:        "mounted_data",
+			facts:       stackFacts{mnt: "/data", growableDisk: "/dev/sdb"},
+			want:        riskMedium,
+			wantReasons: []string{"filesystem is mounted at /data", "partition table of /dev/sdb will be rewritten"},
+		},
+		{
+			name:        "mounted_root",
+			facts:       stackFacts{mnt: "/", growableDisk: "/dev/sda"},
+			want:        riskHigh,
+			wantReasons: []string{"filesystem is the mounted root filesystem", "partition table of /dev/sda will be rewritten"},
+		},
+		{
+			name:  "crypt",
+			facts: stackFacts{mnt: "/home", cryptDev: "/dev/mapper/sda3_crypt", growableDisk: "/dev/sda"},
+			want:  riskHigh,
+			wantReasons: []string{
+				"filesystem is mounted at /home",
+				"stack includes dm-crypt device /dev/mapper/sda3_crypt",
+				"partition table of /dev/sda will be rewritten",
+			},
+		},
+		{
+			name:        "extended",
+			facts:       stackFacts{mnt: "/srv", growableDisk: "/dev/sda", logicalPart: "/dev/sda5"},
+			want:        riskHigh,
+			wantReasons: []string{"filesystem is mounted at /srv", "grows logical partition /dev/sda5 inside an MBR extended partition"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reasons := classifyRisk(tt.facts)
+			if got != tt.want {
+				t.Errorf("level = %v; want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(reasons, tt.wantReasons) {
+				t.Errorf("reasons = %q; want %q", reasons, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestGatherStackFactsLVMOnLUKS(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sda", "209715200")
+	sys.part("sda", "sda3", "3", "207616000")
+	sys.dm("dm-0", "sda3_crypt", "CRYPT-LUKS2-1234-sda3_crypt", "sda3")
+
+	f, err := gatherStackFacts(pvResizer("/dev/mapper/sda3_crypt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.cryptDev != "/dev/mapper/sda3_crypt" {
+		t.Errorf("cryptDev = %q; want /dev/mapper/sda3_crypt", f.cryptDev)
+	}
+}
+
+func TestGatherStackFactsSfdiskError(t *testing.T) {
+	_, restore := fakeCmds(func([]string) ([]byte, error) { return nil, errors.New("no such device") })
+	defer restore()
+	if _, err := gatherStackFacts(partitionResizer("/dev/sda5")); err == nil {
+		t.Error("gatherStackFacts succeeded; want sfdisk error")
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+var (
+	serveAddr   = flag.String("serve", "", "instead of growing once, serve HTTP on this address (e.g. :8080): GET /healthz, GET /status for what's reclaimable, and POST /grow to grow. Only loopback addresses are allowed without --serve-remote")
+	serveRemote = flag.Bool("serve-remote", false, "allow --serve to listen on addresses other than loopback ones. The API has no authentication unless --serve-token is set")
+	serveToken  = flag.String("serve-token", "", "if set, --serve requires the header \"Authorization: Bearer <token>\" on GET /status and POST /grow")
+)
+
+// serveListenAddr returns the address to listen on for the --serve
+// value addr: on localhost if it names no host. It's an error for it
+// to name a host that's not loopback, unless remote.
+func serveListenAddr(addr string, remote bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("--serve: %v", err)
+	}
+	if host == "" {
+		return net.JoinHostPort("localhost", port), nil
+	}
+	if remote || host == "localhost" {
+		return addr, nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return "", fmt.Errorf("--serve: %s isn't a loopback address; the API has no authentication, so pass --serve-remote to listen on it anyway", host)
+	}
+	return addr, nil
+}
+
+// A server is the --serve HTTP API for growing mnt.
+type server struct {
+	mnt   string
+	opts  Options
+	token string                                                  // required bearer token, if any
+	run   func(mnt string, opts Options) (embiggen.Result, error) // Run, or a fake for tests
+	ready func(mnt string) error                                  // serveReady, or a fake for tests
+
+	mu sync.Mutex // Run uses global state, so only one at a time
+}
+
+// serve serves the HTTP API for growing mnt on addr, until it fails.
+func serve(addr, mnt string, opts Options) error {
+	addr, err := serveListenAddr(addr, *serveRemote)
+	if err != nil {
+		return err
+	}
+	s := &server{mnt: mnt, opts: opts, token: *serveToken, run: Run, ready: serveReady}
+	log.Printf("serving the embiggen-disk API for %s on http://%s/", mnt, addr)
+	return http.ListenAndServe(addr, s.handler())
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := s.ready(s.mnt); err != nil {
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "ok\n")
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.authorized(r) {
+			http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+			return
+		}
+		s.serveResult(w, true)
+	})
+	mux.HandleFunc("/grow", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.authorized(r) {
+			http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+			return
+		}
+		if err := checkSameOrigin(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		s.serveResult(w, false)
+	})
+	return mux
+}
+
+// authorized reports whether r carries the server's bearer token, or
+// the server has none.
+func (s *server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) == 1
+}
+
+// checkSameOrigin returns an error unless r, a POST /grow, could only
+// have come from a client that meant to send it, rather than a web
+// page the user visited. A browser only lets a page set a custom
+// header on a cross-origin request after a CORS preflight, which this
+// server never approves, so the X-Embiggen header is required. A
+// foreign Origin is refused too.
+func checkSameOrigin(r *http.Request) error {
+	if r.Header.Get("X-Embiggen") != "1" {
+		return fmt.Errorf("POST /grow needs the header \"X-Embiggen: 1\"")
+	}
+	if o := r.Header.Get("Origin"); o != "" {
+		if u, err := url.Parse(o); err != nil || u.Host != r.Host {
+			return fmt.Errorf("refusing cross-origin request from %s", o)
+		}
+	}
+	return nil
+}
+
+// serveReady returns an error if growing mnt can't work yet: if sysfs
+// can't be read, or nothing is mounted at mnt.
+func serveReady(mnt string) error {
+	if _, err := ioutil.ReadDir(filepath.Join(sysfsDir, "class", "block")); err != nil {
+		return fmt.Errorf("reading sysfs: %v", err)
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return fmt.Errorf("reading mounts: %v", err)
+	}
+	for _, m := range mounts {
+		if m.mnt == mnt && m.dev != "rootfs" {
+			return nil
+		}
+	}
+	return fmt.Errorf("nothing is mounted at %s", mnt)
+}
+
+// serveResult runs embiggen-disk, only reporting what's reclaimable if
+// reclaimOnly, and writes its result as JSON.
+func (s *server) serveResult(w http.ResponseWriter, reclaimOnly bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer func(v bool) { *reportReclaimable = v }(*reportReclaimable)
+	*reportReclaimable = reclaimOnly
+	res, err := s.run(s.mnt, s.opts)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(res)
+}
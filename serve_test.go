@@ -0,0 +1,209 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+func TestServe(t *testing.T) {
+	var ran []bool // the --report-reclaimable-only setting of each run
+	fail := false
+	var notReady error
+	s := &server{mnt: "/data", ready: func(string) error { return notReady }, run: func(mnt string, opts Options) (embiggen.Result, error) {
+		ran = append(ran, *reportReclaimable)
+		res := embiggen.Result{Version: embiggen.Version, Mount: mnt}
+		if *reportReclaimable {
+			res.ReclaimableBytes = 10 << 30
+			return res, nil
+		}
+		if fail {
+			res.Error = "sfdisk failed"
+			return res, errors.New(res.Error)
+		}
+		res.Changes = []embiggen.Change{{Resizer: "partition /dev/sdb1", Before: "100 sectors", After: "200 sectors"}}
+		return res, nil
+	}}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	grow := map[string]string{"X-Embiggen": "1"}
+	do := func(method, path string, header map[string]string) (int, embiggen.Result, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res embiggen.Result
+		if resp.Header.Get("Content-Type") == "application/json" {
+			if err := json.Unmarshal(body, &res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, res, string(body)
+	}
+
+	if code, _, body := do("GET", "/healthz", nil); code != 200 || body != "ok\n" {
+		t.Errorf("GET /healthz = %d %q; want 200 ok", code, body)
+	}
+	notReady = errors.New("nothing is mounted at /data")
+	if code, _, body := do("GET", "/healthz", nil); code != http.StatusServiceUnavailable || !strings.Contains(body, "nothing is mounted") {
+		t.Errorf("unready GET /healthz = %d %q; want 503 with the reason", code, body)
+	}
+	if code, res, _ := do("GET", "/status", nil); code != 200 || res.ReclaimableBytes != 10<<30 || res.Mount != "/data" {
+		t.Errorf("GET /status = %d %+v; want 200 with 10 GiB reclaimable", code, res)
+	}
+	if code, _, _ := do("GET", "/grow", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /grow = %d; want %d", code, http.StatusMethodNotAllowed)
+	}
+	// A form or fetch from a web page can't set X-Embiggen without a
+	// CORS preflight, so a POST without it may be forged.
+	if code, _, _ := do("POST", "/grow", nil); code != http.StatusForbidden {
+		t.Errorf("POST /grow without X-Embiggen = %d; want %d", code, http.StatusForbidden)
+	}
+	if code, _, _ := do("POST", "/grow", map[string]string{"X-Embiggen": "1", "Origin": "http://evil.example"}); code != http.StatusForbidden {
+		t.Errorf("POST /grow from a foreign origin = %d; want %d", code, http.StatusForbidden)
+	}
+	if code, res, _ := do("POST", "/grow", map[string]string{"X-Embiggen": "1", "Origin": ts.URL}); code != 200 || len(res.Changes) != 1 {
+		t.Errorf("POST /grow from its own origin = %d %+v; want 200 with a change", code, res)
+	}
+	if code, res, _ := do("POST", "/grow", grow); code != 200 || len(res.Changes) != 1 {
+		t.Errorf("POST /grow = %d %+v; want 200 with a change", code, res)
+	}
+	fail = true
+	if code, res, _ := do("POST", "/grow", grow); code != 500 || res.Error != "sfdisk failed" {
+		t.Errorf("failing POST /grow = %d %+v; want 500 with the error", code, res)
+	}
+	if want := []bool{true, false, false, false}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("runs with --report-reclaimable-only = %v; want %v", ran, want)
+	}
+	if *reportReclaimable {
+		t.Error("--report-reclaimable-only left set")
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	runs := 0
+	s := &server{mnt: "/data", token: "sekrit", run: func(mnt string, opts Options) (embiggen.Result, error) {
+		runs++
+		return embiggen.Result{Version: embiggen.Version, Mount: mnt}, nil
+	}}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		method, path, auth string
+		want               int
+	}{
+		{"GET", "/status", "", http.StatusUnauthorized},
+		{"GET", "/status", "Bearer wrong", http.StatusUnauthorized},
+		{"GET", "/status", "Bearer sekrit", 200},
+		{"POST", "/grow", "", http.StatusUnauthorized},
+		{"POST", "/grow", "Bearer sekrit", 200},
+	} {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Embiggen", "1")
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s %s with Authorization %q = %d; want %d", tt.method, tt.path, tt.auth, resp.StatusCode, tt.want)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("ran %d times; want 2, only when authorized", runs)
+	}
+}
+
+func TestServeReady(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	defer func(p string) { procDir = p }(procDir)
+	procDir = filepath.Join(sys.dir, "proc")
+	sys.file("proc/mounts", "rootfs / rootfs rw 0 0\n/dev/sdb1 /data ext4 rw 0 0\n")
+	sys.disk("sdb", "41943040")
+
+	if err := serveReady("/data"); err != nil {
+		t.Errorf("serveReady(/data) = %v; want ready", err)
+	}
+	if err := serveReady("/"); err == nil || !strings.Contains(err.Error(), "nothing is mounted at /") {
+		t.Errorf("serveReady(/) with only rootfs there = %v; want not mounted", err)
+	}
+	sysfsDir = filepath.Join(sys.dir, "nonexistent")
+	if err := serveReady("/data"); err == nil || !strings.Contains(err.Error(), "sysfs") {
+		t.Errorf("serveReady without sysfs = %v; want a sysfs error", err)
+	}
+}
+
+func TestServeListenAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		remote bool
+		want   string // "" for an error
+	}{
+		{":8080", false, "localhost:8080"},
+		{"127.0.0.1:8080", false, "127.0.0.1:8080"},
+		{"[::1]:8080", false, "[::1]:8080"},
+		{"localhost:8080", false, "localhost:8080"},
+		{"0.0.0.0:8080", false, ""},
+		{"10.0.0.5:8080", false, ""},
+		{"0.0.0.0:8080", true, "0.0.0.0:8080"},
+		{"8080", false, ""},
+	}
+	for _, tt := range tests {
+		got, err := serveListenAddr(tt.addr, tt.remote)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("serveListenAddr(%q, %v) = %q; want error", tt.addr, tt.remote, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("serveListenAddr(%q, %v) = %q, %v; want %q", tt.addr, tt.remote, got, err, tt.want)
+		}
+	}
+}
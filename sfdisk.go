@@ -0,0 +1,105 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var minSfdiskVersion = flag.String("min-sfdisk-version", "", "if non-empty, the oldest util-linux version of sfdisk (e.g. 2.26) to modify partition tables with")
+
+// A utilLinuxVersion is a util-linux release version, like 2.37.2.
+type utilLinuxVersion [3]int
+
+var utilLinuxVersionRx = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// parseUtilLinuxVersion parses a version like "2.37.2" or "2.26",
+// ignoring any suffix such as "-rc1".
+func parseUtilLinuxVersion(s string) (v utilLinuxVersion, err error) {
+	m := utilLinuxVersionRx.FindStringSubmatch(s)
+	if m == nil {
+		return v, fmt.Errorf("invalid util-linux version %q", s)
+	}
+	for i := range v {
+		if m[i+1] != "" {
+			v[i], _ = strconv.Atoi(m[i+1])
+		}
+	}
+	return v, nil
+}
+
+func (v utilLinuxVersion) String() string {
+	if v[2] == 0 {
+		return fmt.Sprintf("%d.%d", v[0], v[1])
+	}
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v utilLinuxVersion) less(w utilLinuxVersion) bool {
+	for i := range v {
+		if v[i] != w[i] {
+			return v[i] < w[i]
+		}
+	}
+	return false
+}
+
+var sfdiskVersionRx = regexp.MustCompile(`util-linux(?:-ng)? (\d\S*)`)
+
+// parseSfdiskVersion parses the output of "sfdisk --version", such as
+// "sfdisk from util-linux 2.37.2" or, from old versions,
+// "sfdisk (util-linux-ng 2.17.2)".
+func parseSfdiskVersion(out []byte) (utilLinuxVersion, error) {
+	m := sfdiskVersionRx.FindSubmatch(out)
+	if m == nil {
+		return utilLinuxVersion{}, fmt.Errorf("unrecognized sfdisk --version output %q", strings.TrimSpace(string(out)))
+	}
+	return parseUtilLinuxVersion(string(m[1]))
+}
+
+// checkSfdiskVersion logs sfdisk's version and returns an error if
+// it's older than --min-sfdisk-version.
+func checkSfdiskVersion() error {
+	out, err := cmdOutput(command("/sbin/sfdisk", "--version"))
+	if err != nil {
+		return fmt.Errorf("running sfdisk --version: %v", execErrDetail(err))
+	}
+	v, err := parseSfdiskVersion(out)
+	if err != nil {
+		if *minSfdiskVersion != "" {
+			return err
+		}
+		vlogf("%v", err)
+		return nil
+	}
+	vlogf("using sfdisk from util-linux %v", v)
+	if *minSfdiskVersion == "" {
+		return nil
+	}
+	min, err := parseUtilLinuxVersion(*minSfdiskVersion)
+	if err != nil {
+		return fmt.Errorf("--min-sfdisk-version: %v", err)
+	}
+	if v.less(min) {
+		return fmt.Errorf("sfdisk is from util-linux %v, older than --min-sfdisk-version=%v", v, min)
+	}
+	return nil
+}
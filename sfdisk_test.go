@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestParseSfdiskVersion(t *testing.T) {
+	tests := []struct {
+		out     string
+		want    utilLinuxVersion
+		wantErr bool
+	}{
+		{out: "sfdisk from util-linux 2.37.2\n", want: utilLinuxVersion{2, 37, 2}},
+		{out: "sfdisk from util-linux 2.26\n", want: utilLinuxVersion{2, 26, 0}},
+		{out: "sfdisk from util-linux 2.40-rc1\n", want: utilLinuxVersion{2, 40, 0}},
+		{out: "sfdisk (util-linux-ng 2.17.2)\n", want: utilLinuxVersion{2, 17, 2}},
+		{out: "sfdisk from busybox\n", wantErr: true},
+		{out: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSfdiskVersion([]byte(tt.out))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSfdiskVersion(%q) error = %v; want error: %v", tt.out, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSfdiskVersion(%q) = %v; want %v", tt.out, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSfdiskVersion(t *testing.T) {
+	defer func(v string) { *minSfdiskVersion = v }(*minSfdiskVersion)
+	_, restore := fakeCmds(func([]string) ([]byte, error) {
+		return []byte("sfdisk from util-linux 2.33.1\n"), nil
+	})
+	defer restore()
+	for _, tt := range []struct {
+		min    string
+		wantOK bool
+	}{
+		{"", true},
+		{"2.26", true},
+		{"2.33.1", true},
+		{"2.33.2", false},
+		{"2.34", false},
+		{"3", false}, // invalid
+	} {
+		*minSfdiskVersion = tt.min
+		if err := checkSfdiskVersion(); (err == nil) != tt.wantOK {
+			t.Errorf("--min-sfdisk-version=%q: error = %v; want ok=%v", tt.min, err, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/bradfitz/embiggen-disk/embiggen"
+)
+
+// getUnmountedFSResizer returns a Resizer for the unmounted
+// filesystem on dev, for use with --shrink-to. (ext filesystems
+// can only be shrunk while unmounted.)
+func getUnmountedFSResizer(dev string) (Resizer, error) {
+	dev = canonicalDev(dev)
+	mounted, err := isMountedDev(dev)
+	if err != nil {
+		return nil, err
+	}
+	if mounted {
+		return nil, fmt.Errorf("%s is mounted; pass its mount point instead", dev)
+	}
+	fstype, err := blkidValue(dev, "TYPE")
+	if err != nil {
+		return nil, err
+	}
+	if fstype == "" {
+		return nil, fmt.Errorf("no filesystem found on %s", dev)
+	}
+	return fsResizer{fs: fsStat{dev: dev, fstype: fstype}}, nil
+}
+
+// shrink shrinks the filesystem e to size bytes and then shrinks the
+// partition it's on to match. That's the reverse of the order Resize
+// grows things in: the filesystem must be made smaller before the
+// partition holding it is.
+//
+// Only filesystems directly on a partition are supported.
+func shrink(e Resizer, size int64) (changes []embiggen.Change, err error) {
+	fe, ok := e.(fsResizer)
+	if !ok {
+		return nil, fmt.Errorf("can't shrink %v; only filesystems can be shrunk", e)
+	}
+	dep, err := fe.DepResizer()
+	if err != nil {
+		return nil, err
+	}
+	pr, ok := dep.(partitionResizer)
+	if !ok {
+		return nil, fmt.Errorf("can't shrink %v: it's not directly on a partition, and shrinking LVM isn't supported", fe)
+	}
+	cmds, err := fsShrinkCmds(fe.fs, size)
+	if err != nil {
+		return nil, err
+	}
+	if *confirmShrink != string(pr) {
+		return nil, fmt.Errorf("shrinking %v and %v can destroy data; back it up and pass --confirm-shrink=%s to proceed", fe, pr, string(pr))
+	}
+	if !*dry {
+		// Check before shrinking the filesystem, not just before
+		// writing the partition table after it.
+		if err := checkAllowedHours(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The states are only for reporting.
+	fs0, fsErr := fe.State()
+	part0, partErr := pr.State()
+	for _, cmd := range cmds {
+		if *dry {
+			skipDryRun(cmd)
+			continue
+		}
+		out, err := cmdCombinedOutput(cmd)
+		if err != nil {
+			return nil, fmt.Errorf("running %s: %v, %s", strings.Join(cmd.Args, " "), err, capOutput(cmd.Args, out))
+		}
+		if err := checkOutput(cmd.Args, out); err != nil {
+			return nil, err
+		}
+	}
+	if fsErr == nil {
+		if fs1, err := fe.State(); err == nil && fs1 != fs0 {
+			changes = append(changes, embiggen.Change{Resizer: fe.String(), Before: fs0, After: fs1})
+		}
+	}
+
+	if err := shrinkPartition(string(pr), size); err != nil {
+		return changes, err
+	}
+	if partErr == nil {
+		part1, err := pr.State()
+		if err != nil {
+			return changes, fmt.Errorf("error after successful shrink of %v: %v", pr, err)
+		}
+		if part1 != part0 {
+			changes = append(changes, embiggen.Change{Resizer: pr.String(), Before: part0, After: part1})
+		}
+	}
+	return changes, nil
+}
+
+// fsShrinkCmds returns the commands that shrink fs to size bytes.
+func fsShrinkCmds(fs fsStat, size int64) ([]*exec.Cmd, error) {
+	switch fs.fstype {
+	case "ext2", "ext3", "ext4":
+		if fs.mnt != "" {
+			return nil, fmt.Errorf("%s filesystems can only be shrunk while unmounted; unmount %s and pass %s instead", fs.fstype, fs.mnt, fs.dev)
+		}
+		return []*exec.Cmd{
+			command("e2fsck", "-f", "-p", fs.dev), // resize2fs insists
+			command("resize2fs", fs.dev, fmt.Sprintf("%dK", size>>10)),
+		}, nil
+	case "btrfs":
+		if fs.mnt == "" {
+			return nil, fmt.Errorf("btrfs filesystems can only be resized while mounted; mount %s first", fs.dev)
+		}
+		devid, err := btrfsDevid(fs)
+		if err != nil {
+			return nil, err
+		}
+		return []*exec.Cmd{
+			command("btrfs", "filesystem", "resize", fmt.Sprintf("%s:%d", devid, size), fs.mnt),
+		}, nil
+	case "xfs":
+		return nil, fmt.Errorf("xfs filesystems can't be shrunk")
+	}
+	return nil, fmt.Errorf("shrinking %s filesystems isn't supported", fs.fstype)
+}
+
+// shrinkPartition rewrites the partition table so partDev is just big
+// enough to hold size bytes.
+func shrinkPartition(partDev string, size int64) error {
+	diskDev, err := diskDev(partDev)
+	if err != nil {
+		return err
+	}
+	if err := lockDisk(diskDev); err != nil {
+		return err
+	}
+	if err := checkSfdiskVersion(); err != nil {
+		return err
+	}
+	pt, err := readPartitionTable(diskDev)
+	if err != nil {
+		return err
+	}
+	if _, err := pt.checkedSectorSize(diskDev); err != nil {
+		return err
+	}
+	for _, part := range pt.parts {
+		if part.dev != partDev {
+			continue
+		}
+		sectors := size / part.sectorSize
+		if size%part.sectorSize != 0 {
+			sectors++
+		}
+		if sectors >= part.Size() {
+			return fmt.Errorf("%s is already %d sectors; not shrinking it to %d", partDev, part.Size(), sectors)
+		}
+		if err := part.SetSize(sectors); err != nil {
+			return err
+		}
+		return writePartitionTable(diskDev, pt, part)
+	}
+	return fmt.Errorf("%s not found in partition table of %s", partDev, diskDev)
+}
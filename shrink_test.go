@@ -0,0 +1,132 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShrinkOrder(t *testing.T) {
+	defer func(f func(string, sfdiskLine) error) { blkpgResizePartition = f }(blkpgResizePartition)
+	blkpgResizePartition = func(string, sfdiskLine) error { return nil }
+	defer func(v string) { *confirmShrink = v }(*confirmShrink)
+	defer func(d string) { lockDir = d }(lockDir)
+	td, err := ioutil.TempDir("", "embiggen-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	lockDir = td
+	defer unlockDisks()
+	_, cleanup := newFakeSysfs(t) // no /dev/sdb, so not known to be idle
+	defer cleanup()
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if reflect.DeepEqual(args, []string{"/sbin/sfdisk", "-d", "/dev/sdb"}) {
+			return []byte("label: dos\ndevice: /dev/sdb\nunit: sectors\n\n/dev/sdb1 : start=2048, size=41940992, type=83\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	e := fsResizer{fs: fsStat{dev: "/dev/sdb1", fstype: "ext4"}}
+
+	*confirmShrink = ""
+	if _, err := shrink(e, 10<<30); err == nil || !strings.Contains(err.Error(), "--confirm-shrink=/dev/sdb1") {
+		t.Fatalf("shrink without confirmation = %v; want error asking for --confirm-shrink", err)
+	}
+	if len(*ran) != 0 {
+		t.Fatalf("ran %q without confirmation", *ran)
+	}
+
+	*confirmShrink = "/dev/sdb1"
+	if _, err := shrink(e, 10<<30); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"dumpe2fs", "-h", "/dev/sdb1"}, // for the filesystem's State
+		{"e2fsck", "-f", "-p", "/dev/sdb1"},
+		{"resize2fs", "/dev/sdb1", "10485760K"},
+		{"/sbin/sfdisk", "--version"},
+		{"/sbin/sfdisk", "-d", "/dev/sdb"},
+		{"/sbin/sfdisk", "--no-act", "-f", "--no-reread", "--no-tell-kernel", "/dev/sdb"},
+		{"blkid", "-o", "value", "-s", "PARTUUID", "/dev/sdb1"},
+		{"/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", "/dev/sdb"},
+	}
+	if !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran:\n%q\nwant:\n%q", *ran, want)
+	}
+}
+
+func TestShrinkRefusesXFS(t *testing.T) {
+	_, err := fsShrinkCmds(fsStat{dev: "/dev/sdb1", mnt: "/data", fstype: "xfs"}, 10<<30)
+	if err == nil || !strings.Contains(err.Error(), "can't be shrunk") {
+		t.Errorf("fsShrinkCmds(xfs) error = %v; want refusal", err)
+	}
+	_, err = fsShrinkCmds(fsStat{dev: "/dev/sdb1", mnt: "/data", fstype: "ext4"}, 10<<30)
+	if err == nil || !strings.Contains(err.Error(), "unmounted") {
+		t.Errorf("fsShrinkCmds(mounted ext4) error = %v; want refusal", err)
+	}
+}
+
+// TestShrinkPartition4Kn checks that a partition on a 4Kn disk, whose
+// table old sfdisk prints without a sector-size line, is shrunk in
+// 4096-byte sectors, as the kernel reports them.
+func TestShrinkPartition4Kn(t *testing.T) {
+	defer func(d bool) { *dry = d }(*dry)
+	*dry = true
+	defer func(d string) { lockDir = d }(lockDir)
+	td, err := ioutil.TempDir("", "embiggen-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	lockDir = td
+	defer unlockDisks()
+	defer func() { recording, recorded = false, nil }()
+	recording, recorded = true, nil
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sdb", "83886080")
+	sys.file("block/sdb/queue/logical_block_size", "4096\n")
+	_, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if reflect.DeepEqual(args, []string{"/sbin/sfdisk", "-d", "/dev/sdb"}) {
+			return []byte("label: dos\ndevice: /dev/sdb\nunit: sectors\n\n/dev/sdb1 : start=256, size=10485504, type=83\n"), nil
+		}
+		if reflect.DeepEqual(args, []string{"/sbin/sfdisk", "--version"}) {
+			return []byte("sfdisk from util-linux 2.23\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	if err := shrinkPartition("/dev/sdb1", 10<<30); err != nil {
+		t.Fatal(err)
+	}
+	var table string
+	for _, rc := range recorded {
+		if rc.DryRun && rc.Args[0] == "/sbin/sfdisk" {
+			table = rc.Stdin
+		}
+	}
+	if !strings.Contains(table, "/dev/sdb1 : start=256, size=2621440, type=83") {
+		t.Errorf("new table:\n%s\nwant /dev/sdb1 of 2621440 4096-byte sectors", table)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var simulate = flag.Bool("simulate", false, "like --dry-run, but also write the new partition table to a scratch file the size of the disk, to check it end to end")
+
+// validateTable runs the sfdisk script table against diskDev with
+// --no-act, so sfdisk checks it without writing anything.
+func validateTable(diskDev string, table []byte) error {
+	cmd := command("/sbin/sfdisk", "--no-act", "-f", "--no-reread", "--no-tell-kernel", diskDev)
+	cmd.Stdin = bytes.NewReader(table)
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("sfdisk rejected the new partition table for %s: %v: %s", diskDev, err, capOutput(cmd.Args, bytes.TrimSpace(out)))
+	}
+	return nil
+}
+
+// simulateTable writes the sfdisk script table to a sparse scratch
+// file the same size as diskDev, then removes it.
+func simulateTable(diskDev string, table []byte) error {
+	size, err := devSizeBytes(diskDev)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "embiggen-simulate-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = f.Truncate(size)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	// The script names diskDev's partitions; sfdisk wants the
+	// scratch file's.
+	script := strings.Replace(string(table), diskDev, f.Name(), -1)
+	cmd := command("/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", f.Name())
+	cmd.Stdin = strings.NewReader(script)
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("simulated write of %s's partition table: %v: %s", diskDev, err, capOutput(cmd.Args, bytes.TrimSpace(out)))
+	}
+	fmt.Fprintf(progress(), "[simulate] wrote new partition table for %s to a scratch copy\n", diskDev)
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var strict = flag.Bool("strict", false, "fail if a tool that changes something prints anything but its known-good messages, which might mean a partial success")
+
+// benignOutput are, for each tool that changes something, patterns
+// matching each line of output it prints when all is well. A tool with
+// no entry must print nothing.
+var benignOutput = map[string][]*regexp.Regexp{
+	"resize2fs": regexps(
+		`^resize2fs \d`,
+		`^Filesystem at \S+ is mounted on .*; on-line resizing required$`,
+		`^old_desc_blocks = \d+, new_desc_blocks = \d+$`,
+		`^Resizing the filesystem on \S+ to \d+ \(\w+\) blocks\.$`,
+		`^The filesystem on \S+ is now \d+ \(\w+\) blocks long\.$`,
+		`^The filesystem is already \d+ \(\w+\) blocks long\.\s+Nothing to do!$`,
+	),
+	"e2fsck": regexps(
+		`^\S+: clean, \d+/\d+ files, \d+/\d+ blocks$`,
+		`^\S+: \d+/\d+ files \([\d.]+% non-contiguous\), \d+/\d+ blocks$`,
+	),
+	"xfs_growfs": regexps(
+		`^(meta-data|data|naming|log|realtime)\s*=`,
+		`^\s+=`,
+		`^data blocks changed from \d+ to \d+$`,
+		`^data size unchanged, skipping$`,
+	),
+	"btrfs": regexps(
+		`^Resize device id \d+ \(.*\) from .* to .*$`,
+		`^Resize '.*' of '.*'$`,
+	),
+	"pvresize": regexps(
+		`^Physical volume ".*" changed$`,
+		`^\d+ physical volume\(s\) resized or updated / \d+ physical volume\(s\) not resized$`,
+	),
+	"lvextend": regexps(
+		`^Size of logical volume \S+ changed from .* to .*\.$`,
+		`^Logical volume \S+ successfully resized\.$`,
+		`^Rounding size to boundary between physical extents: .*\.$`,
+	),
+	"bcachefs": regexps(
+		`^Doing (online|offline) resize of \S+$`,
+		`^resizing \S+ to \d+ buckets$`,
+	),
+	"vdo":        nil, // growPhysical prints nothing when it works
+	"partx":      nil, // likewise partx -u
+	"cryptsetup": nil, // and cryptsetup resize
+	"sgdisk": regexps(
+		`^The operation has completed successfully\.$`,
+	),
+	"sfdisk": regexps(
+		`^Checking that no-one is using this disk right now \.\.\. OK$`,
+		`^Disk /dev/\S+: .*$`,
+		`^Disk model: `,
+		`^Units: sectors of `,
+		`^Sector size \(logical/physical\): `,
+		`^I/O size \(minimum/optimal\): `,
+		`^Disklabel type: \w+$`,
+		`^Disk identifier: \S+$`,
+		`^(Old|New) situation:$`,
+		`^>>> (Script header accepted|Created a new .*|Done)\.$`,
+		`^/dev/\S+: (Created a new partition \d+ of type .*|Done\.)$`,
+		`^Device\s+(Boot\s+)?Start\s+End\s+Sectors`,
+		`^/dev/\S+\s+\*?\s*\d+\s+\d+\s+\d+\s`,
+		`^The partition table has been altered\.$`,
+		`^Calling ioctl\(\) to re-read partition table\.$`,
+		`^Syncing disks\.$`,
+	),
+}
+
+func regexps(pats ...string) []*regexp.Regexp {
+	var rxs []*regexp.Regexp
+	for _, p := range pats {
+		rxs = append(rxs, regexp.MustCompile(p))
+	}
+	return rxs
+}
+
+// checkOutput returns an error if --strict is set and out, the output
+// of the command with arguments args, has a line that isn't one of its
+// known-good messages.
+func checkOutput(args []string, out []byte) error {
+	if !*strict {
+		return nil
+	}
+	tool := filepath.Base(args[0])
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || matchesAny(benignOutput[tool], line) {
+			continue
+		}
+		return fmt.Errorf("--strict: unexpected output from %s: %q", strings.Join(args, " "), line)
+	}
+	return nil
+}
+
+func matchesAny(rxs []*regexp.Regexp, s string) bool {
+	for _, rx := range rxs {
+		if rx.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "testing"
+
+func TestCheckOutput(t *testing.T) {
+	defer func(v bool) { *strict = v }(*strict)
+	tests := []struct {
+		args   []string
+		out    string
+		wantOK bool
+	}{
+		{
+			args:   []string{"resize2fs", "/dev/sda1"},
+			out:    "resize2fs 1.46.5 (30-Dec-2021)\nFilesystem at /dev/sda1 is mounted on /; on-line resizing required\nold_desc_blocks = 7, new_desc_blocks = 13\nThe filesystem on /dev/sda1 is now 52428539 (4k) blocks long.\n\n",
+			wantOK: true,
+		},
+		{
+			args:   []string{"resize2fs", "/dev/sda1"},
+			out:    "resize2fs 1.46.5 (30-Dec-2021)\nresize2fs: Permission denied to resize filesystem\n",
+			wantOK: false,
+		},
+		{
+			args:   []string{"pvresize", "/dev/sda3"},
+			out:    "  Physical volume \"/dev/sda3\" changed\n  1 physical volume(s) resized or updated / 0 physical volume(s) not resized\n",
+			wantOK: true,
+		},
+		{
+			args:   []string{"lvextend", "-l", "+100%FREE", "/dev/mapper/vg-root"},
+			out:    "  WARNING: Sum of all thin volume sizes (2.00 TiB) exceeds the size of thin pool vg/pool (1.00 TiB).\n  Logical volume vg/root successfully resized.\n",
+			wantOK: false,
+		},
+		{
+			args:   []string{"bcachefs", "device", "resize", "/dev/sdb1"},
+			out:    "Doing online resize of /dev/sdb1\n",
+			wantOK: true,
+		},
+		{
+			args:   []string{"vdo", "growPhysical", "--name=vdo0"},
+			out:    "vdo: ERROR - Device vdo0 could not be grown\n",
+			wantOK: false,
+		},
+		{
+			args:   []string{"pvresize", "/dev/sda3"},
+			out:    "  WARNING: Device /dev/sda3 has size of 100 sectors which is smaller than corresponding PV size\n  Physical volume \"/dev/sda3\" changed\n",
+			wantOK: false,
+		},
+		{
+			args: []string{"/sbin/sfdisk", "-f", "--no-reread", "--no-tell-kernel", "/dev/sda"},
+			out: `Checking that no-one is using this disk right now ... OK
+
+Disk /dev/sda: 20 GiB, 21474836480 bytes, 41943040 sectors
+Disk model: QEMU HARDDISK
+Units: sectors of 1 * 512 = 512 bytes
+Sector size (logical/physical): 512 bytes / 512 bytes
+I/O size (minimum/optimal): 512 bytes / 512 bytes
+Disklabel type: gpt
+Disk identifier: 5E2D0C6A-3D7B-4C1F-9E0A-2B1A8F0D6C11
+
+Old situation:
+
+Device       Start      End  Sectors Size Type
+/dev/sda1     2048 20969471 20967424  10G Linux filesystem
+
+>>> Script header accepted.
+>>> Created a new GPT disklabel (GUID: 5E2D0C6A-3D7B-4C1F-9E0A-2B1A8F0D6C11).
+/dev/sda1: Created a new partition 1 of type 'Linux filesystem' and of size 20 GiB.
+/dev/sda2: Done.
+
+New situation:
+Disklabel type: gpt
+Disk identifier: 5E2D0C6A-3D7B-4C1F-9E0A-2B1A8F0D6C11
+
+Device       Start      End  Sectors Size Type
+/dev/sda1     2048 41940991 41938944  20G Linux filesystem
+
+The partition table has been altered.
+`,
+			wantOK: true,
+		},
+		{
+			args:   []string{"partx", "-u", "--nr", "3", "/dev/sda"},
+			out:    "partx: /dev/sda: error updating partition 3\n",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		*strict = false
+		if err := checkOutput(tt.args, []byte(tt.out)); err != nil {
+			t.Errorf("%s without --strict: %v", tt.args[0], err)
+		}
+		*strict = true
+		if err := checkOutput(tt.args, []byte(tt.out)); (err == nil) != tt.wantOK {
+			t.Errorf("%s with --strict: error = %v; want ok=%v", tt.args[0], err, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var trim = flag.Bool("trim", false, "after growing a mounted filesystem, run fstrim on it so an SSD or thin-provisioned backend learns the new space is unused")
+
+// trimFSTypes are the filesystem types fstrim can discard the free
+// space of.
+var trimFSTypes = map[string]bool{
+	"ext3":  true,
+	"ext4":  true,
+	"xfs":   true,
+	"btrfs": true,
+}
+
+// trimGrown runs fstrim on the filesystem that e, the top Resizer,
+// grew, and returns how many bytes it trimmed. Filesystems and devices
+// that can't discard are skipped. Failing to trim is only warned
+// about, as the growing is done by then.
+func trimGrown(e Resizer) int64 {
+	fr, ok := e.(fsResizer)
+	if !ok || fr.fs.mnt == "" {
+		notef("--trim: skipping %v, which isn't a mounted filesystem", e)
+		return 0
+	}
+	if !trimFSTypes[fr.fs.fstype] {
+		notef("--trim: skipping %v; fstrim doesn't support %s", e, fr.fs.fstype)
+		return 0
+	}
+	if !canDiscard(fr.fs.dev) {
+		notef("--trim: skipping %v; %s doesn't support discard", e, fr.fs.dev)
+		return 0
+	}
+	cmd := command("fstrim", "-v", fr.fs.mnt)
+	if *dry {
+		skipDryRun(cmd)
+		return 0
+	}
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		warnf("--trim: running %s: %v, %s", strings.Join(cmd.Args, " "), err, capOutput(cmd.Args, out))
+		return 0
+	}
+	n, err := parseFstrim(out)
+	if err != nil {
+		warnf("--trim: %v", err)
+		return 0
+	}
+	notef("--trim: trimmed %d bytes of %v", n, e)
+	return n
+}
+
+// canDiscard reports whether the block device dev, or the disk it's a
+// partition of, supports discard. If that can't be told, it's assumed
+// to, and fstrim will say.
+func canDiscard(dev string) bool {
+	if isPartitionDevName(dev) {
+		disk, err := diskDev(dev)
+		if err != nil {
+			return true
+		}
+		dev = disk
+	}
+	max, err := readInt64File(filepath.Join(sysfsDir, "class", "block", filepath.Base(canonicalDev(dev)), "queue", "discard_max_bytes"))
+	return err != nil || max > 0
+}
+
+var fstrimRx = regexp.MustCompile(`\((\d+) bytes\) trimmed`)
+
+// parseFstrim returns how many bytes "fstrim -v" says it trimmed, from
+// its output, like "/: 10.2 GiB (10952478720 bytes) trimmed".
+func parseFstrim(out []byte) (int64, error) {
+	m := fstrimRx.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("no bytes trimmed in fstrim output %q", out)
+	}
+	return strconv.ParseInt(string(m[1]), 10, 64)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrimGrown(t *testing.T) {
+	defer func() { notes, warnings = nil, nil }()
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sdb", "41943040")
+	sys.part("sdb", "sdb1", "1", "41940992")
+	sys.file("block/sdb/queue/discard_max_bytes", "2147450880\n")
+	sys.disk("sdc", "41943040")
+	sys.part("sdc", "sdc1", "1", "41940992")
+	sys.file("block/sdc/queue/discard_max_bytes", "0\n")
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		return []byte("/data: 10.2 GiB (10952478720 bytes) trimmed\n"), nil
+	})
+	defer restore()
+
+	if n := trimGrown(fsResizer{fs: fsStat{mnt: "/data", dev: "/dev/sdb1", fstype: "ext4"}}); n != 10952478720 {
+		t.Errorf("trimmed %d bytes; want 10952478720", n)
+	}
+	if want := [][]string{{"fstrim", "-v", "/data"}}; !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %q; want %q", *ran, want)
+	}
+
+	for _, fs := range []fsStat{
+		{mnt: "/data", dev: "/dev/sdc1", fstype: "ext4"},     // no discard
+		{mnt: "/boot/efi", dev: "/dev/sdb1", fstype: "vfat"}, // unsupported filesystem
+		{mnt: "", dev: "/dev/sdb1", fstype: "ext4"},          // unmounted
+	} {
+		*ran = nil
+		if n := trimGrown(fsResizer{fs: fs}); n != 0 || len(*ran) != 0 {
+			t.Errorf("trimGrown(%+v) = %d, ran %q; want it skipped", fs, n, *ran)
+		}
+	}
+}
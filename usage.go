@@ -0,0 +1,52 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+var growIfUsedAbove = flag.Float64("grow-if-used-above", 0, "if non-zero, grow only if the filesystem is more than this percentage full, as df counts it, and otherwise skip it, for scheduled jobs that grow disks only when needed")
+
+// usedPercent returns how full the filesystem described by st is, in
+// percent, as df reports it: the space used of that usable by
+// non-root users.
+func usedPercent(st unix.Statfs_t) float64 {
+	used := st.Blocks - st.Bfree
+	if used+st.Bavail == 0 {
+		return 0
+	}
+	return 100 * float64(used) / float64(used+st.Bavail)
+}
+
+// usageSkip returns why e shouldn't be grown because of
+// --grow-if-used-above, or "" if it should be.
+func usageSkip(e Resizer) (string, error) {
+	fe, ok := e.(fsResizer)
+	if !ok || fe.fs.mnt == "" {
+		return "", fmt.Errorf("--grow-if-used-above needs a mounted filesystem; %v isn't one", e)
+	}
+	pct := usedPercent(fe.fs.statfs)
+	if pct > *growIfUsedAbove {
+		vlogf("%v is %.1f%% full, above --grow-if-used-above=%v", e, pct, *growIfUsedAbove)
+		return "", nil
+	}
+	return fmt.Sprintf("%v is %.1f%% full, not above --grow-if-used-above=%v; usage below threshold, skipping", e, pct, *growIfUsedAbove), nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestUsageSkip(t *testing.T) {
+	defer func(v float64) { *growIfUsedAbove = v }(*growIfUsedAbove)
+	*growIfUsedAbove = 85
+
+	// 1000 blocks, 50 reserved for root: 90% used is 855 of the 950
+	// usable by others.
+	fsAt := func(used uint64) fsResizer {
+		return fsResizer{fs: fsStat{mnt: "/data", dev: "/dev/sdb1", fstype: "ext4", statfs: unix.Statfs_t{
+			Blocks: 1000,
+			Bfree:  1000 - used,
+			Bavail: 950 - used,
+		}}}
+	}
+	if got := usedPercent(fsAt(855).fs.statfs); got != 90 {
+		t.Errorf("usedPercent = %v; want 90", got)
+	}
+
+	for _, tt := range []struct {
+		used uint64
+		skip bool
+	}{
+		{used: 855, skip: false}, // 90%
+		{used: 808, skip: false}, // 85.05%
+		{used: 807, skip: true},  // 84.9%
+		{used: 0, skip: true},
+	} {
+		skip, err := usageSkip(fsAt(tt.used))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (skip != "") != tt.skip {
+			t.Errorf("%d blocks used: skip = %q; want skip %v", tt.used, skip, tt.skip)
+		}
+	}
+	if skip, _ := usageSkip(fsAt(0)); !strings.Contains(skip, "0.0% full") || !strings.Contains(skip, "skipping") {
+		t.Errorf("skip message %q; want usage and skipping", skip)
+	}
+	if got := usedPercent(unix.Statfs_t{}); got != 0 {
+		t.Errorf("usedPercent of empty statfs = %v; want 0", got)
+	}
+
+	if _, err := usageSkip(partitionResizer("/dev/sdb1")); err == nil {
+		t.Error("usageSkip of a raw partition succeeded; want error")
+	}
+}
@@ -18,12 +18,96 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
+	"strconv"
+	"strings"
 )
 
 func execErrDetail(err error) string {
 	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
-		return fmt.Sprintf("%v; stderr: %s", err, ee.Stderr)
+		return fmt.Sprintf("%v; stderr: %s", err, capOutput(nil, ee.Stderr))
 	}
 	return err.Error()
 }
+
+// These run external commands, like the exec.Cmd methods of the same
+// names. They're variables so tests can fake the programs being run.
+var (
+	cmdOutput         = (*exec.Cmd).Output
+	cmdCombinedOutput = (*exec.Cmd).CombinedOutput
+	cmdRun            = (*exec.Cmd).Run
+	lookPath          = exec.LookPath
+)
+
+// blkidValue returns the value of tag (such as "TYPE" or "UUID") that
+// blkid reports for dev, or the empty string if it has none.
+func blkidValue(dev, tag string) (string, error) {
+	out, err := cmdOutput(command("blkid", "-o", "value", "-s", tag, dev))
+	if ee, ok := err.(*exec.ExitError); ok && ee.ExitCode() == 2 {
+		return "", nil // blkid exits 2 if nothing was found
+	}
+	if err != nil {
+		return "", fmt.Errorf("running blkid on %s: %v", dev, execErrDetail(err))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// parseSize parses a size in bytes such as "1073741824", "512M" or
+// "20GiB". Suffixes are powers of 1024.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSpace(s), "B"), "i")
+	shift := uint(0)
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGTPE", num[n-1]&^0x20); i != -1 {
+			shift = 10 * uint(i+1)
+			num = num[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > (1<<63-1)>>shift {
+		return 0, fmt.Errorf("size %q too large", s)
+	}
+	return n << shift, nil
+}
+
+// sectorBytes returns the size in bytes of n sectors of sectorSize
+// bytes, or an error if that doesn't fit in an int64.
+func sectorBytes(n, sectorSize int64) (int64, error) {
+	if n < 0 || sectorSize <= 0 || n > (1<<63-1)/sectorSize {
+		return 0, fmt.Errorf("%d sectors of %d bytes is out of range", n, sectorSize)
+	}
+	return n * sectorSize, nil
+}
+
+// humanSectors describes n sectors of sectorSize bytes, like
+// "2048 sectors (1048576 bytes, 0.001 GiB)".
+func humanSectors(n, sectorSize int64) string {
+	gib := float64(n) * float64(sectorSize) / (1 << 30)
+	b, err := sectorBytes(n, sectorSize)
+	if err != nil {
+		return fmt.Sprintf("%d sectors (%0.03f GiB)", n, gib)
+	}
+	return fmt.Sprintf("%d sectors (%d bytes, %0.03f GiB)", n, b, gib)
+}
+
+// containsString reports whether s is in list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// command returns the exec.Cmd to run the named program with args. It
+// runs in the C locale, so output we parse isn't translated.
+func command(name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	return cmd
+}
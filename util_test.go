@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math"
+	"os/exec"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "1073741824", want: 1 << 30},
+		{in: "512M", want: 512 << 20},
+		{in: "20G", want: 20 << 30},
+		{in: "20GiB", want: 20 << 30},
+		{in: "20g", want: 20 << 30},
+		{in: "3T", want: 3 << 40},
+		{in: "1K", want: 1024},
+		{in: "", wantErr: true},
+		{in: "G", wantErr: true},
+		{in: "-5G", wantErr: true},
+		{in: "1.5G", wantErr: true},
+		{in: "9000E", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v; want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d; want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSectorBytes(t *testing.T) {
+	tests := []struct {
+		n, sectorSize int64
+		want          int64
+		wantErr       bool
+	}{
+		{n: 2048, sectorSize: 512, want: 1 << 20},
+		{n: math.MaxInt64 / 512, sectorSize: 512, want: math.MaxInt64 / 512 * 512},
+		{n: math.MaxInt64/512 + 1, sectorSize: 512, wantErr: true},
+		{n: math.MaxInt64 / 4096, sectorSize: 4096, want: math.MaxInt64 / 4096 * 4096},
+		{n: math.MaxInt64/4096 + 1, sectorSize: 4096, wantErr: true},
+		{n: math.MaxInt64, sectorSize: 4096, wantErr: true},
+		{n: -1, sectorSize: 512, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := sectorBytes(tt.n, tt.sectorSize)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("sectorBytes(%d, %d) error = %v; want error: %v", tt.n, tt.sectorSize, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("sectorBytes(%d, %d) = %d; want %d", tt.n, tt.sectorSize, got, tt.want)
+		}
+	}
+}
+
+func TestHumanSectors(t *testing.T) {
+	if got, want := humanSectors(2048, 512), "2048 sectors (1048576 bytes, 0.001 GiB)"; got != want {
+		t.Errorf("humanSectors = %q; want %q", got, want)
+	}
+	if got, want := humanSectors(math.MaxInt64, 4096), "9223372036854775807 sectors (35184372088832.000 GiB)"; got != want {
+		t.Errorf("humanSectors = %q; want %q", got, want)
+	}
+}
+
+func TestDiskSectorsLarge(t *testing.T) {
+	const sysfs = math.MaxInt64 / 2 // would overflow if multiplied by 512 first
+	if got, want := diskSectors(sysfs, 4096), int64(sysfs/8); got != want {
+		t.Errorf("diskSectors(%d, 4096) = %d; want %d", int64(sysfs), got, want)
+	}
+	if got := diskSectors(sysfs, 512); got != sysfs {
+		t.Errorf("diskSectors(%d, 512) = %d; want unchanged", int64(sysfs), got)
+	}
+}
+
+func TestCommandCLocale(t *testing.T) {
+	var env []string
+	_, restore := fakeCmds(func([]string) ([]byte, error) { return nil, nil })
+	defer restore()
+	cmdOutput = func(c *exec.Cmd) ([]byte, error) {
+		env = c.Env
+		return []byte("value\n"), nil
+	}
+	if _, err := blkidValue("/dev/sda1", "TYPE"); err != nil {
+		t.Fatal(err)
+	}
+	if len(env) == 0 || env[len(env)-1] != "LC_ALL=C" {
+		t.Errorf("blkid ran with environment ending %q; want LC_ALL=C last", env)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// vdoResizer grows the physical storage of a VDO (deduplicating and
+// compressing) volume managed by the vdo tool after its backing
+// device grows.
+type vdoResizer string // "/dev/mapper/vdo0"
+
+// isVDODev reports whether dev is a VDO volume.
+func isVDODev(dev string) bool {
+	return strings.HasPrefix(dmUUID(dev), "VDO-")
+}
+
+func (r vdoResizer) name() string { return filepath.Base(string(r)) }
+
+func (r vdoResizer) String() string { return fmt.Sprintf("VDO volume %s", r.name()) }
+
+var vdoPhysicalBlocksRx = regexp.MustCompile(`(?m)^\s*physical blocks\s*:\s*(\d+)\s*$`)
+
+// vdoBlockSize is the size of VDO's physical blocks, in bytes.
+const vdoBlockSize = 4096
+
+func (r vdoResizer) State() (string, error) {
+	n, err := r.physicalBlocks()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("physical blocks=%d", n), nil
+}
+
+// physicalBlocks returns the size of the volume's physical storage,
+// in vdoBlockSize blocks.
+func (r vdoResizer) physicalBlocks() (int64, error) {
+	out, err := cmdOutput(command("vdostats", "--verbose", string(r)))
+	if err != nil {
+		return 0, fmt.Errorf("running vdostats --verbose %s: %v", r, execErrDetail(err))
+	}
+	m := vdoPhysicalBlocksRx.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("no physical blocks in vdostats --verbose %s output", r)
+	}
+	return strconv.ParseInt(string(m[1]), 10, 64)
+}
+
+// backingDev returns the device the VDO volume is stored on.
+func (r vdoResizer) backingDev() (string, error) {
+	name, err := sysBlockName(string(r))
+	if err != nil {
+		return "", err
+	}
+	slaves, _ := ioutil.ReadDir(filepath.Join(sysfsDir, "block", name, "slaves"))
+	if len(slaves) != 1 {
+		return "", fmt.Errorf("%v is on %d devices; want 1", r, len(slaves))
+	}
+	return "/dev/" + slaves[0].Name(), nil
+}
+
+// DepResizer returns the Resizer for the device the VDO volume is
+// stored on: its partition, or nothing for a whole disk.
+func (r vdoResizer) DepResizer() (Resizer, error) {
+	dev, err := r.backingDev()
+	if err != nil {
+		return nil, err
+	}
+	if isPartitionDevName(dev) {
+		return partitionResizer(dev), nil
+	}
+	name := filepath.Base(dev)
+	_, diskErr := os.Stat(filepath.Join(sysfsDir, "block", name))
+	_, dmErr := os.Stat(filepath.Join(sysfsDir, "block", name, "dm"))
+	if diskErr != nil || dmErr == nil || strings.HasPrefix(name, "md") {
+		return nil, fmt.Errorf("don't know how to grow %s, which %v is on", dev, r)
+	}
+	return nil, nil // a whole disk, which the hypervisor grows
+}
+
+func (r vdoResizer) Resize() error {
+	if _, err := lookPath("vdo"); err != nil {
+		return fmt.Errorf("growing %v needs the vdo command from the vdo package: %v", r, err)
+	}
+	cmd := command("vdo", "growPhysical", "--name="+r.name())
+	if *dry {
+		skipDryRun(cmd)
+		return nil
+	}
+	// vdo growPhysical fails if there's nothing to grow into.
+	blocks, err := r.physicalBlocks()
+	if err != nil {
+		return err
+	}
+	dev, err := r.backingDev()
+	if err != nil {
+		return err
+	}
+	size, err := devSizeBytes(dev)
+	if err != nil {
+		return err
+	}
+	if size/vdoBlockSize <= blocks {
+		notef("%v already fills %s; not growing it", r, dev)
+		return nil
+	}
+	out, err := cmdCombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("running %s: %v, %s", strings.Join(cmd.Args, " "), err, capOutput(cmd.Args, out))
+	}
+	return checkOutput(cmd.Args, out)
+}
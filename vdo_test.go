@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestVDOStack(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sdb", "209715200")
+	sys.part("sdb", "sdb1", "1", "104855552")
+	sys.dm("dm-0", "vdo0", "VDO-7b8c1e2a-6a0c-4a8e-9a61-2f1c3b4d5e6f", "sdb1")
+	sys.dm("dm-1", "vg-root", "LVM-abcd", "sdb1")
+
+	if !isVDODev("/dev/mapper/vdo0") {
+		t.Error("vdo0 not detected as VDO")
+	}
+	if isVDODev("/dev/mapper/vg-root") {
+		t.Error("LV detected as VDO")
+	}
+
+	// The filesystem grows after the VDO volume, which grows after
+	// its partition.
+	var chain []string
+	var e Resizer = fsResizer{fs: fsStat{mnt: "/data", dev: "/dev/mapper/vdo0", fstype: "xfs"}}
+	for e != nil {
+		chain = append(chain, e.String())
+		var err error
+		if e, err = e.DepResizer(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"xfs filesystem at /data", "VDO volume vdo0", "partition /dev/sdb1"}
+	if !reflect.DeepEqual(chain, want) {
+		t.Errorf("resizers = %q; want %q", chain, want)
+	}
+
+	defer func(f func(string) (string, error)) { lookPath = f }(lookPath)
+	lookPath = func(string) (string, error) { return "/usr/bin/vdo", nil }
+	defer func() { notes = nil }()
+	ran, restore := fakeCmds(func(args []string) ([]byte, error) {
+		if args[0] == "vdostats" {
+			return []byte("  physical blocks                     : 13106944\n"), nil
+		}
+		return nil, nil
+	})
+	defer restore()
+
+	// The partition hasn't grown: nothing to do.
+	if err := vdoResizer("/dev/mapper/vdo0").Resize(); err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"vdostats", "--verbose", "/dev/mapper/vdo0"}}; !reflect.DeepEqual(*ran, want) {
+		t.Errorf("ran %q; want %q", *ran, want)
+	}
+
+	// Once it has, grow into it.
+	sys.file("block/sdb/sdb1/size", "209713152\n")
+	*ran = nil
+	if err := vdoResizer("/dev/mapper/vdo0").Resize(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"vdo", "growPhysical", "--name=vdo0"}; len(*ran) == 0 || !reflect.DeepEqual((*ran)[len(*ran)-1], want) {
+		t.Errorf("ran %q; want it to end with %q", *ran, want)
+	}
+
+	lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	if err := vdoResizer("/dev/mapper/vdo0").Resize(); err == nil {
+		t.Error("Resize without vdo command: want error")
+	}
+}
+
+func TestVDODepResizer(t *testing.T) {
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	sys.disk("sdc", "209715200")
+	sys.dm("dm-0", "vdo-disk", "VDO-1111", "sdc")
+	sys.disk("sdd", "209715200")
+	sys.dm("dm-1", "vg-lv", "LVM-abcd", "sdd")
+	sys.dm("dm-2", "vdo-lv", "VDO-2222", "dm-1")
+
+	if dep, err := vdoResizer("/dev/mapper/vdo-disk").DepResizer(); err != nil || dep != nil {
+		t.Errorf("VDO on whole disk: DepResizer = %v, %v; want nil, nil", dep, err)
+	}
+	if dep, err := vdoResizer("/dev/mapper/vdo-lv").DepResizer(); err == nil {
+		t.Errorf("VDO on LV: DepResizer = %v; want error", dep)
+	}
+}
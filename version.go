@@ -0,0 +1,66 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+)
+
+var showVersion = flag.Bool("version", false, "print embiggen-disk's version, commit and build date, and exit")
+
+// The build metadata, set when building a release with:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// toolVersion returns the version of this binary: as set at link
+// time, else the module version go install recorded, else "devel".
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		return bi.Main.Version
+	}
+	return "devel"
+}
+
+// versionString describes this binary for --version, such as
+// "embiggen-disk 1.2.3 (commit 0123abc, built 2026-01-02T03:04:05Z)".
+func versionString() string {
+	s := "embiggen-disk " + toolVersion()
+	var meta string
+	if commit != "" {
+		meta = "commit " + commit
+	}
+	if buildDate != "" {
+		if meta != "" {
+			meta += ", "
+		}
+		meta += "built " + buildDate
+	}
+	if meta != "" {
+		s += fmt.Sprintf(" (%s)", meta)
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	defer func(v, c, d string) { version, commit, buildDate = v, c, d }(version, commit, buildDate)
+
+	version, commit, buildDate = "", "", ""
+	if got := versionString(); !strings.HasPrefix(got, "embiggen-disk ") || strings.Contains(got, "(") {
+		t.Errorf("unstamped versionString = %q", got)
+	}
+	version, commit, buildDate = "1.2.3", "0123abc", "2026-01-02T03:04:05Z"
+	if got, want := versionString(), "embiggen-disk 1.2.3 (commit 0123abc, built 2026-01-02T03:04:05Z)"; got != want {
+		t.Errorf("versionString = %q; want %q", got, want)
+	}
+	buildDate = ""
+	if got, want := versionString(), "embiggen-disk 1.2.3 (commit 0123abc)"; got != want {
+		t.Errorf("versionString = %q; want %q", got, want)
+	}
+
+	// The JSON result says which binary did the run.
+	sys, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	res, _ := Run("/", Options{SysfsRoot: sys.dir})
+	js, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(js), `"toolVersion":"1.2.3","toolCommit":"0123abc"`) {
+		t.Errorf("JSON = %s; want toolVersion and toolCommit", js)
+	}
+}
+
+// TestVersionFlag runs main with --version in a child process, which
+// must print the version and exit 0 without running anything.
+func TestVersionFlag(t *testing.T) {
+	if os.Getenv("VERSION_FLAG_TEST_CHILD") == "1" {
+		sysfsDir, procDir = "/nonexistent/sys", "/nonexistent/proc"
+		fakeCmds(func(args []string) ([]byte, error) {
+			fmt.Printf("ran %q\n", args)
+			os.Exit(3)
+			return nil, nil
+		})
+		os.Args = []string{"embiggen-disk", "--version"}
+		main()
+		fmt.Println("main returned")
+		os.Exit(4)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVersionFlag$")
+	cmd.Env = append(os.Environ(), "VERSION_FLAG_TEST_CHILD=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("embiggen-disk --version: %v, %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != versionString() {
+		t.Errorf("embiggen-disk --version printed %q; want %q", got, versionString())
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	wholeDisk        = flag.Bool("whole-disk", false, "expect the LVM PV to be a whole disk with no partition table, and refuse to continue if the disk has partitions")
+	confirmWholeDisk = flag.String("confirm-whole-disk", "", "a whole-disk PV whose disk has a partition table, confirming that the table is stale and the PV should be grown anyway")
+)
+
+// bottomResizer returns the last Resizer in e's chain of dependencies.
+func bottomResizer(e Resizer) (Resizer, error) {
+	for {
+		dep, err := e.DepResizer()
+		if err != nil {
+			return nil, err
+		}
+		if dep == nil {
+			return e, nil
+		}
+		e = dep
+	}
+}
+
+// diskPartitions returns the number of partitions in dev's partition
+// table, which is zero if dev has no partition table at all.
+func diskPartitions(dev string) (int, error) {
+	out, err := cmdOutput(command("/sbin/sfdisk", "-d", dev))
+	if err != nil {
+		if strings.Contains(execErrDetail(err), "does not contain a recognized partition table") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("running sfdisk -d %s: %v", dev, execErrDetail(err))
+	}
+	pt, err := parsePartitionTable(out)
+	if err != nil {
+		return 0, err
+	}
+	return len(pt.parts), nil
+}
+
+// isStackedDev reports whether dev is a device-mapper (such as a
+// dm-crypt mapping) or md device, rather than a disk that could have
+// a partition table.
+func isStackedDev(dev string) bool {
+	name := filepath.Base(dev)
+	return strings.HasPrefix(dev, "/dev/mapper/") || strings.HasPrefix(name, "dm-") ||
+		strings.HasPrefix(name, "md") || dmUUID(dev) != ""
+}
+
+// checkDiskIntent checks that whether e's chain ends in a whole disk
+// or in a partition agrees with --whole-disk. Treating a partitioned
+// disk as one big PV would destroy every partition on it.
+func checkDiskIntent(e Resizer) error {
+	bottom, err := bottomResizer(e)
+	if err != nil {
+		return err
+	}
+	pv, ok := bottom.(pvResizer)
+	if !ok || isPartitionDevName(string(pv)) || isStackedDev(string(pv)) {
+		if *wholeDisk {
+			return fmt.Errorf("--whole-disk was given, but %v is not a PV on a whole disk", bottom)
+		}
+		return nil
+	}
+	dev := string(pv)
+	n, err := diskPartitions(dev)
+	if err != nil {
+		return err
+	}
+	switch {
+	case n > 0 && *confirmWholeDisk != dev:
+		return fmt.Errorf("%s is used as a whole-disk PV but has a partition table with %d partition(s); growing it would destroy them (use --confirm-whole-disk=%s if the table is stale)", dev, n, dev)
+	case n > 0:
+		warnf("%s has a partition table with %d partition(s), ignored because of --confirm-whole-disk", dev, n)
+	case !*wholeDisk:
+		warnf("%s has no partition table; treating it as a whole-disk PV (pass --whole-disk to say so explicitly)", dev)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCheckDiskIntent(t *testing.T) {
+	defer func(w, f bool, c string) { *wholeDisk, *force, *confirmWholeDisk = w, f, c }(*wholeDisk, *force, *confirmWholeDisk)
+	defer func() { warnings = nil }()
+
+	const table = `label: gpt
+device: /dev/sdb
+
+/dev/sdb1 : start=2048, size=1000, type=0FC63DAF-8483-4772-8E79-3D69D8477DE4
+`
+	noTable := &exec.ExitError{Stderr: []byte("sfdisk: /dev/sdb: does not contain a recognized partition table\n")}
+	tests := []struct {
+		name       string
+		bottom     Resizer
+		sfdisk     string // empty means no partition table
+		whole      bool
+		force      bool
+		confirm    string
+		wantErr    string
+		wantWarned bool
+	}{
+		{name: "whole disk with partitions", bottom: pvResizer("/dev/sdb"), sfdisk: table, whole: true, wantErr: "partition table with 1 partition"},
+		{name: "whole disk with partitions, --force", bottom: pvResizer("/dev/sdb"), sfdisk: table, whole: true, force: true, wantErr: "--confirm-whole-disk=/dev/sdb"},
+		{name: "whole disk with partitions, confirmed", bottom: pvResizer("/dev/sdb"), sfdisk: table, whole: true, confirm: "/dev/sdb", wantWarned: true},
+		{name: "whole disk with partitions, other disk confirmed", bottom: pvResizer("/dev/sdb"), sfdisk: table, whole: true, confirm: "/dev/sdc", wantErr: "would destroy"},
+		{name: "whole disk, no table", bottom: pvResizer("/dev/sdb"), whole: true},
+		{name: "whole disk flag on a partition", bottom: pvResizer("/dev/sdb1"), whole: true, wantErr: "not a PV on a whole disk"},
+		{name: "partition mode, no table", bottom: pvResizer("/dev/sdb"), wantWarned: true},
+		{name: "partition mode, partitioned disk", bottom: pvResizer("/dev/sdb"), sfdisk: table, wantErr: "would destroy"},
+		{name: "partition mode on a partition", bottom: &stubResizer{name: "partition /dev/sdb1"}},
+		{name: "NVMe whole disk, no table", bottom: pvResizer("/dev/nvme1n1"), whole: true},
+		{name: "NVMe whole disk, partitioned", bottom: pvResizer("/dev/nvme1n1"), sfdisk: table, wantErr: "would destroy"},
+		{name: "whole disk flag on an NVMe partition", bottom: pvResizer("/dev/nvme1n1p1"), whole: true, wantErr: "not a PV on a whole disk"},
+		{name: "PV on dm-crypt", bottom: pvResizer("/dev/mapper/sdb1_crypt")},
+		{name: "whole disk flag on dm-crypt", bottom: pvResizer("/dev/mapper/sdb1_crypt"), whole: true, wantErr: "not a PV on a whole disk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings = nil
+			*wholeDisk, *force, *confirmWholeDisk = tt.whole, tt.force, tt.confirm
+			_, restore := fakeCmds(func(args []string) ([]byte, error) {
+				if args[0] != "/sbin/sfdisk" {
+					t.Fatalf("unexpected command %q", args)
+				}
+				if tt.sfdisk == "" {
+					return nil, noTable
+				}
+				return []byte(tt.sfdisk), nil
+			})
+			defer restore()
+
+			e := &stubResizer{name: "fs", dep: tt.bottom}
+			err := checkDiskIntent(e)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v; want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if warned := len(warnings) > 0; warned != tt.wantWarned {
+				t.Errorf("warnings = %q; want warning: %v", warnings, tt.wantWarned)
+			}
+		})
+	}
+}
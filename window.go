@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var allowedHours = flag.String("allowed-hours", "", "if non-empty, a maintenance window like 01:00-05:00, in local time, outside which partition tables aren't written; planning and --dry-run work at any time")
+
+// timeNow is time.Now, for tests to fake.
+var timeNow = time.Now
+
+// A window is a daily span of local time, in minutes since midnight.
+// It wraps past midnight if end is before start.
+type window struct {
+	start, end int
+}
+
+// parseWindow parses a --allowed-hours value like "01:00-05:00" or
+// "22:00-02:00".
+func parseWindow(s string) (window, error) {
+	f := strings.Split(s, "-")
+	if len(f) != 2 {
+		return window{}, fmt.Errorf("%q isn't of the form HH:MM-HH:MM", s)
+	}
+	var w window
+	for i, p := range []*int{&w.start, &w.end} {
+		t, err := time.Parse("15:04", strings.TrimSpace(f[i]))
+		if err != nil {
+			return window{}, fmt.Errorf("%q isn't of the form HH:MM-HH:MM", s)
+		}
+		*p = t.Hour()*60 + t.Minute()
+	}
+	if w.start == w.end {
+		return window{}, fmt.Errorf("%q is empty", s)
+	}
+	return w, nil
+}
+
+// contains reports whether the local time of day of t is in w.
+func (w window) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return m >= w.start && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// checkAllowedHours returns an error if it's outside the
+// --allowed-hours maintenance window.
+func checkAllowedHours() error {
+	if *allowedHours == "" {
+		return nil
+	}
+	w, err := parseWindow(*allowedHours)
+	if err != nil {
+		return fmt.Errorf("--allowed-hours: %v", err)
+	}
+	if now := timeNow(); !w.contains(now) {
+		return fmt.Errorf("outside maintenance window: it's %s, and --allowed-hours is %s; not writing the partition table", now.Format("15:04"), *allowedHours)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllowedHours(t *testing.T) {
+	defer func(f func() time.Time, s string) { timeNow, *allowedHours = f, s }(timeNow, *allowedHours)
+	at := func(hhmm string) func() time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", "2018-06-01 "+hhmm, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return func() time.Time { return tm }
+	}
+	tests := []struct {
+		hours string
+		now   string
+		ok    bool
+	}{
+		{"", "14:00", true},
+		{"01:00-05:00", "01:00", true},
+		{"01:00-05:00", "04:59", true},
+		{"01:00-05:00", "05:00", false},
+		{"01:00-05:00", "14:00", false},
+		{"22:00-02:00", "23:30", true},
+		{"22:00-02:00", "01:15", true},
+		{"22:00-02:00", "12:00", false},
+	}
+	for _, tt := range tests {
+		*allowedHours, timeNow = tt.hours, at(tt.now)
+		err := checkAllowedHours()
+		if tt.ok && err != nil {
+			t.Errorf("--allowed-hours=%s at %s: %v; want allowed", tt.hours, tt.now, err)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), "outside maintenance window")) {
+			t.Errorf("--allowed-hours=%s at %s: %v; want outside maintenance window", tt.hours, tt.now, err)
+		}
+	}
+
+	for _, bad := range []string{"1-5", "01:00", "01:00-25:00", "03:00-03:00"} {
+		if _, err := parseWindow(bad); err == nil {
+			t.Errorf("parseWindow(%q) succeeded; want error", bad)
+		}
+	}
+
+	// The window doesn't stop a dry run.
+	defer func(v bool) { *dry = v }(*dry)
+	*dry, *allowedHours, timeNow = true, "01:00-05:00", at("14:00")
+	_, cleanup := newFakeSysfs(t)
+	defer cleanup()
+	_, restore := fakeCmds(func([]string) ([]byte, error) { return nil, nil })
+	defer restore()
+	pt, err := parsePartitionTable([]byte(mbrSample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, _ := pt.lastPartition()
+	if err := writePartitionTable("/dev/sda", pt, part); err != nil {
+		t.Errorf("dry-run writePartitionTable outside the window: %v", err)
+	}
+	*dry = false
+	if err := writePartitionTable("/dev/sda", pt, part); err == nil || !strings.Contains(err.Error(), "outside maintenance window") {
+		t.Errorf("writePartitionTable outside the window = %v; want refusal", err)
+	}
+}